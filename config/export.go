@@ -0,0 +1,12 @@
+package config
+
+import "time"
+
+// Время жизни ссылки на скачивание архива с данными пользователя
+const DataExportTTL = time.Hour * 24
+
+// Максимальное время сборки одного архива
+const DataExportTimeout = time.Minute * 30
+
+// Через сколько после создания незавершенный архив считается брошенным: сборка к этому времени уже прервана
+const StaleDataExportTimeout = DataExportTimeout + time.Minute*5
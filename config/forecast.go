@@ -0,0 +1,4 @@
+package config
+
+// Наибольшее число периодов, на которое можно заказать прогноз
+const MaxForecastHorizon = 365
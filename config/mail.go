@@ -0,0 +1,18 @@
+package config
+
+import "time"
+
+// Настройки SMTP сервера для уведомлений, при пустом SMTPHost письма только пишутся в лог
+const (
+	SMTPHost     = ""
+	SMTPPort     = 587
+	SMTPUsername = ""
+	SMTPPassword = ""
+	MailFrom     = "LinAutoML <noreply@linautoml.local>"
+)
+
+// Адрес сервиса, с которого строятся ссылки в письмах
+const BaseURL = "http://localhost:8080"
+
+// Время ожидания отправки одного письма
+const MailTimeout = time.Second * 30
@@ -0,0 +1,30 @@
+package config
+
+import (
+	"feklistova/models"
+	"time"
+)
+
+// Тарифный план новых пользователей
+const DefaultPlan = "free"
+
+// Ограничения ресурсов для тарифных планов. Отдельным пользователям лимиты
+// можно переопределить в таблице user_quotas
+var Plans = map[string]models.Quota{
+	"free": {
+		MaxStorageBytes:        500 << 20, // 500 MB
+		MaxConcurrentShipments: 1,
+		MaxTrainingMinutes:     120,
+		MaxDatasetBytes:        50 << 20, // 50 MB
+	},
+	"pro": {
+		MaxStorageBytes:        10 << 30, // 10 GB
+		MaxConcurrentShipments: 4,
+		MaxTrainingMinutes:     3000,
+		MaxDatasetBytes:        500 << 20, // 500 MB
+	},
+}
+
+// Запуск, который дольше этого остается в статусе accepted или in progress, считается зависшим:
+// процесс обучения останавливается, запуск помечается failed и больше не занимает квоту
+const StaleShipmentTimeout = time.Hour * 6
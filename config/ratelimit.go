@@ -0,0 +1,30 @@
+package config
+
+import "time"
+
+// Ограничение попыток входа: ключ - IP адрес и почта аккаунта
+const (
+	LoginMaxAttempts = 5
+	LoginBaseDelay   = time.Second
+	LoginMaxDelay    = time.Second * 30
+	LoginLockout     = time.Minute * 15
+	LoginWindow      = time.Hour
+)
+
+// Ограничение регистраций с одного IP адреса
+const (
+	RegisterMaxAttempts = 3
+	RegisterBaseDelay   = time.Second * 10
+	RegisterMaxDelay    = time.Minute
+	RegisterLockout     = time.Hour
+	RegisterWindow      = time.Hour
+)
+
+// Ограничение частоты запусков обучения: ключ - IP адрес и пользователь.
+// Подряд можно запустить ShipmentBurst обучений, затем одно за ShipmentInterval
+const (
+	ShipmentRateLimitEnabled = true
+	ShipmentBurst            = 10
+	ShipmentInterval         = time.Minute * 6
+	ShipmentWindow           = time.Hour
+)
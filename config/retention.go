@@ -0,0 +1,15 @@
+package config
+
+import "time"
+
+// Через сколько после окончания обучения удаляются загруженные датасеты, 0 - хранить бессрочно
+const DatasetRetention = time.Hour * 24 * 30
+
+// Сколько запуск хранится в корзине до окончательного удаления
+const TrashRetention = time.Hour * 24 * 7
+
+// Как часто запускается очистка по срокам хранения
+const RetentionInterval = time.Hour
+
+// Сколько датасетов и запусков удаляется за один проход очистки
+const RetentionBatchSize = 100
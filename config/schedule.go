@@ -0,0 +1,9 @@
+package config
+
+import "time"
+
+// Как часто планировщик проверяет расписания, у которых подошло время запуска
+const SchedulerInterval = time.Minute
+
+// Сколько последних запусков по расписанию показывается на странице проекта
+const ScheduleHistoryLimit = 50
@@ -0,0 +1,30 @@
+package config
+
+import "time"
+
+// Переменная окружения с ключом для подписи CSRF токенов: 32 байта в шестнадцатеричной записи (64 символа)
+const CSRFAuthKeyEnv = "CSRF_AUTH_KEY"
+
+// Файл со случайным ключом, который создается при первом запуске, если переменная окружения не задана
+const CSRFAuthKeyFile = "/root/secrets/csrf_auth_key"
+
+// Переменная окружения с ключом для подписи cookie сессии: 32 байта в шестнадцатеричной записи (64 символа)
+const SessionAuthKeyEnv = "SESSION_AUTH_KEY"
+
+// Файл со случайным ключом сессии, который создается при первом запуске, если переменная окружения не задана
+const SessionAuthKeyFile = "/root/secrets/session_auth_key"
+
+// SecureCookies включает флаг Secure у cookie сессии и CSRF, при работе через HTTPS должен быть true
+const SecureCookies = false
+
+// Require2FA обязывает всех пользователей развертывания подключить двухфакторную аутентификацию
+const Require2FA = false
+
+// TOTPIssuer - название сервиса, которое видит пользователь в приложении-аутентификаторе
+const TOTPIssuer = "LinAutoML"
+
+// Время, за которое пользователь должен ввести код второго фактора после проверки пароля
+const TwoFactorPendingTimeout = time.Minute * 5
+
+// Количество одноразовых кодов восстановления, выдаваемых пользователю
+const RecoveryCodesCount = 10
@@ -0,0 +1,16 @@
+package config
+
+// Настройки проверки качества по умолчанию совпадают с разбиением, которое использовалось до их появления
+const (
+	DefaultValidationStrategy = "holdout"
+	DefaultTestSize           = 0.2
+	DefaultValidationFolds    = 5
+	DefaultValidationSeed     = 42
+)
+
+// Границы настроек проверки качества в форме обучения
+const (
+	MinTestSize        = 0.05
+	MaxTestSize        = 0.5
+	MaxValidationFolds = 20
+)
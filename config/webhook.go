@@ -0,0 +1,27 @@
+package config
+
+import "time"
+
+// Как часто отправляются события вебхуков, ожидающие доставки
+const WebhookInterval = time.Second * 10
+
+// Время ожидания ответа на одну доставку
+const WebhookTimeout = time.Second * 10
+
+// Число попыток доставки, после которого событие помечается недоставленным
+const WebhookMaxAttempts = 8
+
+// Задержка перед первой повторной попыткой, каждая следующая вдвое больше, но не больше WebhookMaxBackoff
+const (
+	WebhookBaseBackoff = time.Second * 30
+	WebhookMaxBackoff  = time.Hour * 2
+)
+
+// Сколько доставок отправляется за один проход
+const WebhookBatchSize = 50
+
+// Разрешить вебхуки на адреса локальной сети и localhost (только для разработки)
+const WebhookAllowPrivateNetworks = false
+
+// Сколько последних доставок показывается на странице проекта
+const WebhookHistoryLimit = 50
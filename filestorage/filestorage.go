@@ -1,40 +1,43 @@
 package filestorage
 
 import (
+	"fmt"
 	"io"
 	"log"
 	"os"
 	"path/filepath"
+	"strings"
 )
 
 type FileStorage struct {
 	UploadsDir   string
 	DownloadsDir string
+	ExportsDir   string
 }
 
-func (fs *FileStorage) NewFileStorage(uploadsDir, downloadsDir string) error {
-	_, err := os.Stat(uploadsDir)
-	if os.IsNotExist(err) {
-		if err := os.MkdirAll(uploadsDir, 0755); err != nil {
+func (fs *FileStorage) NewFileStorage(uploadsDir, downloadsDir, exportsDir string) error {
+	for _, dir := range []string{uploadsDir, downloadsDir, exportsDir} {
+		if err := ensureDir(dir); err != nil {
 			return err
 		}
-		log.Println("Directory", uploadsDir, "created successfully.")
-	} else if err != nil {
-		return err
 	}
 
-	_, err = os.Stat(downloadsDir)
+	fs.UploadsDir = uploadsDir
+	fs.DownloadsDir = downloadsDir
+	fs.ExportsDir = exportsDir
+	return nil
+}
+
+func ensureDir(dir string) error {
+	_, err := os.Stat(dir)
 	if os.IsNotExist(err) {
-		if err := os.MkdirAll(downloadsDir, 0755); err != nil {
+		if err := os.MkdirAll(dir, 0755); err != nil {
 			return err
 		}
-		log.Println("Directory", downloadsDir, "created successfully.")
+		log.Println("Directory", dir, "created successfully.")
 	} else if err != nil {
 		return err
 	}
-
-	fs.UploadsDir = uploadsDir
-	fs.DownloadsDir = downloadsDir
 	return nil
 }
 
@@ -99,3 +102,23 @@ func (fs *FileStorage) GetUploadedFilePath(fileName string) string {
 func (fs *FileStorage) GetDownloadedFilePath(fileName string) string {
 	return filepath.Join(fs.DownloadsDir, fileName)
 }
+
+// CreateExportFile creates an empty file for a data export archive and returns it opened for writing
+func (fs *FileStorage) CreateExportFile(fileName string) (*os.File, error) {
+	filePath := filepath.Join(fs.ExportsDir, fileName)
+	log.Printf("Creating export file %s", filePath)
+	return os.Create(filePath)
+}
+
+// DeleteFileByPath deletes a file by the path stored in the database.
+// Only files inside the storage directories can be deleted.
+func (fs *FileStorage) DeleteFileByPath(filePath string) error {
+	cleanPath := filepath.Clean(filePath)
+	for _, dir := range []string{fs.UploadsDir, fs.DownloadsDir, fs.ExportsDir} {
+		if strings.HasPrefix(cleanPath, filepath.Clean(dir)+string(filepath.Separator)) {
+			log.Printf("Deleting physical file %s", cleanPath)
+			return fs.deleteFile(cleanPath)
+		}
+	}
+	return fmt.Errorf("file %s is outside of the file storage", filePath)
+}
@@ -4,11 +4,15 @@ import "time"
 
 // User представляет собой модель пользователя
 type User struct {
-	ID        int
-	Username  string
-	Email     string
-	Password  string
-	CreatedAt time.Time `json:"created_at"`
+	ID          int
+	Username    string
+	Email       string
+	Password    string
+	TOTPSecret  string    `json:"-"`
+	TOTPEnabled bool      `json:"totp_enabled"`
+	IsAdmin     bool      `json:"is_admin"`
+	Plan        string    `json:"plan"`
+	CreatedAt   time.Time `json:"created_at"`
 }
 
 // Session представляет модель сессии пользователя
@@ -21,24 +25,298 @@ type Session struct {
 	CreatedAt      time.Time `json:"created_at"`
 }
 
-// Shipment представляет модель отправки файла
-type Shipment struct {
-	ShipmentID   int       `json:"shipment_id"`
+// Project представляет модель проекта, объединяющего запуски обучения
+type Project struct {
+	ProjectID           int       `json:"project_id"`
+	UserID              int       `json:"user_id"`
+	Name                string    `json:"name"`
+	Description         string    `json:"description"`
+	DefaultModelType    string    `json:"default_model_type"`
+	DefaultTargetColumn string    `json:"default_target_column"`
+	CreatedAt           time.Time `json:"created_at"`
+}
+
+// Schedule представляет расписание периодического переобучения в проекте
+type Schedule struct {
+	ScheduleID         int       `json:"schedule_id"`
+	UserID             int       `json:"user_id"`
+	ProjectID          int       `json:"project_id"`
+	TemplateShipmentID int       `json:"template_shipment_id"` // 0 - последний успешный запуск проекта
+	CronExpr           string    `json:"cron_expr"`
+	DatasetPath        string    `json:"-"` // пустой путь - датасет запуска-шаблона
+	DatasetName        string    `json:"dataset_name"`
+	DatasetSize        int64     `json:"dataset_size"`
+	Enabled            bool      `json:"enabled"`
+	NextRunAt          time.Time `json:"next_run_at"`
+	CreatedAt          time.Time `json:"created_at"`
+}
+
+// ScheduleRun представляет запись истории запусков по расписанию
+type ScheduleRun struct {
+	RunID          int       `json:"run_id"`
+	ScheduleID     int       `json:"schedule_id"`
+	ShipmentID     int       `json:"shipment_id"`
+	ShipmentStatus string    `json:"shipment_status"`
+	Status         string    `json:"status"`
+	Error          string    `json:"error"`
+	StartedAt      time.Time `json:"started_at"`
+}
+
+// Webhook представляет адрес, на который отправляются события проекта
+type Webhook struct {
+	WebhookID int       `json:"webhook_id"`
+	UserID    int       `json:"user_id"`
+	ProjectID int       `json:"project_id"`
+	URL       string    `json:"url"`
+	Secret    string    `json:"-"` // ключ подписи HMAC-SHA256
+	Events    []string  `json:"events"`
+	Enabled   bool      `json:"enabled"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// WebhookDelivery представляет одну доставку события на вебхук и ее результат
+type WebhookDelivery struct {
+	DeliveryID    int        `json:"delivery_id"`
+	WebhookID     int        `json:"webhook_id"`
+	Event         string     `json:"event"`
+	Payload       string     `json:"payload"`
+	Status        string     `json:"status"`
+	Attempts      int        `json:"attempts"`
+	NextAttemptAt time.Time  `json:"next_attempt_at"`
+	ResponseCode  int        `json:"response_code"` // 0, если ответ не получен
+	Error         string     `json:"error"`
+	CreatedAt     time.Time  `json:"created_at"`
+	DeliveredAt   *time.Time `json:"delivered_at,omitempty"`
+	// URL и Secret берутся из вебхука при отправке
+	URL    string `json:"url"`
+	Secret string `json:"-"`
+}
+
+// RegisteredModel представляет именованную модель в реестре моделей проекта
+type RegisteredModel struct {
+	ModelID     int       `json:"model_id"`
+	UserID      int       `json:"user_id"`
+	ProjectID   int       `json:"project_id"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// ModelVersion представляет версию модели в реестре, полученную из завершенного запуска
+type ModelVersion struct {
+	VersionID   int       `json:"version_id"`
+	ModelID     int       `json:"model_id"`
+	Version     int       `json:"version"`
+	ShipmentID  int       `json:"shipment_id"`
+	ModelFileID int       `json:"model_file_id"`
+	Stage       string    `json:"stage"` // none, staging, production или archived
+	CreatedBy   int       `json:"created_by"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// ModelStageTransition представляет смену стадии версии модели
+type ModelStageTransition struct {
+	TransitionID int       `json:"transition_id"`
+	VersionID    int       `json:"version_id"`
+	Version      int       `json:"version"`
+	FromStage    string    `json:"from_stage"`
+	ToStage      string    `json:"to_stage"`
 	UserID       int       `json:"user_id"`
-	ProjectName  string    `json:"project_name"`
-	ModelType    string    `json:"model_type"`
-	Algorithm    string    `json:"algorithm"`
-	TargetColumn string    `json:"target_column"`
-	Status       string    `json:"status"`
-	Timestamp    time.Time `json:"timestamp"`
+	UserName     string    `json:"user_name"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// Shipment представляет модель отправки файла (запуска обучения в проекте)
+type Shipment struct {
+	ShipmentID      int                 `json:"shipment_id"`
+	UserID          int                 `json:"user_id"`
+	ProjectID       int                 `json:"project_id"`
+	ParentID        int                 `json:"parent_shipment_id"` // запуск, с которого был склонирован этот
+	ProjectName     string              `json:"project_name"`
+	ModelType       string              `json:"model_type"`
+	Algorithm       string              `json:"algorithm"`
+	TargetColumn    string              `json:"target_column"`
+	Hyperparameters map[string]float64  `json:"hyperparameters"`
+	Forecast        *ForecastSettings   `json:"forecast,omitempty"`   // только для прогнозирования временных рядов
+	Validation      *ValidationSettings `json:"validation,omitempty"` // только для классификации и регрессии
+	Columns         map[string]string   `json:"columns"`              // роли столбцов, заданные пользователем: столбец -> роль
+	Note            string              `json:"note"`                 // заметка в формате markdown
+	Tags            []string            `json:"tags"`
+	Status          string              `json:"status"`
+	Timestamp       time.Time           `json:"timestamp"`
+	FinishedAt      *time.Time          `json:"finished_at,omitempty"`
+	DeletedAt       *time.Time          `json:"deleted_at,omitempty"` // время перемещения в корзину
 }
 
 // File представляет модель файла
 type File struct {
-	FileID     int       `json:"file_id"`
-	ShipmentID int       `json:"shipment_id"`
-	FilePath   string    `json:"file_path"`
-	Timestamp  time.Time `json:"timestamp"`
+	FileID       int       `json:"file_id"`
+	ShipmentID   int       `json:"shipment_id"`
+	FilePath     string    `json:"file_path"`
+	OriginalName string    `json:"original_name"` // имя файла, загруженного пользователем
+	Size         int64     `json:"size"`
+	Timestamp    time.Time `json:"timestamp"`
+}
+
+// ClassificationReport содержит подробную оценку классификатора на тестовой выборке
+type ClassificationReport struct {
+	Classes         []ClassMetrics `json:"classes"`
+	ConfusionMatrix [][]int        `json:"confusion_matrix"` // строки - истинные классы, столбцы - предсказанные, в порядке Classes
+}
+
+// ClassMetrics содержит метрики одного класса
+type ClassMetrics struct {
+	Label     string  `json:"label"`
+	Precision float64 `json:"precision"`
+	Recall    float64 `json:"recall"`
+	F1Score   float64 `json:"f1_score"`
+	Support   int     `json:"support"`
+}
+
+// ModelDetails содержит подробные результаты обучения помимо метрик, каждое поле заполняется только для подходящих типов задач
+type ModelDetails struct {
+	Report    *ClassificationReport
+	Forecast  *Forecast
+	Schema    []DatasetColumn
+	MetricStd map[string]float64 // стандартное отклонение метрик по фолдам кросс-валидации
+}
+
+// DatasetColumn описывает, как столбец датасета использовался при обучении
+type DatasetColumn struct {
+	Name     string `json:"name"`
+	Type     string `json:"type"`     // numeric, categorical, text, datetime, id, weight, ignored или target
+	Detected bool   `json:"detected"` // тип определен автоматически, а не указан пользователем
+}
+
+// ValidationSettings задает, как оценивается качество модели классификации или регрессии.
+// Запуски без настроек проверялись на отложенных 20% строк с seed 42.
+type ValidationSettings struct {
+	Strategy    string  `json:"strategy"`               // holdout, kfold, stratified_kfold, group_kfold или time_series
+	TestSize    float64 `json:"test_size,omitempty"`    // доля отложенной выборки, только для holdout
+	Folds       int     `json:"folds,omitempty"`        // число фолдов кросс-валидации
+	GroupColumn string  `json:"group_column,omitempty"` // столбец групп, только для group_kfold
+	TimeColumn  string  `json:"time_column,omitempty"`  // порядок строк для time_series, пустой - порядок в файле
+	Seed        int     `json:"seed"`
+}
+
+// ForecastSettings содержит параметры запуска прогнозирования временных рядов
+type ForecastSettings struct {
+	TimeColumn   string `json:"time_column"`
+	SeriesColumn string `json:"series_column,omitempty"` // пустой, если в датасете один ряд
+	Horizon      int    `json:"horizon"`                 // число периодов прогноза
+}
+
+// Forecast содержит конец истории и прогноз рядов для графика на странице результатов
+type Forecast struct {
+	Series []ForecastSeries `json:"series"`
+}
+
+// ForecastSeries содержит историю и прогноз одного ряда
+type ForecastSeries struct {
+	SeriesID    string          `json:"series_id"` // пустой, если в датасете один ряд
+	History     []ForecastPoint `json:"history"`
+	Predictions []ForecastPoint `json:"predictions"`
+	Naive       bool            `json:"naive,omitempty"` // ряд короче n_lags, построен сезонный наивный прогноз
+}
+
+// ForecastPoint - значение ряда за период
+type ForecastPoint struct {
+	Period string  `json:"period"`
+	Value  float64 `json:"value"`
+}
+
+// NotificationPreferences описывает, о каких завершениях запусков пользователь получает письма
+type NotificationPreferences struct {
+	UserID   int  `json:"-"`
+	Finished bool `json:"finished"`
+	Failed   bool `json:"failed"`
+	Denied   bool `json:"denied"`
+}
+
+// Wants reports whether the user asked to be emailed when a shipment reaches the status
+func (p NotificationPreferences) Wants(status string) bool {
+	switch status {
+	case "finished":
+		return p.Finished
+	case "failed":
+		return p.Failed
+	case "denied":
+		return p.Denied
+	}
+	return false
+}
+
+// Quota представляет ограничения ресурсов пользователя, 0 означает отсутствие ограничения
+type Quota struct {
+	MaxStorageBytes        int64 `json:"max_storage_bytes"`
+	MaxConcurrentShipments int   `json:"max_concurrent_shipments"`
+	MaxTrainingMinutes     int   `json:"max_training_minutes"`
+	MaxDatasetBytes        int64 `json:"max_dataset_bytes"`
+}
+
+// QuotaUsage представляет использованные пользователем ресурсы
+type QuotaUsage struct {
+	StoredBytes      int64   `json:"stored_bytes"`
+	RunningShipments int     `json:"running_shipments"`
+	TrainingMinutes  float64 `json:"training_minutes"` // за текущий месяц
+}
+
+// DataExport представляет модель архива с данными пользователя
+type DataExport struct {
+	ExportID  int       `json:"export_id"`
+	UserID    int       `json:"user_id"`
+	Token     string    `json:"-"`
+	Status    string    `json:"status"`
+	FilePath  string    `json:"-"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// AuditEvent представляет запись журнала событий безопасности
+type AuditEvent struct {
+	EventID   int64     `json:"event_id"`
+	ActorID   int       `json:"actor_id"` // 0 если действие выполнено неавторизованным пользователем
+	Action    string    `json:"action"`
+	Target    string    `json:"target"`
+	IPAddress string    `json:"ip_address"`
+	UserAgent string    `json:"user_agent"`
+	Outcome   string    `json:"outcome"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// AuditFilter описывает условия выборки из журнала событий
+type AuditFilter struct {
+	ActorID int
+	Action  string
+	Target  string
+	Outcome string
+	IP      string
+	From    time.Time
+	To      time.Time
+	Limit   int
+	Offset  int
+}
+
+// ShipmentFilter описывает условия поиска по запускам пользователя, пустые поля не ограничивают выборку
+type ShipmentFilter struct {
+	UserID      int
+	ProjectName string
+	Algorithm   string
+	ModelType   string
+	Status      string
+	Tags        []string // запуск должен иметь все теги
+	From        time.Time
+	To          time.Time
+	Metrics     []MetricThreshold
+	Limit       int
+	Offset      int
+}
+
+// MetricThreshold описывает условие на значение метрики, например F1-score >= 0.8
+type MetricThreshold struct {
+	Name     string
+	Operator string // >=, <=, > или <
+	Value    float64
 }
 
 type ModelMetrics struct {
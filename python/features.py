@@ -0,0 +1,148 @@
+"""Feature preparation shared by the scripts that learn from table columns."""
+import json
+
+import pandas as pd
+from sklearn.pipeline import Pipeline
+from sklearn.compose import ColumnTransformer
+from sklearn.impute import SimpleImputer
+from sklearn.preprocessing import OneHotEncoder, FunctionTransformer
+from sklearn.feature_extraction.text import TfidfVectorizer
+
+# an object column is free text when its values have several words and rarely repeat
+TEXT_MIN_MEAN_WORDS = 4
+TEXT_MIN_UNIQUE_SHARE = 0.5
+# vocabulary of every text column is limited, so that long texts do not run out of memory
+TEXT_MAX_FEATURES = 5000
+TEXT_NGRAM_RANGE = (1, 2)
+
+# column types for the roles of the column spec
+ROLE_TYPES = {
+    "ignore": "ignored",
+    "id": "id",
+    "categorical": "categorical",
+    "numeric": "numeric",
+    "text": "text",
+    "datetime": "datetime",
+    "weight": "weight",
+}
+
+
+def is_text_column(values):
+    values = values.dropna().astype(str)
+    if values.empty:
+        return False
+    mean_words = values.str.split().str.len().mean()
+    unique_share = values.nunique() / len(values)
+    return mean_words >= TEXT_MIN_MEAN_WORDS and unique_share >= TEXT_MIN_UNIQUE_SHARE
+
+
+def column_types(X, columns=None):
+    """Returns the type of every column and whether it was detected automatically.
+    columns maps column names to the roles the user set, the rest are detected from the values."""
+    columns = columns or {}
+    unknown = set(columns) - set(X.columns)
+    if unknown:
+        raise ValueError("Unknown columns in the column spec: %s" % ", ".join(sorted(unknown)))
+
+    types = {}
+    for column in X.columns:
+        if column in columns:
+            types[column] = (ROLE_TYPES[columns[column]], False)
+        elif X[column].dtype == object:
+            types[column] = ("text" if is_text_column(X[column]) else "categorical", True)
+        elif X[column].dtype.kind in "iuf":
+            types[column] = ("numeric", True)
+        elif X[column].dtype.kind == "M":
+            types[column] = ("datetime", True)
+        else:
+            types[column] = ("ignored", True)
+    return types
+
+
+def columns_of_type(types, kind):
+    return [column for column, (column_kind, _) in types.items() if column_kind == kind]
+
+
+def sample_weight(X, types):
+    """Values of the weight column, None when the spec has no weight. Rows without a weight count once."""
+    weights = columns_of_type(types, "weight")
+    if not weights:
+        return None
+    return pd.to_numeric(X[weights[0]]).fillna(1.0).to_numpy()
+
+
+def to_text(values):
+    # TfidfVectorizer expects one string per row
+    return values.fillna("").astype(str)
+
+
+def datetime_parts(frame):
+    """Replaces every date column with its year, month, day and day of week."""
+    parts = {}
+    for column in frame.columns:
+        values = pd.to_datetime(frame[column], errors="coerce")
+        parts[column + "_year"] = values.dt.year
+        parts[column + "_month"] = values.dt.month
+        parts[column + "_day"] = values.dt.day
+        parts[column + "_dayofweek"] = values.dt.dayofweek
+    return pd.DataFrame(parts, index=frame.index)
+
+
+def text_transformer(max_features=TEXT_MAX_FEATURES, ngram_range=TEXT_NGRAM_RANGE):
+    return Pipeline(
+        steps=[
+            ("fill", FunctionTransformer(to_text)),
+            ("tfidf", TfidfVectorizer(ngram_range=ngram_range, max_features=max_features, sublinear_tf=True)),
+        ]
+    )
+
+
+def build_preprocessor(types, numeric_transformer, text_options=None):
+    """Numeric columns go through numeric_transformer, categorical ones are one-hot encoded,
+    dates are split into their parts and every text column gets its own TF-IDF vocabulary.
+    Ignored, ID and weight columns are not features and are dropped."""
+    text_options = text_options or {}
+
+    categorical_transformer = Pipeline(
+        steps=[
+            ("imputer", SimpleImputer(strategy="most_frequent")),
+            ("onehot", OneHotEncoder(handle_unknown="ignore")),
+        ]
+    )
+
+    datetime_transformer = Pipeline(
+        steps=[
+            ("parts", FunctionTransformer(datetime_parts)),
+            ("imputer", SimpleImputer(strategy="most_frequent")),
+        ]
+    )
+
+    transformers = [
+        ("num", numeric_transformer, columns_of_type(types, "numeric")),
+        ("cat", categorical_transformer, columns_of_type(types, "categorical")),
+        ("date", datetime_transformer, columns_of_type(types, "datetime")),
+    ]
+    for i, column in enumerate(columns_of_type(types, "text")):
+        # a single column name, not a list, passes the values to the vectorizer as one dimension
+        transformers.append(("text_%d" % i, text_transformer(**text_options), column))
+    return ColumnTransformer(transformers=transformers)
+
+
+def longest_text_column(X):
+    """The object column with the longest values, the text of a dataset that has no obvious text column."""
+    candidates = X.select_dtypes(include=["object"]).columns
+    if len(candidates) == 0:
+        raise ValueError("The dataset has no text columns.")
+    return max(candidates, key=lambda column: X[column].dropna().astype(str).str.len().mean())
+
+
+def print_schema(types, target_column=None):
+    """Prints the dataset schema on a single line, the server parses it as JSON."""
+    schema = []
+    if target_column:
+        schema.append({"name": target_column, "type": "target", "detected": False})
+    schema += [
+        {"name": column, "type": kind, "detected": detected}
+        for column, (kind, detected) in types.items()
+    ]
+    print("Schema:", json.dumps(schema, ensure_ascii=False))
@@ -0,0 +1,142 @@
+package python
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Hyperparameter описывает гиперпараметр алгоритма, который пользователь может задать в форме
+type Hyperparameter struct {
+	Name    string
+	Title   string
+	Integer bool
+	Min     float64
+	Max     float64
+}
+
+// Алгоритмы, доступные для каждого типа модели, в порядке отображения в форме
+var modelAlgorithms = map[string][]string{
+	"class":    {"Логистическая регрессия", "Случайный лес", "Текстовая классификация (TF-IDF)"},
+	"reg":      {"Линейная регрессия", "Метод опорных векторов"},
+	"cluster":  {"K-средних", "DBSCAN", "Агломеративная кластеризация"},
+	"forecast": {"Сезонная наивная модель", "Линейная модель по лагам", "Градиентный бустинг по лагам"},
+	"anomaly":  {"Изолирующий лес", "Локальный уровень выброса", "Одноклассовый метод опорных векторов"},
+}
+
+var algorithmMapping = map[string]string{
+	"Логистическая регрессия":              "logistic_regression",
+	"Текстовая классификация (TF-IDF)":     "text_logistic_regression",
+	"Случайный лес":                        "random_forest",
+	"Линейная регрессия":                   "linear_regression",
+	"Метод опорных векторов":               "support_vector_machine",
+	"K-средних":                            "kmeans",
+	"DBSCAN":                               "dbscan",
+	"Агломеративная кластеризация":         "agglomerative",
+	"Сезонная наивная модель":              "seasonal_naive",
+	"Линейная модель по лагам":             "lag_linear",
+	"Градиентный бустинг по лагам":         "lag_gradient_boosting",
+	"Изолирующий лес":                      "isolation_forest",
+	"Локальный уровень выброса":            "local_outlier_factor",
+	"Одноклассовый метод опорных векторов": "one_class_svm",
+}
+
+// Гиперпараметры, которые скрипты передают в конструктор модели sklearn
+var algorithmHyperparameters = map[string][]Hyperparameter{
+	"logistic_regression": {
+		{Name: "C", Title: "Коэффициент регуляризации C", Min: 0.0001, Max: 10000},
+		{Name: "max_iter", Title: "Максимум итераций", Integer: true, Min: 10, Max: 10000},
+	},
+	"random_forest": {
+		{Name: "n_estimators", Title: "Число деревьев", Integer: true, Min: 1, Max: 1000},
+		{Name: "max_depth", Title: "Максимальная глубина", Integer: true, Min: 1, Max: 100},
+		{Name: "min_samples_split", Title: "Минимум объектов для разбиения", Integer: true, Min: 2, Max: 1000},
+	},
+	// пресет для датасетов из одного текстового столбца и метки
+	"text_logistic_regression": {
+		{Name: "C", Title: "Коэффициент регуляризации C", Min: 0.0001, Max: 10000},
+		{Name: "max_features", Title: "Размер словаря", Integer: true, Min: 100, Max: 200000},
+		{Name: "ngram_max", Title: "Наибольшая длина n-граммы", Integer: true, Min: 1, Max: 3},
+	},
+	"linear_regression": {},
+	"support_vector_machine": {
+		{Name: "C", Title: "Коэффициент регуляризации C", Min: 0.0001, Max: 10000},
+		{Name: "epsilon", Title: "Эпсилон", Min: 0, Max: 100},
+	},
+	// без n_clusters число кластеров подбирается по коэффициенту силуэта
+	"kmeans": {
+		{Name: "n_clusters", Title: "Число кластеров", Integer: true, Min: 2, Max: 100},
+	},
+	"dbscan": {
+		{Name: "eps", Title: "Радиус окрестности eps", Min: 0.0001, Max: 10000},
+		{Name: "min_samples", Title: "Минимум объектов в окрестности", Integer: true, Min: 1, Max: 1000},
+	},
+	"agglomerative": {
+		{Name: "n_clusters", Title: "Число кластеров", Integer: true, Min: 2, Max: 100},
+	},
+	// season_length также задает масштаб MASE, n_lags - сколько прошлых периодов видит модель
+	"seasonal_naive": {
+		{Name: "season_length", Title: "Длина сезона", Integer: true, Min: 1, Max: 366},
+	},
+	"lag_linear": {
+		{Name: "n_lags", Title: "Число лагов", Integer: true, Min: 1, Max: 366},
+		{Name: "season_length", Title: "Длина сезона", Integer: true, Min: 1, Max: 366},
+	},
+	"lag_gradient_boosting": {
+		{Name: "n_lags", Title: "Число лагов", Integer: true, Min: 1, Max: 366},
+		{Name: "season_length", Title: "Длина сезона", Integer: true, Min: 1, Max: 366},
+		{Name: "n_estimators", Title: "Число деревьев", Integer: true, Min: 1, Max: 1000},
+		{Name: "max_depth", Title: "Максимальная глубина", Integer: true, Min: 1, Max: 100},
+	},
+	// contamination - ожидаемая доля аномалий, по ней выбирается порог флага
+	"isolation_forest": {
+		{Name: "contamination", Title: "Доля аномалий", Min: 0.0001, Max: 0.5},
+		{Name: "n_estimators", Title: "Число деревьев", Integer: true, Min: 1, Max: 1000},
+	},
+	"local_outlier_factor": {
+		{Name: "contamination", Title: "Доля аномалий", Min: 0.0001, Max: 0.5},
+		{Name: "n_neighbors", Title: "Число соседей", Integer: true, Min: 1, Max: 1000},
+	},
+	"one_class_svm": {
+		{Name: "contamination", Title: "Доля аномалий", Min: 0.0001, Max: 0.5},
+	},
+}
+
+// Algorithms returns the algorithm titles available for the model type
+func Algorithms(modelType string) []string {
+	return modelAlgorithms[modelType]
+}
+
+// AlgorithmHyperparameters returns the hyperparameters the algorithm accepts
+func AlgorithmHyperparameters(algorithm string) []Hyperparameter {
+	return algorithmHyperparameters[algorithmMapping[algorithm]]
+}
+
+// ParseHyperparameters validates the hyperparameter values entered for the algorithm.
+// Empty values are skipped so that the sklearn defaults are used.
+func ParseHyperparameters(algorithm string, value func(name string) string) (map[string]float64, error) {
+	if _, ok := algorithmMapping[algorithm]; !ok {
+		return nil, fmt.Errorf("unsupported algorithm type: %s", algorithm)
+	}
+
+	params := make(map[string]float64)
+	for _, param := range AlgorithmHyperparameters(algorithm) {
+		raw := strings.TrimSpace(value(param.Name))
+		if raw == "" {
+			continue
+		}
+		number, err := strconv.ParseFloat(strings.Replace(raw, ",", ".", 1), 64)
+		if err != nil {
+			return nil, fmt.Errorf("hyperparameter %s must be a number", param.Name)
+		}
+		if param.Integer && number != math.Trunc(number) {
+			return nil, fmt.Errorf("hyperparameter %s must be an integer", param.Name)
+		}
+		if number < param.Min || number > param.Max {
+			return nil, fmt.Errorf("hyperparameter %s must be between %g and %g", param.Name, param.Min, param.Max)
+		}
+		params[param.Name] = number
+	}
+	return params, nil
+}
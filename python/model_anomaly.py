@@ -0,0 +1,142 @@
+import pandas as pd
+import numpy as np
+from sklearn.pipeline import Pipeline
+from sklearn.impute import SimpleImputer
+from sklearn.preprocessing import StandardScaler
+from sklearn.ensemble import IsolationForest
+from sklearn.neighbors import LocalOutlierFactor
+from sklearn.svm import OneClassSVM
+from sklearn.metrics import precision_score, recall_score, f1_score, roc_auc_score, average_precision_score
+import json
+import sys
+
+from features import build_preprocessor, column_types, print_schema
+
+# expected share of anomalies when contamination is not set
+DEFAULT_CONTAMINATION = 0.05
+# label values, besides non-zero numbers, that mark a row as an anomaly
+ANOMALY_LABELS = {"1", "true", "yes", "anomaly", "outlier", "fraud"}
+
+
+def load_data(file_path):
+    if file_path.endswith(".csv"):
+        return pd.read_csv(file_path)
+    elif file_path.endswith(".xls") or file_path.endswith(".xlsx"):
+        return pd.read_excel(file_path)
+    elif file_path.endswith(".pkl"):
+        return pd.read_pickle(file_path)
+    else:
+        raise ValueError(
+            "Unsupported file type. Supported types are csv, xls, xlsx, and pkl."
+        )
+
+
+def prepare_features(features, label_column, columns):
+    types = column_types(features, columns)
+    print_schema(types, label_column)
+
+    # the detectors are distance and split based, so numeric features are brought to one scale
+    numeric_transformer = Pipeline(
+        steps=[
+            ("imputer", SimpleImputer(strategy="mean")),
+            ("scaler", StandardScaler()),
+        ]
+    )
+    return build_preprocessor(types, numeric_transformer).fit_transform(features)
+
+
+def anomaly_scores(X, algorithm, hyperparameters):
+    """Returns the anomaly score of every row, higher scores are more unusual, and the flags."""
+    params = dict(hyperparameters)
+    contamination = params.pop("contamination", DEFAULT_CONTAMINATION)
+
+    if algorithm == "isolation_forest":
+        model = IsolationForest(contamination=contamination, random_state=42, **params)
+        flags = model.fit_predict(X)
+        scores = -model.score_samples(X)
+    elif algorithm == "local_outlier_factor":
+        model = LocalOutlierFactor(contamination=contamination, **params)
+        flags = model.fit_predict(X)
+        scores = -model.negative_outlier_factor_
+    elif algorithm == "one_class_svm":
+        # nu bounds the share of training rows outside the boundary, it plays the role of contamination
+        model = OneClassSVM(nu=contamination, **params)
+        flags = model.fit_predict(X)
+        scores = -model.decision_function(X)
+    else:
+        raise ValueError(
+            "Unsupported algorithm. Choose 'isolation_forest', 'local_outlier_factor' or 'one_class_svm'."
+        )
+
+    # sklearn marks anomalies with -1
+    return scores, flags == -1
+
+
+def parse_labels(labels):
+    if pd.api.types.is_numeric_dtype(labels):
+        return labels.fillna(0).to_numpy() != 0
+    return labels.astype(str).str.strip().str.lower().isin(ANOMALY_LABELS).to_numpy()
+
+
+def report_metrics(is_anomaly, scores, labels):
+    print(f"Anomalies: {is_anomaly.sum()}")
+    print(f"Anomaly Share: {is_anomaly.mean():.4f}")
+    if labels is None:
+        return
+
+    actual = parse_labels(labels)
+    print(f"Precision: {precision_score(actual, is_anomaly, zero_division=0):.4f}")
+    print(f"Recall: {recall_score(actual, is_anomaly, zero_division=0):.4f}")
+    print(f"F1-score: {f1_score(actual, is_anomaly, zero_division=0):.4f}")
+    # ranking metrics need both normal and anomalous rows among the labels
+    if 0 < actual.sum() < len(actual):
+        print(f"ROC AUC: {roc_auc_score(actual, scores):.4f}")
+        print(f"Average Precision: {average_precision_score(actual, scores):.4f}")
+
+
+def detect(data, label_column, model_path, algorithm="isolation_forest", hyperparameters=None, columns=None):
+    labels = None
+    features = data
+    if label_column:
+        # labels are only used to evaluate the detector, it never sees them
+        labels = data[label_column]
+        features = data.drop(columns=[label_column])
+
+    X = prepare_features(features, label_column, columns)
+    scores, is_anomaly = anomaly_scores(X, algorithm, hyperparameters or {})
+    report_metrics(is_anomaly, scores, labels)
+
+    data.assign(anomaly_score=scores, is_anomaly=is_anomaly.astype(int)).to_csv(model_path, index=False)
+
+    return is_anomaly
+
+
+def main():
+    if len(sys.argv) not in (5, 6, 7):
+        print(
+            "Usage: python %s <algorithm> <label_column> <input_file_path> <output_file_path> [<hyperparameters_json> [<options_json>]]"
+            % sys.argv[0]
+        )
+        sys.exit(1)
+
+    # the label column is optional, an empty argument means the dataset has no labels
+    algorithm = sys.argv[1]
+    label_column = sys.argv[2]
+    input_file_path = sys.argv[3]
+    output_file_path = sys.argv[4]
+    hyperparameters = json.loads(sys.argv[5]) if len(sys.argv) >= 6 else {}
+    options = json.loads(sys.argv[6]) if len(sys.argv) == 7 else {}
+
+    data = load_data(input_file_path)
+    detect(
+        data,
+        label_column,
+        algorithm=algorithm,
+        model_path=output_file_path,
+        hyperparameters=hyperparameters,
+        columns=options.get("columns"),
+    )
+
+
+if __name__ == "__main__":
+    main()
@@ -1,15 +1,24 @@
 import pandas as pd
-from sklearn.model_selection import train_test_split, GridSearchCV
+import numpy as np
 from sklearn.pipeline import Pipeline
-from sklearn.compose import ColumnTransformer
 from sklearn.impute import SimpleImputer
-from sklearn.preprocessing import OneHotEncoder
 from sklearn.ensemble import RandomForestClassifier
 from sklearn.linear_model import LogisticRegression
-from sklearn.metrics import accuracy_score, classification_report
+from sklearn.metrics import (
+    accuracy_score,
+    balanced_accuracy_score,
+    classification_report,
+    confusion_matrix,
+    log_loss,
+    roc_auc_score,
+)
 import joblib
+import json
 import sys
 
+from features import build_preprocessor, column_types, longest_text_column, print_schema, sample_weight
+from validation import DEFAULT_VALIDATION, fit_and_split, implied_roles, order_rows, report_metrics
+
 
 def load_data(file_path):
     if file_path.endswith(".csv"):
@@ -24,23 +33,25 @@ def load_data(file_path):
         )
 
 
-def train_model(data, target_column, model_path, algorithm="random_forest"):
+def train_model(data, target_column, model_path, algorithm="random_forest", hyperparameters=None, columns=None,
+                validation=None):
+    validation = validation or DEFAULT_VALIDATION
+    data = order_rows(data, validation)
     X = data.drop(columns=[target_column])
     y = data[target_column]
-
-    X_train, X_test, y_train, y_test = train_test_split(
-        X, y, test_size=0.2, random_state=42
-    )
-
-    categorical_features = X.select_dtypes(include=["object"]).columns
-    numeric_features = X.select_dtypes(include=["int", "float"]).columns
-
-    categorical_transformer = Pipeline(
-        steps=[
-            ("imputer", SimpleImputer(strategy="most_frequent")),
-            ("onehot", OneHotEncoder(handle_unknown="ignore")),
-        ]
-    )
+    hyperparameters = dict(hyperparameters or {})
+
+    types = column_types(X, implied_roles(columns, validation))
+    text_options = {}
+    if algorithm == "text_logistic_regression":
+        # the preset is meant for one text column plus a label, it is taken from the longest values if none was found
+        if not any(kind == "text" for kind, _ in types.values()):
+            types[longest_text_column(X)] = ("text", True)
+        text_options = {
+            "max_features": int(hyperparameters.pop("max_features", 50000)),
+            "ngram_range": (1, int(hyperparameters.pop("ngram_max", 2))),
+        }
+    print_schema(types, target_column)
 
     numeric_transformer = Pipeline(
         steps=[
@@ -48,12 +59,7 @@ def train_model(data, target_column, model_path, algorithm="random_forest"):
         ]
     )
 
-    preprocessor = ColumnTransformer(
-        transformers=[
-            ("num", numeric_transformer, numeric_features),
-            ("cat", categorical_transformer, categorical_features),
-        ]
-    )
+    preprocessor = build_preprocessor(types, numeric_transformer, text_options)
 
     if algorithm == "random_forest":
         classifier = RandomForestClassifier()
@@ -66,38 +72,102 @@ def train_model(data, target_column, model_path, algorithm="random_forest"):
         param_grid = (
             {}
         )  # "classifier__C": [0.1, 1, 10], "classifier__max_iter": [100, 300]}
+    elif algorithm == "text_logistic_regression":
+        # TF-IDF gives many sparse features, a linear model copes with them best
+        classifier = LogisticRegression(max_iter=1000)
+        param_grid = {}
     else:
         raise ValueError(
-            "Unsupported algorithm. Choose 'random_forest' or 'logistic_regression'."
+            "Unsupported algorithm. Choose 'random_forest', 'logistic_regression' or 'text_logistic_regression'."
         )
 
-    clf = Pipeline(steps=[("preprocessor", preprocessor), ("classifier", classifier)])
-
-    grid_search = GridSearchCV(clf, param_grid, cv=5, scoring="accuracy")
-    grid_search.fit(X_train, y_train)
-
-    y_pred = grid_search.predict(X_test)
-    accuracy = accuracy_score(y_test, y_pred)
-    report = classification_report(y_test, y_pred, output_dict=True)
-
-    precision = report["weighted avg"]["precision"]
-    recall = report["weighted avg"]["recall"]
-    f1_score = report["weighted avg"]["f1-score"]
+    if hyperparameters:
+        classifier.set_params(**hyperparameters)
 
-    print("Accuracy:", accuracy)
-    print(f"Precision: {precision:.2f}")
-    print(f"Recall: {recall:.2f}")
-    print(f"F1-score: {f1_score:.2f}")
+    clf = Pipeline(steps=[("preprocessor", preprocessor), ("classifier", classifier)])
 
-    joblib.dump(grid_search.best_estimator_, model_path)
+    model, evaluated = fit_and_split(
+        clf, param_grid, X, y, validation, "accuracy", "classifier__sample_weight", sample_weight(X, types)
+    )
 
-    return grid_search.best_estimator_
+    # folds may miss rare classes, so every fold is evaluated against the classes of the whole dataset
+    classes = list(np.unique(y))
+    fold_metrics, y_true, y_pred = [], [], []
+    for fold_model, test in evaluated:
+        metrics, predictions = evaluate_fold(fold_model, X.iloc[test], y.iloc[test], classes)
+        fold_metrics.append(metrics)
+        y_true.extend(y.iloc[test])
+        y_pred.extend(predictions)
+    report_metrics(fold_metrics)
+
+    # the report and the confusion matrix are built from the test rows of every fold together
+    report = classification_report(
+        y_true, y_pred, labels=classes, output_dict=True, zero_division=0
+    )
+    evaluation = {
+        "classes": [
+            {
+                "label": str(label),
+                "precision": report[str(label)]["precision"],
+                "recall": report[str(label)]["recall"],
+                "f1_score": report[str(label)]["f1-score"],
+                "support": int(report[str(label)]["support"]),
+            }
+            for label in classes
+        ],
+        "confusion_matrix": confusion_matrix(y_true, y_pred, labels=classes).tolist(),
+    }
+    # the evaluation goes last on a single line, the server parses it as JSON
+    print("Evaluation:", json.dumps(evaluation, ensure_ascii=False))
+
+    joblib.dump(model, model_path)
+
+    return model
+
+
+def evaluate_fold(model, X_test, y_test, classes):
+    """Returns the metrics of the model on the test rows of a fold and its predictions."""
+    y_pred = model.predict(X_test)
+    y_proba = class_probabilities(model, X_test, classes)
+    report = classification_report(
+        y_test, y_pred, labels=classes, output_dict=True, zero_division=0
+    )
+    metrics = {
+        "Accuracy": accuracy_score(y_test, y_pred),
+        "Precision": report["weighted avg"]["precision"],
+        "Recall": report["weighted avg"]["recall"],
+        "F1-score": report["weighted avg"]["f1-score"],
+        "Balanced Accuracy": balanced_accuracy_score(y_test, y_pred),
+        "ROC AUC": compute_roc_auc(y_test, y_proba, classes),
+        "Log Loss": log_loss(y_test, y_proba, labels=classes),
+    }
+    return metrics, y_pred
+
+
+def class_probabilities(model, X_test, classes):
+    """Probabilities in the order of classes, zero for the classes the fold model never saw."""
+    fold_proba = model.predict_proba(X_test)
+    proba = np.zeros((len(X_test), len(classes)))
+    for i, label in enumerate(model.classes_):
+        proba[:, classes.index(label)] = fold_proba[:, i]
+    return proba
+
+
+def compute_roc_auc(y_test, y_proba, classes):
+    """ROC AUC for a binary target, one-vs-rest macro average for a multiclass one.
+    Returns None when the test sample does not contain every class."""
+    if len(set(y_test)) != len(classes):
+        return None
+    if len(classes) == 2:
+        return roc_auc_score(y_test, y_proba[:, 1])
+    return roc_auc_score(y_test, y_proba, multi_class="ovr", labels=classes)
 
 
 def main():
-    if len(sys.argv) != 5:
+    if len(sys.argv) not in (5, 6, 7):
         print(
-            "Usage: python model_class.py <target_column> <input_file_path> <output_file_path>"
+            "Usage: python %s <algorithm> <target_column> <input_file_path> <output_file_path> [<hyperparameters_json> [<options_json>]]"
+            % sys.argv[0]
         )
         sys.exit(1)
 
@@ -105,9 +175,19 @@ def main():
     target_column = sys.argv[2]
     input_file_path = sys.argv[3]
     output_file_path = sys.argv[4]
+    hyperparameters = json.loads(sys.argv[5]) if len(sys.argv) >= 6 else {}
+    options = json.loads(sys.argv[6]) if len(sys.argv) == 7 else {}
 
     data = load_data(input_file_path)
-    train_model(data, target_column, algorithm=algorithm, model_path=output_file_path)
+    train_model(
+        data,
+        target_column,
+        algorithm=algorithm,
+        model_path=output_file_path,
+        hyperparameters=hyperparameters,
+        columns=options.get("columns"),
+        validation=options.get("validation"),
+    )
 
 
 if __name__ == "__main__":
@@ -0,0 +1,137 @@
+import pandas as pd
+from scipy.sparse import issparse
+from sklearn.pipeline import Pipeline
+from sklearn.impute import SimpleImputer
+from sklearn.preprocessing import StandardScaler
+from sklearn.cluster import KMeans, DBSCAN, AgglomerativeClustering
+from sklearn.metrics import silhouette_score, davies_bouldin_score
+import json
+import sys
+
+from features import build_preprocessor, column_types, print_schema
+
+# upper bound of the cluster count tried when it is chosen automatically
+MAX_AUTO_CLUSTERS = 10
+# silhouette is quadratic in the number of rows, larger datasets are scored on a sample
+SILHOUETTE_SAMPLE_SIZE = 10000
+
+
+def load_data(file_path):
+    if file_path.endswith(".csv"):
+        return pd.read_csv(file_path)
+    elif file_path.endswith(".xls") or file_path.endswith(".xlsx"):
+        return pd.read_excel(file_path)
+    elif file_path.endswith(".pkl"):
+        return pd.read_pickle(file_path)
+    else:
+        raise ValueError(
+            "Unsupported file type. Supported types are csv, xls, xlsx, and pkl."
+        )
+
+
+def prepare_features(data, columns):
+    types = column_types(data, columns)
+    print_schema(types)
+
+    # clustering is distance based, so numeric features are brought to one scale
+    numeric_transformer = Pipeline(
+        steps=[
+            ("imputer", SimpleImputer(strategy="mean")),
+            ("scaler", StandardScaler()),
+        ]
+    )
+    return build_preprocessor(types, numeric_transformer).fit_transform(data)
+
+
+def dense(X):
+    # agglomerative clustering and the Davies-Bouldin index do not accept sparse text features
+    return X.toarray() if issparse(X) else X
+
+
+def make_model(algorithm, n_clusters, hyperparameters):
+    if algorithm == "kmeans":
+        return KMeans(n_clusters=n_clusters, n_init=10, random_state=42, **hyperparameters)
+    elif algorithm == "agglomerative":
+        return AgglomerativeClustering(n_clusters=n_clusters, **hyperparameters)
+    raise ValueError(
+        "Unsupported algorithm. Choose 'kmeans', 'dbscan' or 'agglomerative'."
+    )
+
+
+def score_silhouette(X, labels):
+    sample_size = min(len(X), SILHOUETTE_SAMPLE_SIZE)
+    return silhouette_score(X, labels, sample_size=sample_size, random_state=42)
+
+
+def choose_n_clusters(X, algorithm, hyperparameters):
+    """Tries every cluster count from 2 to MAX_AUTO_CLUSTERS and keeps the best silhouette."""
+    best_n, best_score = None, None
+    for n_clusters in range(2, min(MAX_AUTO_CLUSTERS, len(X) - 1) + 1):
+        labels = make_model(algorithm, n_clusters, hyperparameters).fit_predict(X)
+        if len(set(labels)) < 2:
+            continue
+        score = score_silhouette(X, labels)
+        if best_score is None or score > best_score:
+            best_n, best_score = n_clusters, score
+    if best_n is None:
+        raise ValueError("Not enough rows to choose the number of clusters.")
+    return best_n
+
+
+def cluster(data, model_path, algorithm="kmeans", hyperparameters=None, columns=None):
+    hyperparameters = dict(hyperparameters or {})
+    X = prepare_features(data, columns)
+    if algorithm == "agglomerative":
+        X = dense(X)
+
+    if algorithm == "dbscan":
+        labels = DBSCAN(**hyperparameters).fit_predict(X)
+    else:
+        n_clusters = hyperparameters.pop("n_clusters", None)
+        if n_clusters is None:
+            n_clusters = choose_n_clusters(X, algorithm, hyperparameters)
+        labels = make_model(algorithm, n_clusters, hyperparameters).fit_predict(X)
+
+    # DBSCAN marks noise with -1, noise points are left out of the quality metrics
+    clustered = labels != -1
+    n_found = len(set(labels[clustered]))
+
+    print(f"Clusters: {n_found}")
+    if algorithm == "dbscan":
+        print(f"Noise Share: {1 - clustered.mean():.4f}")
+    if 2 <= n_found < clustered.sum():
+        print(f"Silhouette: {score_silhouette(X[clustered], labels[clustered]):.4f}")
+        print(f"Davies-Bouldin: {davies_bouldin_score(dense(X[clustered]), labels[clustered]):.4f}")
+
+    data.assign(cluster=labels).to_csv(model_path, index=False)
+
+    return labels
+
+
+def main():
+    if len(sys.argv) not in (5, 6, 7):
+        print(
+            "Usage: python %s <algorithm> <target_column> <input_file_path> <output_file_path> [<hyperparameters_json> [<options_json>]]"
+            % sys.argv[0]
+        )
+        sys.exit(1)
+
+    # clustering has no target, the argument is accepted for the same command line as the other scripts
+    algorithm = sys.argv[1]
+    input_file_path = sys.argv[3]
+    output_file_path = sys.argv[4]
+    hyperparameters = json.loads(sys.argv[5]) if len(sys.argv) >= 6 else {}
+    options = json.loads(sys.argv[6]) if len(sys.argv) == 7 else {}
+
+    data = load_data(input_file_path)
+    cluster(
+        data,
+        algorithm=algorithm,
+        model_path=output_file_path,
+        hyperparameters=hyperparameters,
+        columns=options.get("columns"),
+    )
+
+
+if __name__ == "__main__":
+    main()
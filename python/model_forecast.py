@@ -0,0 +1,255 @@
+import pandas as pd
+import numpy as np
+from sklearn.linear_model import LinearRegression
+from sklearn.ensemble import GradientBoostingRegressor
+import json
+import sys
+
+# number of time-ordered backtest folds, each fold holds out the last horizon before its cutoff
+BACKTEST_FOLDS = 3
+# lags used when n_lags is not set and the season is shorter
+DEFAULT_LAGS = 7
+# the result page chart shows only the first series and the end of their history
+CHART_SERIES = 5
+CHART_HISTORY = 60
+
+
+def load_data(file_path):
+    if file_path.endswith(".csv"):
+        return pd.read_csv(file_path)
+    elif file_path.endswith(".xls") or file_path.endswith(".xlsx"):
+        return pd.read_excel(file_path)
+    elif file_path.endswith(".pkl"):
+        return pd.read_pickle(file_path)
+    else:
+        raise ValueError(
+            "Unsupported file type. Supported types are csv, xls, xlsx, and pkl."
+        )
+
+
+def split_series(data, target_column, time_column, series_column):
+    """Returns the series of the dataset ordered by time, keyed by the series ID."""
+    columns = [time_column, target_column] + ([series_column] if series_column else [])
+    frame = data[columns].copy()
+    frame[time_column] = pd.to_datetime(frame[time_column])
+    frame[target_column] = pd.to_numeric(frame[target_column])
+    frame = frame.dropna(subset=[time_column, target_column])
+
+    if series_column:
+        frame[series_column] = frame[series_column].astype(str)
+        groups = frame.groupby(series_column, sort=True)
+    else:
+        groups = [("", frame)]
+
+    series = {}
+    for series_id, group in groups:
+        # several rows of one period, e.g. separate sales of one week, are summed up
+        values = group.groupby(time_column)[target_column].sum().sort_index()
+        if len(values) >= 2:
+            series[series_id] = values
+    if not series:
+        raise ValueError("Every series needs at least two periods.")
+    return series
+
+
+def infer_step(index):
+    freq = pd.infer_freq(index) if len(index) >= 3 else None
+    if freq:
+        return pd.tseries.frequencies.to_offset(freq)
+    return (index[1:] - index[:-1]).median()
+
+
+def format_period(timestamp):
+    if timestamp == timestamp.normalize():
+        return timestamp.strftime("%Y-%m-%d")
+    return timestamp.strftime("%Y-%m-%d %H:%M")
+
+
+class Forecaster:
+    """Seasonal naive forecast or a regressor over the previous n_lags values, shared by all series.
+    Series shorter than n_lags can not fill the regressor window, they get the seasonal naive forecast."""
+
+    def __init__(self, algorithm, hyperparameters):
+        params = dict(hyperparameters)
+        self.season_length = int(params.pop("season_length", 1))
+        self.n_lags = int(params.pop("n_lags", max(DEFAULT_LAGS, self.season_length)))
+        self.algorithm = algorithm
+
+        if algorithm == "seasonal_naive":
+            self.regressor = None
+        elif algorithm == "lag_linear":
+            self.regressor = LinearRegression(**params)
+        elif algorithm == "lag_gradient_boosting":
+            self.regressor = GradientBoostingRegressor(random_state=42, **params)
+        else:
+            raise ValueError(
+                "Unsupported algorithm. Choose 'seasonal_naive', 'lag_linear' or 'lag_gradient_boosting'."
+            )
+
+    def min_history(self):
+        if self.regressor is None:
+            return self.season_length
+        return self.n_lags + 1
+
+    def falls_back(self, values):
+        return self.regressor is not None and len(values) < self.n_lags
+
+    def fit(self, histories):
+        if self.regressor is None:
+            return self
+        X, y = [], []
+        for values in histories:
+            # series of different scale are brought together by their mean level
+            scaled = values / series_scale(values)
+            for end in range(self.n_lags, len(scaled)):
+                X.append(scaled[end - self.n_lags:end])
+                y.append(scaled[end])
+        if not X:
+            raise ValueError("Series are too short for %d lags." % self.n_lags)
+        self.regressor.fit(np.array(X), np.array(y))
+        return self
+
+    def predict(self, values, horizon):
+        if self.regressor is None or self.falls_back(values):
+            season = values[-min(self.season_length, len(values)):]
+            return np.array([season[step % len(season)] for step in range(horizon)])
+
+        scale = series_scale(values)
+        window = list(values[-self.n_lags:] / scale)
+        predictions = []
+        for _ in range(horizon):
+            # each step is predicted from the previous predictions, as the future is unknown
+            prediction = self.regressor.predict(np.array([window[-self.n_lags:]]))[0]
+            predictions.append(prediction)
+            window.append(prediction)
+        return np.array(predictions) * scale
+
+
+def series_scale(values):
+    scale = np.mean(np.abs(values))
+    return scale if scale > 0 else 1.0
+
+
+def naive_error(values, season_length):
+    """Mean absolute error of the seasonal naive forecast on the history, the denominator of MASE."""
+    if len(values) <= season_length:
+        return None
+    error = np.mean(np.abs(values[season_length:] - values[:-season_length]))
+    return error if error > 0 else None
+
+
+def backtest(series, algorithm, hyperparameters, horizon):
+    actual, predicted, scaled_errors = [], [], []
+    for fold in range(BACKTEST_FOLDS, 0, -1):
+        forecaster = Forecaster(algorithm, hyperparameters)
+        cutoff = fold * horizon
+        histories = {
+            series_id: values.values[:-cutoff]
+            for series_id, values in series.items()
+            if len(values) - cutoff >= forecaster.min_history()
+        }
+        if not histories:
+            continue
+        try:
+            forecaster.fit(list(histories.values()))
+        except ValueError:
+            continue
+
+        for series_id, history in histories.items():
+            values = series[series_id].values
+            expected = values[len(history):len(history) + horizon]
+            forecast = forecaster.predict(history, len(expected))
+            actual.extend(expected)
+            predicted.extend(forecast)
+            scale = naive_error(history, forecaster.season_length)
+            if scale is not None:
+                scaled_errors.append(np.mean(np.abs(expected - forecast)) / scale)
+
+    if not actual:
+        raise ValueError("Not enough history to backtest a forecast of %d periods." % horizon)
+    return np.array(actual), np.array(predicted), scaled_errors
+
+
+def report_metrics(actual, predicted, scaled_errors):
+    errors = np.abs(actual - predicted)
+    if scaled_errors:
+        print(f"MASE: {np.mean(scaled_errors):.4f}")
+
+    denominator = np.abs(actual) + np.abs(predicted)
+    # both values being zero is a perfect forecast
+    smape = np.where(denominator > 0, 2 * errors / np.where(denominator > 0, denominator, 1), 0)
+    print(f"sMAPE: {100 * np.mean(smape):.4f}")
+
+    # periods with a zero actual value make MAPE infinite, they are left out
+    nonzero = actual != 0
+    if nonzero.any():
+        print(f"MAPE: {100 * np.mean(errors[nonzero] / np.abs(actual[nonzero])):.4f}")
+
+
+def forecast(data, target_column, time_column, series_column, horizon, model_path,
+             algorithm="seasonal_naive", hyperparameters=None):
+    hyperparameters = dict(hyperparameters or {})
+    series = split_series(data, target_column, time_column, series_column)
+
+    actual, predicted, scaled_errors = backtest(series, algorithm, hyperparameters, horizon)
+    report_metrics(actual, predicted, scaled_errors)
+
+    forecaster = Forecaster(algorithm, hyperparameters)
+    forecaster.fit([values.values for values in series.values()])
+
+    rows, chart = [], []
+    for series_id, values in series.items():
+        naive = forecaster.falls_back(values.values)
+        periods = pd.date_range(start=values.index[-1], periods=horizon + 1, freq=infer_step(values.index))[1:]
+        predictions = forecaster.predict(values.values, horizon)
+        for period, prediction in zip(periods, predictions):
+            row = {time_column: period, target_column: prediction}
+            if series_column:
+                row = {series_column: series_id, **row}
+            rows.append(row)
+
+        if len(chart) < CHART_SERIES:
+            history = values.iloc[-CHART_HISTORY:]
+            chart.append({
+                "series_id": series_id,
+                "history": [{"period": format_period(t), "value": float(v)} for t, v in history.items()],
+                "predictions": [{"period": format_period(t), "value": float(v)} for t, v in zip(periods, predictions)],
+                "naive": naive,
+            })
+
+    pd.DataFrame(rows).to_csv(model_path, index=False)
+    print("Forecast: " + json.dumps({"series": chart}))
+
+    return forecaster
+
+
+def main():
+    if len(sys.argv) != 7:
+        print(
+            "Usage: python %s <algorithm> <target_column> <input_file_path> <output_file_path> <hyperparameters_json> <options_json>"
+            % sys.argv[0]
+        )
+        sys.exit(1)
+
+    algorithm = sys.argv[1]
+    target_column = sys.argv[2]
+    input_file_path = sys.argv[3]
+    output_file_path = sys.argv[4]
+    hyperparameters = json.loads(sys.argv[5])
+    settings = json.loads(sys.argv[6])["forecast"]
+
+    data = load_data(input_file_path)
+    forecast(
+        data,
+        target_column,
+        time_column=settings["time_column"],
+        series_column=settings.get("series_column"),
+        horizon=settings["horizon"],
+        algorithm=algorithm,
+        model_path=output_file_path,
+        hyperparameters=hyperparameters,
+    )
+
+
+if __name__ == "__main__":
+    main()
@@ -1,15 +1,17 @@
 import pandas as pd
-from sklearn.model_selection import train_test_split, GridSearchCV
 from sklearn.pipeline import Pipeline
-from sklearn.compose import ColumnTransformer
 from sklearn.impute import SimpleImputer
-from sklearn.preprocessing import OneHotEncoder, StandardScaler
+from sklearn.preprocessing import StandardScaler
 from sklearn.linear_model import LinearRegression
 from sklearn.svm import SVR
 from sklearn.metrics import mean_squared_error, mean_absolute_error, r2_score
 import joblib
+import json
 import sys
 
+from features import build_preprocessor, column_types, print_schema, sample_weight
+from validation import DEFAULT_VALIDATION, fit_and_split, implied_roles, order_rows, report_metrics
+
 
 def load_data(file_path):
     if file_path.endswith(".csv"):
@@ -24,23 +26,15 @@ def load_data(file_path):
         )
 
 
-def train_model(data, target_column, model_path, algorithm="linear_regression"):
+def train_model(data, target_column, model_path, algorithm="linear_regression", hyperparameters=None, columns=None,
+                validation=None):
+    validation = validation or DEFAULT_VALIDATION
+    data = order_rows(data, validation)
     X = data.drop(columns=[target_column])
     y = data[target_column]
 
-    X_train, X_test, y_train, y_test = train_test_split(
-        X, y, test_size=0.2, random_state=42
-    )
-
-    categorical_features = X.select_dtypes(include=["object"]).columns
-    numeric_features = X.select_dtypes(include=["int", "float"]).columns
-
-    categorical_transformer = Pipeline(
-        steps=[
-            ("imputer", SimpleImputer(strategy="most_frequent")),
-            ("onehot", OneHotEncoder(handle_unknown="ignore")),
-        ]
-    )
+    types = column_types(X, implied_roles(columns, validation))
+    print_schema(types, target_column)
 
     numeric_transformer = Pipeline(
         steps=[
@@ -49,12 +43,7 @@ def train_model(data, target_column, model_path, algorithm="linear_regression"):
         ]
     )
 
-    preprocessor = ColumnTransformer(
-        transformers=[
-            ("num", numeric_transformer, numeric_features),
-            ("cat", categorical_transformer, categorical_features),
-        ]
-    )
+    preprocessor = build_preprocessor(types, numeric_transformer)
 
     if algorithm == "linear_regression":
         regressor = LinearRegression()
@@ -72,30 +61,36 @@ def train_model(data, target_column, model_path, algorithm="linear_regression"):
             "Unsupported algorithm. Choose 'linear_regression' or 'support_vector_machine'."
         )
 
-    clf = Pipeline(steps=[("preprocessor", preprocessor), ("regressor", regressor)])
+    if hyperparameters:
+        regressor.set_params(**hyperparameters)
 
-    grid_search = GridSearchCV(clf, param_grid, cv=5, scoring="neg_mean_squared_error")
-    grid_search.fit(X_train, y_train)
+    clf = Pipeline(steps=[("preprocessor", preprocessor), ("regressor", regressor)])
 
-    y_pred = grid_search.predict(X_test)
-    mse = mean_squared_error(y_test, y_pred)
-    rmse = mse**0.5
-    mae = mean_absolute_error(y_test, y_pred)
-    r2 = r2_score(y_test, y_pred)
+    model, evaluated = fit_and_split(
+        clf, param_grid, X, y, validation, "neg_mean_squared_error", "regressor__sample_weight", sample_weight(X, types)
+    )
 
-    print(f"RMSE: {rmse:.2f}")
-    print(f"MAE: {mae:.2f}")
-    print(f"R2 Score: {r2:.2f}")
+    fold_metrics = []
+    for fold_model, test in evaluated:
+        y_test = y.iloc[test]
+        y_pred = fold_model.predict(X.iloc[test])
+        fold_metrics.append({
+            "RMSE": mean_squared_error(y_test, y_pred) ** 0.5,
+            "MAE": mean_absolute_error(y_test, y_pred),
+            "R2 Score": r2_score(y_test, y_pred),
+        })
+    report_metrics(fold_metrics, precision=2)
 
-    joblib.dump(grid_search.best_estimator_, model_path)
+    joblib.dump(model, model_path)
 
-    return grid_search.best_estimator_
+    return model
 
 
 def main():
-    if len(sys.argv) != 5:
+    if len(sys.argv) not in (5, 6, 7):
         print(
-            "Usage: python model_class.py <target_column> <input_file_path> <output_file_path>"
+            "Usage: python %s <algorithm> <target_column> <input_file_path> <output_file_path> [<hyperparameters_json> [<options_json>]]"
+            % sys.argv[0]
         )
         sys.exit(1)
 
@@ -103,9 +98,19 @@ def main():
     target_column = sys.argv[2]
     input_file_path = sys.argv[3]
     output_file_path = sys.argv[4]
+    hyperparameters = json.loads(sys.argv[5]) if len(sys.argv) >= 6 else {}
+    options = json.loads(sys.argv[6]) if len(sys.argv) == 7 else {}
 
     data = load_data(input_file_path)
-    train_model(data, target_column, algorithm=algorithm, model_path=output_file_path)
+    train_model(
+        data,
+        target_column,
+        algorithm=algorithm,
+        model_path=output_file_path,
+        hyperparameters=hyperparameters,
+        columns=options.get("columns"),
+        validation=options.get("validation"),
+    )
 
 
 if __name__ == "__main__":
@@ -0,0 +1,122 @@
+package python
+
+// ModelType описывает тип задачи, который может решать сервис
+type ModelType struct {
+	Name           string   // значение model_type в маршрутах и в таблице shipments
+	Title          string   // название для пользователя
+	Script         string   // скрипт обучения
+	NeedsTarget    bool     // нужен ли целевой столбец
+	OptionalTarget bool     // целевой столбец можно указать для оценки качества, но обучение его не использует
+	ColumnRoles    []string // роли столбцов, которые принимает скрипт; скрипты с ролями сообщают схему датасета
+	Validation     []string // способы проверки качества, которые поддерживает скрипт
+	ResultFile     string   // имя файла результатов при скачивании
+}
+
+// ValidationStrategy описывает способ проверки качества модели
+type ValidationStrategy struct {
+	Name  string // значение strategy в настройках проверки
+	Title string // название для пользователя
+	Folds bool   // качество усредняется по фолдам, а не считается на одной отложенной выборке
+}
+
+// Способы проверки качества в порядке отображения
+var validationStrategies = []ValidationStrategy{
+	{Name: "holdout", Title: "Отложенная выборка"},
+	{Name: "kfold", Title: "K-fold кросс-валидация", Folds: true},
+	{Name: "stratified_kfold", Title: "Стратифицированная K-fold кросс-валидация", Folds: true},
+	{Name: "group_kfold", Title: "Group K-fold по столбцу групп", Folds: true},
+	{Name: "time_series", Title: "Разбиение по времени", Folds: true},
+}
+
+// Роли столбцов датасета: исключить, идентификатор, типы признаков и вес строки
+var allColumnRoles = []string{"ignore", "id", "categorical", "numeric", "text", "datetime", "weight"}
+
+// Роли для задач без учителя, где веса строк не поддерживаются
+var featureColumnRoles = []string{"ignore", "id", "categorical", "numeric", "text", "datetime"}
+
+// Стратификация нужна только классам, регрессия проверяется остальными способами
+var classValidation = []string{"holdout", "kfold", "stratified_kfold", "group_kfold", "time_series"}
+var regValidation = []string{"holdout", "kfold", "group_kfold", "time_series"}
+
+// Типы моделей в порядке отображения
+var modelTypes = []ModelType{
+	{Name: "class", Title: "Классификация", Script: "model_class.py", NeedsTarget: true, ColumnRoles: allColumnRoles, Validation: classValidation, ResultFile: "results"},
+	{Name: "reg", Title: "Регрессия", Script: "model_reg.py", NeedsTarget: true, ColumnRoles: allColumnRoles, Validation: regValidation, ResultFile: "results"},
+	{Name: "cluster", Title: "Кластеризация", Script: "model_cluster.py", ColumnRoles: featureColumnRoles, ResultFile: "clusters.csv"},
+	{Name: "forecast", Title: "Прогнозирование", Script: "model_forecast.py", NeedsTarget: true, ResultFile: "forecast.csv"},
+	{Name: "anomaly", Title: "Поиск аномалий", Script: "model_anomaly.py", OptionalTarget: true, ColumnRoles: featureColumnRoles, ResultFile: "anomalies.csv"},
+}
+
+// ModelTypes returns the names of all supported model types
+func ModelTypes() []string {
+	names := make([]string, len(modelTypes))
+	for i, modelType := range modelTypes {
+		names[i] = modelType.Name
+	}
+	return names
+}
+
+// LookupModelType returns the description of the model type
+func LookupModelType(name string) (ModelType, bool) {
+	for _, modelType := range modelTypes {
+		if modelType.Name == name {
+			return modelType, true
+		}
+	}
+	return ModelType{}, false
+}
+
+// ValidModelType reports whether the model type is supported
+func ValidModelType(name string) bool {
+	_, ok := LookupModelType(name)
+	return ok
+}
+
+// ModelTypeTitle returns the title of the model type shown to the user
+func ModelTypeTitle(name string) string {
+	if modelType, ok := LookupModelType(name); ok {
+		return modelType.Title
+	}
+	return name
+}
+
+// AcceptsColumnRole reports whether the script of the model type accepts the column role
+func (t ModelType) AcceptsColumnRole(role string) bool {
+	for _, accepted := range t.ColumnRoles {
+		if accepted == role {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidationStrategies returns the validation strategies the script of the model type supports
+func (t ModelType) ValidationStrategies() []ValidationStrategy {
+	var strategies []ValidationStrategy
+	for _, strategy := range validationStrategies {
+		for _, name := range t.Validation {
+			if name == strategy.Name {
+				strategies = append(strategies, strategy)
+			}
+		}
+	}
+	return strategies
+}
+
+// LookupValidationStrategy returns the description of the validation strategy
+func LookupValidationStrategy(name string) (ValidationStrategy, bool) {
+	for _, strategy := range validationStrategies {
+		if strategy.Name == name {
+			return strategy, true
+		}
+	}
+	return ValidationStrategy{}, false
+}
+
+// ValidationStrategyTitle returns the title of the validation strategy shown to the user
+func ValidationStrategyTitle(name string) string {
+	if strategy, ok := LookupValidationStrategy(name); ok {
+		return strategy.Title
+	}
+	return name
+}
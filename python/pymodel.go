@@ -1,7 +1,11 @@
 package python
 
 import (
+	"feklistova/config"
+	"feklistova/models"
 	"bytes"
+	"context"
+	"encoding/json"
 	"fmt"
 	"log"
 	"os/exec"
@@ -10,35 +14,61 @@ import (
 
 type PyModel struct{}
 
-func (p *PyModel) RunModel(modelType, algorithm, targetColumn, inputFilePath, outputFilePath string) (map[string]float64, error) {
-	var pythonScript string
-	switch modelType {
-	case "reg":
-		pythonScript = "model_reg.py"
-	case "class":
-		pythonScript = "model_class.py"
-	default:
-		return nil, fmt.Errorf("unsupported model type: %s", modelType)
-	}
+// TrainingOptions содержит настройки обучения помимо гиперпараметров, скрипт получает их последним аргументом в JSON
+type TrainingOptions struct {
+	Forecast   *models.ForecastSettings   `json:"forecast,omitempty"`
+	Columns    map[string]string          `json:"columns,omitempty"` // роли столбцов: столбец -> роль
+	Validation *models.ValidationSettings `json:"validation,omitempty"`
+}
+
+func (o TrainingOptions) empty() bool {
+	return o.Forecast == nil && len(o.Columns) == 0 && o.Validation == nil
+}
 
-	algorithmMapping := map[string]string{
-		"Логистическая регрессия": "logistic_regression",
-		"Случайный лес":           "random_forest",
-		"Линейная регрессия":      "linear_regression",
-		"Метод опорных векторов":  "support_vector_machine",
+// Result содержит все, что скрипт сообщил об обученной модели
+type Result struct {
+	Metrics map[string]float64
+	models.ModelDetails
+}
+
+// RunModel trains the model and returns its metrics together with the detailed evaluation the script printed
+func (p *PyModel) RunModel(modelType, algorithm, targetColumn, inputFilePath, outputFilePath string, hyperparameters map[string]float64, options TrainingOptions) (*Result, error) {
+	modelDescription, ok := LookupModelType(modelType)
+	if !ok {
+		return nil, fmt.Errorf("unsupported model type: %s", modelType)
 	}
+	pythonScript := modelDescription.Script
 
-	algorithm, ok := algorithmMapping[algorithm]
+	algorithm, ok = algorithmMapping[algorithm]
 	if !ok {
 		return nil, fmt.Errorf("unsupported algorithm type: %s", algorithm)
 	}
 
-	log.Printf("Executing: python %s %s %s %s %s", pythonScript, algorithm, targetColumn, inputFilePath, outputFilePath)
-	cmd := exec.Command("python", pythonScript, algorithm, targetColumn, inputFilePath, outputFilePath)
+	if hyperparameters == nil {
+		hyperparameters = map[string]float64{}
+	}
+	params, err := json.Marshal(hyperparameters)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode hyperparameters: %v", err)
+	}
+	args := []string{pythonScript, algorithm, targetColumn, inputFilePath, outputFilePath, string(params)}
+	if !options.empty() {
+		encodedOptions, err := json.Marshal(options)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode training options: %v", err)
+		}
+		args = append(args, string(encodedOptions))
+	}
+
+	log.Printf("Executing: python %s", strings.Join(args, " "))
+	// a run that trains longer is treated as stuck, the process is stopped so it doesn't outlive its shipment
+	ctx, cancel := context.WithTimeout(context.Background(), config.StaleShipmentTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "python", args...)
 	var stdout, stderr bytes.Buffer
 	cmd.Stdout = &stdout
 	cmd.Stderr = &stderr
-	err := cmd.Run()
+	err = cmd.Run()
 	if err != nil {
 		log.Println("Stderr:", stderr.String())
 		return nil, fmt.Errorf("failed to run Python model: %v", err)
@@ -51,27 +81,64 @@ func (p *PyModel) RunModel(modelType, algorithm, targetColumn, inputFilePath, ou
 			nonEmptyLines = append(nonEmptyLines, line)
 		}
 	}
-	result := strings.Join(nonEmptyLines, "")
+	output := strings.Join(nonEmptyLines, "")
 
-	log.Println("Stdout:", result)
-	metrics, err := ParseMetrics(lines)
+	log.Println("Stdout:", output)
+	result, err := ParseMetrics(lines)
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse metrics: %v", err)
 	}
-	return metrics, nil
+	return result, nil
 }
 
-func ParseMetrics(outputStrings []string) (map[string]float64, error) {
-	metrics := make(map[string]float64)
+// Names of the output lines with the detailed evaluation, the forecast, the dataset schema
+// and the deviation of the metrics over the cross-validation folds in JSON
+const (
+	evaluationLine = "Evaluation"
+	forecastLine   = "Forecast"
+	schemaLine     = "Schema"
+	stdLine        = "Std"
+)
+
+// ParseMetrics reads "Name: value" lines printed by the scripts
+func ParseMetrics(outputStrings []string) (*Result, error) {
+	result := &Result{Metrics: make(map[string]float64)}
 
-	for _, line := range outputStrings[:len(outputStrings)-1] {
-		parts := strings.Split(line, ":")
-		if len(parts) != 2 {
+	for _, line := range outputStrings {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(line, ":")
+		if !ok {
 			return nil, fmt.Errorf("invalid output format: %s", line)
 		}
+		name = strings.TrimSpace(name)
+		value = strings.TrimSpace(value)
 
-		name := strings.TrimSpace(parts[0])
-		value := strings.TrimSpace(parts[1])
+		switch name {
+		case evaluationLine:
+			result.Report = &models.ClassificationReport{}
+			if err := json.Unmarshal([]byte(value), result.Report); err != nil {
+				return nil, fmt.Errorf("failed to parse evaluation: %v", err)
+			}
+			continue
+		case forecastLine:
+			result.Forecast = &models.Forecast{}
+			if err := json.Unmarshal([]byte(value), result.Forecast); err != nil {
+				return nil, fmt.Errorf("failed to parse forecast: %v", err)
+			}
+			continue
+		case schemaLine:
+			if err := json.Unmarshal([]byte(value), &result.Schema); err != nil {
+				return nil, fmt.Errorf("failed to parse dataset schema: %v", err)
+			}
+			continue
+		case stdLine:
+			if err := json.Unmarshal([]byte(value), &result.MetricStd); err != nil {
+				return nil, fmt.Errorf("failed to parse metric deviation: %v", err)
+			}
+			continue
+		}
 
 		var floatValue float64
 		_, err := fmt.Sscanf(value, "%f", &floatValue)
@@ -79,8 +146,8 @@ func ParseMetrics(outputStrings []string) (map[string]float64, error) {
 			return nil, fmt.Errorf("failed to parse value as float: %s", value)
 		}
 
-		metrics[name] = floatValue
+		result.Metrics[name] = floatValue
 	}
 
-	return metrics, nil
+	return result, nil
 }
@@ -0,0 +1,104 @@
+"""Validation strategies shared by the classification and regression scripts."""
+import json
+
+import numpy as np
+import pandas as pd
+from sklearn.base import clone
+from sklearn.model_selection import (
+    GridSearchCV,
+    GroupKFold,
+    KFold,
+    StratifiedKFold,
+    TimeSeriesSplit,
+    train_test_split,
+)
+
+# runs created before the validation settings were split 80/20 with a fixed seed
+DEFAULT_VALIDATION = {"strategy": "holdout", "test_size": 0.2, "seed": 42}
+DEFAULT_FOLDS = 5
+
+
+def order_rows(data, validation):
+    """Time-series folds follow the row order, so the rows are sorted by the time column when one is set."""
+    time_column = validation.get("time_column")
+    if validation["strategy"] != "time_series" or not time_column:
+        return data
+    order = pd.to_datetime(data[time_column], errors="coerce").argsort(kind="stable")
+    return data.iloc[order].reset_index(drop=True)
+
+
+def implied_roles(columns, validation):
+    """The group column only defines the folds, it is not a feature unless the column spec says otherwise."""
+    columns = dict(columns or {})
+    group_column = validation.get("group_column")
+    if validation["strategy"] == "group_kfold" and group_column:
+        columns.setdefault(group_column, "id")
+    return columns
+
+
+def splits(X, y, validation):
+    """Returns the (train rows, test rows) pairs of the strategy, the holdout has a single pair."""
+    strategy = validation["strategy"]
+    seed = validation.get("seed", DEFAULT_VALIDATION["seed"])
+    folds = validation.get("folds") or DEFAULT_FOLDS
+
+    if strategy == "holdout":
+        rows = np.arange(len(X))
+        test_size = validation.get("test_size") or DEFAULT_VALIDATION["test_size"]
+        return [tuple(train_test_split(rows, test_size=test_size, random_state=seed))]
+    if strategy == "kfold":
+        return list(KFold(n_splits=folds, shuffle=True, random_state=seed).split(X))
+    if strategy == "stratified_kfold":
+        return list(StratifiedKFold(n_splits=folds, shuffle=True, random_state=seed).split(X, y))
+    if strategy == "group_kfold":
+        return list(GroupKFold(n_splits=folds).split(X, y, groups=X[validation["group_column"]]))
+    if strategy == "time_series":
+        return list(TimeSeriesSplit(n_splits=folds).split(X))
+    raise ValueError(
+        "Unsupported validation strategy. Choose 'holdout', 'kfold', 'stratified_kfold', 'group_kfold' or 'time_series'."
+    )
+
+
+def fit_params(weight_param, weights, rows=None):
+    if weights is None:
+        return {}
+    return {weight_param: weights if rows is None else weights[rows]}
+
+
+def fit_and_split(estimator, param_grid, X, y, validation, scoring, weight_param, weights=None):
+    """Returns the model to save and the (model, test rows) pairs to evaluate.
+    The holdout model is trained on the training rows only, as before the strategies were configurable.
+    With cross-validation the hyperparameters are searched on the same folds, the saved model is refit
+    on every row and a copy with the best hyperparameters is trained on each fold for evaluation."""
+    folds = splits(X, y, validation)
+
+    if validation["strategy"] == "holdout":
+        train, test = folds[0]
+        search = GridSearchCV(estimator, param_grid, cv=DEFAULT_FOLDS, scoring=scoring)
+        search.fit(X.iloc[train], y.iloc[train], **fit_params(weight_param, weights, train))
+        return search.best_estimator_, [(search.best_estimator_, test)]
+
+    search = GridSearchCV(estimator, param_grid, cv=folds, scoring=scoring)
+    search.fit(X, y, **fit_params(weight_param, weights))
+
+    evaluated = []
+    for train, test in folds:
+        model = clone(search.best_estimator_)
+        model.fit(X.iloc[train], y.iloc[train], **fit_params(weight_param, weights, train))
+        evaluated.append((model, test))
+    return search.best_estimator_, evaluated
+
+
+def report_metrics(fold_metrics, precision=4):
+    """Prints the mean of every metric over the folds. Metrics a fold could not compute are None and skipped.
+    With several folds the standard deviations follow on a single line, the server parses it as JSON."""
+    std = {}
+    for name in fold_metrics[0]:
+        values = [metrics[name] for metrics in fold_metrics if metrics[name] is not None]
+        if not values:
+            continue
+        print(f"{name}: {np.mean(values):.{precision}f}")
+        if len(fold_metrics) > 1:
+            std[name] = round(float(np.std(values)), precision)
+    if std:
+        print("Std:", json.dumps(std))
@@ -0,0 +1,86 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// BucketConfig описывает ограничение частоты запросов корзиной токенов
+type BucketConfig struct {
+	Burst    int           // сколько запросов можно сделать подряд
+	Interval time.Duration // за какое время восстанавливается один токен
+	Window   time.Duration // через сколько без запросов полная корзина забывается
+}
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// Bucket limits the rate of requests per key. Unlike Limiter it counts every request,
+// not only failed ones, and never locks a key out for longer than one token takes to refill.
+type Bucket struct {
+	cfg     BucketConfig
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	now     func() time.Time
+}
+
+func (b *Bucket) NewBucket(cfg BucketConfig) {
+	b.cfg = cfg
+	b.buckets = make(map[string]*bucket)
+	b.now = time.Now
+}
+
+// Take spends a token of every key if all of them have one. Otherwise nothing is spent
+// and the returned duration tells how long the caller has to wait.
+func (b *Bucket) Take(keys ...string) (time.Duration, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := b.now()
+	var wait time.Duration
+	for _, key := range keys {
+		if tokens := b.refill(key, now); tokens < 1 {
+			if missing := time.Duration((1 - tokens) * float64(b.cfg.Interval)); missing > wait {
+				wait = missing
+			}
+		}
+	}
+	if wait > 0 {
+		return wait, false
+	}
+	for _, key := range keys {
+		b.buckets[key].tokens--
+	}
+	return 0, true
+}
+
+// refill adds the tokens restored since the last request of the key and returns how many it has
+func (b *Bucket) refill(key string, now time.Time) float64 {
+	e, ok := b.buckets[key]
+	if !ok {
+		e = &bucket{tokens: float64(b.cfg.Burst)}
+		b.buckets[key] = e
+	} else if b.cfg.Interval > 0 {
+		e.tokens += float64(now.Sub(e.updated)) / float64(b.cfg.Interval)
+	}
+	if b.cfg.Interval <= 0 || e.tokens > float64(b.cfg.Burst) {
+		e.tokens = float64(b.cfg.Burst)
+	}
+	e.updated = now
+	return e.tokens
+}
+
+// Cleanup removes the keys whose buckets are full again and were not used within the window
+func (b *Bucket) Cleanup() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := b.now()
+	for key, e := range b.buckets {
+		if now.Sub(e.updated) > b.cfg.Window && b.refill(key, now) >= float64(b.cfg.Burst) {
+			delete(b.buckets, key)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func newTestBucket(cfg BucketConfig) (*Bucket, *clock) {
+	c := &clock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	b := &Bucket{}
+	b.NewBucket(cfg)
+	b.now = c.Now
+	return b, c
+}
+
+var testBucketConfig = BucketConfig{Burst: 2, Interval: time.Minute, Window: time.Hour}
+
+func TestBucketTake(t *testing.T) {
+	tests := []struct {
+		name     string
+		taken    int
+		advance  time.Duration
+		wantWait time.Duration
+		wantOK   bool
+	}{
+		{name: "full bucket", taken: 0, wantOK: true},
+		{name: "burst not spent", taken: 1, wantOK: true},
+		{name: "burst spent", taken: 2, wantWait: time.Minute},
+		{name: "token partly restored", taken: 2, advance: time.Second * 20, wantWait: time.Second * 40},
+		{name: "token restored", taken: 2, advance: time.Minute, wantOK: true},
+		// the bucket holds at most the burst, a long pause does not allow more
+		{name: "refill capped by the burst", taken: 2, advance: time.Hour * 10, wantOK: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, c := newTestBucket(testBucketConfig)
+			for i := 0; i < tt.taken; i++ {
+				if _, ok := b.Take("key"); !ok {
+					t.Fatalf("Take() %d failed", i)
+				}
+			}
+			c.Advance(tt.advance)
+			wait, ok := b.Take("key")
+			if ok != tt.wantOK || wait != tt.wantWait {
+				t.Errorf("Take() = %s, %v, want %s, %v", wait, ok, tt.wantWait, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestBucketBurstAfterPause(t *testing.T) {
+	b, c := newTestBucket(testBucketConfig)
+	b.Take("key")
+	b.Take("key")
+	c.Advance(time.Hour * 10)
+
+	allowed := 0
+	for i := 0; i < 5; i++ {
+		if _, ok := b.Take("key"); ok {
+			allowed++
+		}
+	}
+	if allowed != testBucketConfig.Burst {
+		t.Errorf("allowed %d requests after a pause, want %d", allowed, testBucketConfig.Burst)
+	}
+}
+
+func TestBucketTakeSeveralKeys(t *testing.T) {
+	b, _ := newTestBucket(testBucketConfig)
+	b.Take("ip", "user")
+	b.Take("ip")
+
+	// the address has no tokens left, so the user keeps its token
+	if _, ok := b.Take("ip", "user"); ok {
+		t.Fatalf("Take() allowed a key without tokens")
+	}
+	if _, ok := b.Take("user"); !ok {
+		t.Errorf("a rejected Take() spent the token of another key")
+	}
+}
+
+func TestBucketCleanup(t *testing.T) {
+	tests := []struct {
+		name     string
+		taken    int
+		advance  time.Duration
+		wantKept bool
+	}{
+		{name: "recent request", taken: 1, advance: time.Minute, wantKept: true},
+		{name: "full and unused", taken: 2, advance: time.Hour + time.Second, wantKept: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, c := newTestBucket(testBucketConfig)
+			for i := 0; i < tt.taken; i++ {
+				b.Take("key")
+			}
+			c.Advance(tt.advance)
+			b.Cleanup()
+			if _, kept := b.buckets["key"]; kept != tt.wantKept {
+				t.Errorf("bucket kept = %v, want %v", kept, tt.wantKept)
+			}
+		})
+	}
+}
@@ -0,0 +1,103 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// Config описывает политику ограничения попыток для одного набора маршрутов
+type Config struct {
+	MaxAttempts int           // количество попыток, после которого ключ блокируется
+	BaseDelay   time.Duration // задержка после первой попытки, удваивается с каждой следующей
+	MaxDelay    time.Duration // верхняя граница прогрессивной задержки
+	Lockout     time.Duration // длительность временной блокировки
+	Window      time.Duration // через сколько без попыток счетчик сбрасывается
+}
+
+type entry struct {
+	attempts    int
+	lastAttempt time.Time
+	nextAllowed time.Time
+}
+
+type Limiter struct {
+	cfg     Config
+	mu      sync.Mutex
+	entries map[string]*entry
+	now     func() time.Time
+}
+
+func (l *Limiter) NewLimiter(cfg Config) {
+	l.cfg = cfg
+	l.entries = make(map[string]*entry)
+	l.now = time.Now
+}
+
+// Check reports whether a new attempt for the key is allowed right now.
+// If it is not, the returned duration tells how long the caller has to wait.
+func (l *Limiter) Check(key string) (time.Duration, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	e, ok := l.entries[key]
+	if !ok {
+		return 0, true
+	}
+	if now.Before(e.nextAllowed) {
+		return e.nextAllowed.Sub(now), false
+	}
+	if now.Sub(e.lastAttempt) > l.cfg.Window {
+		delete(l.entries, key)
+	}
+	return 0, true
+}
+
+// Fail records an attempt for the key and postpones the next allowed one.
+// It returns true if the key has just been locked out.
+func (l *Limiter) Fail(key string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	e, ok := l.entries[key]
+	if !ok || now.Sub(e.lastAttempt) > l.cfg.Window {
+		e = &entry{}
+		l.entries[key] = e
+	}
+	e.attempts++
+	e.lastAttempt = now
+
+	if e.attempts >= l.cfg.MaxAttempts {
+		e.attempts = 0
+		e.nextAllowed = now.Add(l.cfg.Lockout)
+		return true
+	}
+
+	delay := l.cfg.BaseDelay << (e.attempts - 1)
+	if delay > l.cfg.MaxDelay || delay <= 0 {
+		delay = l.cfg.MaxDelay
+	}
+	e.nextAllowed = now.Add(delay)
+	return false
+}
+
+// Reset forgets all attempts recorded for the key
+func (l *Limiter) Reset(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.entries, key)
+}
+
+// Cleanup removes entries that are neither locked nor seen within the window
+func (l *Limiter) Cleanup() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	for key, e := range l.entries {
+		if now.After(e.nextAllowed) && now.Sub(e.lastAttempt) > l.cfg.Window {
+			delete(l.entries, key)
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package ratelimit
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+// clock is a manual clock for the tests, it moves only when told to
+type clock struct {
+	now time.Time
+}
+
+func (c *clock) Now() time.Time {
+	return c.now
+}
+
+func (c *clock) Advance(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+func newTestLimiter(cfg Config) (*Limiter, *clock) {
+	c := &clock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	l := &Limiter{}
+	l.NewLimiter(cfg)
+	l.now = c.Now
+	return l, c
+}
+
+var testConfig = Config{
+	MaxAttempts: 3,
+	BaseDelay:   time.Second,
+	MaxDelay:    time.Second * 3,
+	Lockout:     time.Minute,
+	Window:      time.Hour,
+}
+
+func TestLimiterFail(t *testing.T) {
+	tests := []struct {
+		name       string
+		fails      int
+		advance    time.Duration
+		wantLocked bool
+		wantWait   time.Duration
+		wantOK     bool
+	}{
+		{name: "no attempts", fails: 0, wantOK: true},
+		{name: "first failure waits the base delay", fails: 1, wantWait: time.Second},
+		{name: "delay doubles", fails: 2, wantWait: time.Second * 2},
+		{name: "lockout after max attempts", fails: 3, wantLocked: true, wantWait: time.Minute},
+		{name: "delay passed", fails: 2, advance: time.Second * 2, wantOK: true},
+		{name: "lockout passed", fails: 3, advance: time.Minute, wantLocked: true, wantOK: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l, c := newTestLimiter(testConfig)
+			locked := false
+			for i := 0; i < tt.fails; i++ {
+				locked = l.Fail("key")
+			}
+			if locked != tt.wantLocked {
+				t.Errorf("Fail() locked = %v, want %v", locked, tt.wantLocked)
+			}
+			c.Advance(tt.advance)
+			wait, ok := l.Check("key")
+			if ok != tt.wantOK || wait != tt.wantWait {
+				t.Errorf("Check() = %s, %v, want %s, %v", wait, ok, tt.wantWait, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestLimiterDelayClamp(t *testing.T) {
+	tests := []struct {
+		name  string
+		cfg   Config
+		fails int
+	}{
+		{
+			name:  "delay above the maximum",
+			cfg:   Config{MaxAttempts: 100, BaseDelay: time.Second, MaxDelay: time.Second * 10, Window: time.Hour},
+			fails: 10,
+		},
+		{
+			// time.Hour << 22 does not fit into int64 and turns negative
+			name:  "shift overflows to a negative delay",
+			cfg:   Config{MaxAttempts: 100, BaseDelay: time.Hour, MaxDelay: math.MaxInt64 / 2, Window: time.Hour},
+			fails: 23,
+		},
+		{
+			name:  "shift past the width of the duration",
+			cfg:   Config{MaxAttempts: 100, BaseDelay: time.Second, MaxDelay: math.MaxInt64 / 2, Window: time.Hour},
+			fails: 70,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l, _ := newTestLimiter(tt.cfg)
+			for i := 0; i < tt.fails; i++ {
+				l.Fail("key")
+			}
+			if wait, ok := l.Check("key"); ok || wait != tt.cfg.MaxDelay {
+				t.Errorf("Check() = %s, %v, want %s, false", wait, ok, tt.cfg.MaxDelay)
+			}
+		})
+	}
+}
+
+func TestLimiterWindow(t *testing.T) {
+	tests := []struct {
+		name     string
+		advance  time.Duration
+		wantWait time.Duration
+	}{
+		// the third failure within the window locks the key out
+		{name: "within the window", advance: time.Minute, wantWait: time.Minute},
+		// after the window the count starts over and the next failure waits the base delay
+		{name: "after the window", advance: time.Hour + time.Second, wantWait: time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l, c := newTestLimiter(testConfig)
+			l.Fail("key")
+			l.Fail("key")
+			c.Advance(tt.advance)
+			l.Fail("key")
+			if wait, _ := l.Check("key"); wait != tt.wantWait {
+				t.Errorf("Check() wait = %s, want %s", wait, tt.wantWait)
+			}
+		})
+	}
+}
+
+func TestLimiterReset(t *testing.T) {
+	l, _ := newTestLimiter(testConfig)
+	l.Fail("key")
+	l.Fail("other")
+	l.Reset("key")
+
+	if wait, ok := l.Check("key"); !ok || wait != 0 {
+		t.Errorf("Check() after Reset = %s, %v, want 0, true", wait, ok)
+	}
+	if _, ok := l.Check("other"); ok {
+		t.Errorf("Reset removed another key")
+	}
+}
+
+func TestLimiterCleanup(t *testing.T) {
+	tests := []struct {
+		name     string
+		fails    int
+		advance  time.Duration
+		wantKept bool
+	}{
+		{name: "recent attempt", fails: 1, advance: time.Minute, wantKept: true},
+		{name: "attempt outside the window", fails: 1, advance: time.Hour + time.Second, wantKept: false},
+		// a lockout longer than the window must survive the cleanup
+		{name: "locked key", fails: 3, advance: time.Hour + time.Second, wantKept: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testConfig
+			cfg.Lockout = time.Hour * 2
+			l, c := newTestLimiter(cfg)
+			for i := 0; i < tt.fails; i++ {
+				l.Fail("key")
+			}
+			c.Advance(tt.advance)
+			l.Cleanup()
+			if _, kept := l.entries["key"]; kept != tt.wantKept {
+				t.Errorf("entry kept = %v, want %v", kept, tt.wantKept)
+			}
+		})
+	}
+}
@@ -0,0 +1,121 @@
+package repository
+
+import (
+	"feklistova/models"
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// CreateAuditEvent appends an event to the audit log
+func (r *Repository) CreateAuditEvent(ctx context.Context, event *models.AuditEvent) error {
+	var actorID sql.NullInt64
+	if event.ActorID != 0 {
+		actorID = sql.NullInt64{Int64: int64(event.ActorID), Valid: true}
+	}
+
+	err := r.Db.QueryRowContext(ctx, `
+        INSERT INTO audit_events (actor_id, action, target, ip_address, user_agent, outcome, created_at)
+        VALUES ($1, $2, $3, $4, $5, $6, $7)
+        RETURNING event_id`,
+		actorID, event.Action, event.Target, event.IPAddress, event.UserAgent, event.Outcome, event.CreatedAt,
+	).Scan(&event.EventID)
+	if err != nil {
+		return errors.Wrap(err, "failed to insert audit event")
+	}
+	return nil
+}
+
+// HasAuditEventFromIP reports whether the actor already has a successful event of the action from the IP address
+func (r *Repository) HasAuditEventFromIP(ctx context.Context, actorID int, action, ip string) (bool, error) {
+	var exists bool
+	err := r.Db.QueryRowContext(ctx, `
+        SELECT EXISTS (
+            SELECT 1 FROM audit_events
+            WHERE actor_id = $1 AND action = $2 AND ip_address = $3 AND outcome = 'success'
+        )`, actorID, action, ip).Scan(&exists)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to query audit events")
+	}
+	return exists, nil
+}
+
+// GetAuditEvents returns audit events matching the filter ordered from the newest
+func (r *Repository) GetAuditEvents(ctx context.Context, filter models.AuditFilter) ([]models.AuditEvent, error) {
+	var conditions []string
+	var args []interface{}
+	addCondition := func(condition string, value interface{}) {
+		args = append(args, value)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+
+	if filter.ActorID != 0 {
+		addCondition("actor_id = $%d", filter.ActorID)
+	}
+	if filter.Action != "" {
+		addCondition("action = $%d", filter.Action)
+	}
+	if filter.Target != "" {
+		addCondition("target = $%d", filter.Target)
+	}
+	if filter.Outcome != "" {
+		addCondition("outcome = $%d", filter.Outcome)
+	}
+	if filter.IP != "" {
+		addCondition("ip_address = $%d", filter.IP)
+	}
+	if !filter.From.IsZero() {
+		addCondition("created_at >= $%d", filter.From)
+	}
+	if !filter.To.IsZero() {
+		addCondition("created_at < $%d", filter.To)
+	}
+
+	query := `
+        SELECT event_id, COALESCE(actor_id, 0), action, target, ip_address, user_agent, outcome, created_at
+        FROM audit_events`
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	query += " ORDER BY created_at DESC, event_id DESC"
+	if filter.Limit > 0 {
+		args = append(args, filter.Limit)
+		query += fmt.Sprintf(" LIMIT $%d", len(args))
+	}
+	if filter.Offset > 0 {
+		args = append(args, filter.Offset)
+		query += fmt.Sprintf(" OFFSET $%d", len(args))
+	}
+
+	rows, err := r.Db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to query audit events")
+	}
+	defer rows.Close()
+
+	var events []models.AuditEvent
+	for rows.Next() {
+		var event models.AuditEvent
+		if err := rows.Scan(
+			&event.EventID,
+			&event.ActorID,
+			&event.Action,
+			&event.Target,
+			&event.IPAddress,
+			&event.UserAgent,
+			&event.Outcome,
+			&event.CreatedAt,
+		); err != nil {
+			return nil, errors.Wrap(err, "failed to scan audit event row")
+		}
+		events = append(events, event)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "error occurred during iteration")
+	}
+
+	return events, nil
+}
@@ -0,0 +1,145 @@
+package repository
+
+import (
+	"feklistova/models"
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// CreateDataExport registers a new data export job and sets it's ID
+func (r *Repository) CreateDataExport(ctx context.Context, export *models.DataExport) error {
+	err := r.Db.QueryRowContext(ctx, `
+        INSERT INTO data_exports (user_id, token, status, created_at, expires_at)
+        VALUES ($1, $2, $3, $4, $5)
+        RETURNING export_id`,
+		export.UserID, export.Token, export.Status, export.CreatedAt, export.ExpiresAt,
+	).Scan(&export.ExportID)
+	if err != nil {
+		return errors.Wrap(err, "failed to insert data export")
+	}
+	return nil
+}
+
+// UpdateDataExport saves the status, the archive path and the link expiry of the data export
+func (r *Repository) UpdateDataExport(ctx context.Context, export *models.DataExport) error {
+	_, err := r.Db.ExecContext(ctx, `
+        UPDATE data_exports
+        SET status = $1, filepath = $2, expires_at = $3
+        WHERE export_id = $4`,
+		export.Status, export.FilePath, export.ExpiresAt, export.ExportID)
+	if err != nil {
+		return errors.Wrap(err, "failed to update data export")
+	}
+	return nil
+}
+
+func (r *Repository) GetDataExportByToken(ctx context.Context, token string) (*models.DataExport, error) {
+	export, err := scanDataExport(r.Db.QueryRowContext(ctx, `
+        SELECT export_id, user_id, token, status, filepath, created_at, expires_at
+        FROM data_exports
+        WHERE token = $1`, token))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("data export not found")
+		}
+		return nil, errors.Wrap(err, "failed to scan data export")
+	}
+	return export, nil
+}
+
+// GetLatestDataExport returns the newest data export of the user or nil if there are none
+func (r *Repository) GetLatestDataExport(ctx context.Context, userID int) (*models.DataExport, error) {
+	export, err := scanDataExport(r.Db.QueryRowContext(ctx, `
+        SELECT export_id, user_id, token, status, filepath, created_at, expires_at
+        FROM data_exports
+        WHERE user_id = $1
+        ORDER BY created_at DESC
+        LIMIT 1`, userID))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, errors.Wrap(err, "failed to scan data export")
+	}
+	return export, nil
+}
+
+func (r *Repository) GetDataExportsByUserID(ctx context.Context, userID int) ([]models.DataExport, error) {
+	return r.queryDataExports(ctx, `
+        SELECT export_id, user_id, token, status, filepath, created_at, expires_at
+        FROM data_exports
+        WHERE user_id = $1`, userID)
+}
+
+// GetExpiredDataExports returns data exports whose download link has expired
+func (r *Repository) GetExpiredDataExports(ctx context.Context, now time.Time) ([]models.DataExport, error) {
+	return r.queryDataExports(ctx, `
+        SELECT export_id, user_id, token, status, filepath, created_at, expires_at
+        FROM data_exports
+        WHERE expires_at < $1`, now)
+}
+
+// GetStaleDataExports returns data exports still pending since before the moment
+func (r *Repository) GetStaleDataExports(ctx context.Context, createdBefore time.Time) ([]models.DataExport, error) {
+	return r.queryDataExports(ctx, `
+        SELECT export_id, user_id, token, status, filepath, created_at, expires_at
+        FROM data_exports
+        WHERE status = 'pending' AND created_at < $1`, createdBefore)
+}
+
+// DeleteDataExport deletes a data export from the database by its export ID
+func (r *Repository) DeleteDataExport(ctx context.Context, exportID int) error {
+	_, err := r.Db.ExecContext(ctx, "DELETE FROM data_exports WHERE export_id = $1", exportID)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *Repository) queryDataExports(ctx context.Context, query string, args ...interface{}) ([]models.DataExport, error) {
+	var exports []models.DataExport
+
+	rows, err := r.Db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to query data exports")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		export, err := scanDataExport(rows)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to scan data export row")
+		}
+		exports = append(exports, *export)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "error occurred during iteration")
+	}
+
+	return exports, nil
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanDataExport(row rowScanner) (*models.DataExport, error) {
+	var export models.DataExport
+	err := row.Scan(
+		&export.ExportID,
+		&export.UserID,
+		&export.Token,
+		&export.Status,
+		&export.FilePath,
+		&export.CreatedAt,
+		&export.ExpiresAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &export, nil
+}
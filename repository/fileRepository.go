@@ -5,6 +5,7 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
+	"time"
 
 	"github.com/pkg/errors"
 )
@@ -15,16 +16,16 @@ func (r *Repository) CreateFile(ctx context.Context, file *models.File, isDownlo
 	var err error
 	if isDownloaded {
 		err = r.Db.QueryRowContext(ctx, `
-			INSERT INTO downloaded_files (shipment_id, filepath, timestamp)
-			VALUES ($1, $2, $3)
+			INSERT INTO downloaded_files (shipment_id, filepath, original_name, size, timestamp)
+			VALUES ($1, $2, $3, $4, $5)
 			RETURNING file_id`,
-			file.ShipmentID, file.FilePath, file.Timestamp).Scan(&fileID)
+			file.ShipmentID, file.FilePath, file.OriginalName, file.Size, file.Timestamp).Scan(&fileID)
 	} else {
 		err = r.Db.QueryRowContext(ctx, `
-			INSERT INTO model_files (shipment_id, filepath, timestamp)
-			VALUES ($1, $2, $3)
+			INSERT INTO model_files (shipment_id, filepath, size, timestamp)
+			VALUES ($1, $2, $3, $4)
 			RETURNING file_id`,
-			file.ShipmentID, file.FilePath, file.Timestamp).Scan(&fileID)
+			file.ShipmentID, file.FilePath, file.Size, file.Timestamp).Scan(&fileID)
 	}
 	if err != nil {
 		return err
@@ -34,7 +35,7 @@ func (r *Repository) CreateFile(ctx context.Context, file *models.File, isDownlo
 	return nil
 }
 
-func (r *Repository) CreateModelFile(ctx context.Context, file *models.File, metrics []models.ModelMetrics) error {
+func (r *Repository) CreateModelFile(ctx context.Context, file *models.File, metrics []models.ModelMetrics, details models.ModelDetails) error {
 	tx, err := r.Db.BeginTx(ctx, nil)
 	if err != nil {
 		return fmt.Errorf("failed to begin transaction: %v", err)
@@ -57,7 +58,26 @@ func (r *Repository) CreateModelFile(ctx context.Context, file *models.File, met
 
 	fileID := file.FileID
 
-	err = r.saveModelMetrics(ctx, tx, fileID, metrics)
+	err = r.saveModelMetrics(ctx, tx, fileID, metrics, details.MetricStd)
+	if err != nil {
+		return err
+	}
+
+	if details.Report != nil {
+		err = saveClassificationReport(ctx, tx, fileID, details.Report)
+		if err != nil {
+			return err
+		}
+	}
+
+	if details.Forecast != nil {
+		err = saveForecast(ctx, tx, fileID, details.Forecast)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = saveDatasetSchema(ctx, tx, fileID, details.Schema)
 	if err != nil {
 		return err
 	}
@@ -68,10 +88,10 @@ func (r *Repository) CreateModelFile(ctx context.Context, file *models.File, met
 func (r *Repository) insertFile(ctx context.Context, tx *sql.Tx, file *models.File) error {
 	var fileID int
 	err := tx.QueryRowContext(ctx, `
-		INSERT INTO model_files (shipment_id, filepath, timestamp)
-		VALUES ($1, $2, $3)
+		INSERT INTO model_files (shipment_id, filepath, size, timestamp)
+		VALUES ($1, $2, $3, $4)
 		RETURNING file_id`,
-		file.ShipmentID, file.FilePath, file.Timestamp).Scan(&fileID)
+		file.ShipmentID, file.FilePath, file.Size, file.Timestamp).Scan(&fileID)
 	if err != nil {
 		return fmt.Errorf("failed to insert file: %v", err)
 	}
@@ -118,7 +138,7 @@ func (r *Repository) GetDownloadedFilesByShipmentID(ctx context.Context, shipmen
 	var files []models.File
 
 	downloadedQuery := `
-        SELECT file_id, shipment_id, filepath, timestamp FROM downloaded_files WHERE shipment_id = $1
+        SELECT file_id, shipment_id, filepath, original_name, size, timestamp FROM downloaded_files WHERE shipment_id = $1
     `
 	downloadedRows, err := r.Db.QueryContext(ctx, downloadedQuery, shipmentID)
 	if err != nil {
@@ -128,7 +148,7 @@ func (r *Repository) GetDownloadedFilesByShipmentID(ctx context.Context, shipmen
 
 	for downloadedRows.Next() {
 		var file models.File
-		if err := downloadedRows.Scan(&file.FileID, &file.ShipmentID, &file.FilePath, &file.Timestamp); err != nil {
+		if err := downloadedRows.Scan(&file.FileID, &file.ShipmentID, &file.FilePath, &file.OriginalName, &file.Size, &file.Timestamp); err != nil {
 			return nil, errors.Wrap(err, "failed to scan downloaded file row")
 		}
 		files = append(files, file)
@@ -160,10 +180,72 @@ func (r *Repository) GetUploadedFilesByShipmentID(ctx context.Context, shipmentI
 	return files, nil
 }
 
-func (r *Repository) saveModelMetrics(ctx context.Context, tx *sql.Tx, fileID int, metrics []models.ModelMetrics) error {
+// GetFilesByUserID returns all downloaded and model files that belong to the user's shipments
+func (r *Repository) GetFilesByUserID(ctx context.Context, userID int) ([]models.File, []models.File, error) {
+	downloaded, err := r.queryFiles(ctx, `
+        SELECT f.file_id, f.shipment_id, f.filepath, f.timestamp
+        FROM downloaded_files f JOIN shipments s ON s.shipment_id = f.shipment_id
+        WHERE s.user_id = $1
+    `, userID)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to query downloaded files")
+	}
+
+	uploaded, err := r.queryFiles(ctx, `
+        SELECT f.file_id, f.shipment_id, f.filepath, f.timestamp
+        FROM model_files f JOIN shipments s ON s.shipment_id = f.shipment_id
+        WHERE s.user_id = $1
+    `, userID)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to query uploaded files")
+	}
+
+	return downloaded, uploaded, nil
+}
+
+// GetExpiredDatasets returns the datasets of shipments whose training has ended before the moment
+func (r *Repository) GetExpiredDatasets(ctx context.Context, finishedBefore time.Time, limit int) ([]models.File, error) {
+	files, err := r.queryFiles(ctx, `
+        SELECT f.file_id, f.shipment_id, f.filepath, f.timestamp
+        FROM downloaded_files f JOIN shipments s ON s.shipment_id = f.shipment_id
+        WHERE s.finished_at < $1
+        ORDER BY s.finished_at
+        LIMIT $2
+    `, finishedBefore, limit)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to query expired datasets")
+	}
+	return files, nil
+}
+
+func (r *Repository) queryFiles(ctx context.Context, query string, args ...interface{}) ([]models.File, error) {
+	var files []models.File
+
+	rows, err := r.Db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var file models.File
+		if err := rows.Scan(&file.FileID, &file.ShipmentID, &file.FilePath, &file.Timestamp); err != nil {
+			return nil, errors.Wrap(err, "failed to scan file row")
+		}
+		files = append(files, file)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "error occurred during iteration")
+	}
+
+	return files, nil
+}
+
+// saveModelMetrics saves the metrics, std holds the deviation over the cross-validation folds and is empty for other runs
+func (r *Repository) saveModelMetrics(ctx context.Context, tx *sql.Tx, fileID int, metrics []models.ModelMetrics, std map[string]float64) error {
 	stmt, err := tx.PrepareContext(ctx, `
-        INSERT INTO model_metrics (file_id, metric_name, metric_value)
-        VALUES ($1, $2, $3)
+        INSERT INTO model_metrics (file_id, metric_name, metric_value, metric_std)
+        VALUES ($1, $2, $3, $4)
     `)
 	if err != nil {
 		return errors.Wrap(err, "failed to prepare statement")
@@ -171,7 +253,11 @@ func (r *Repository) saveModelMetrics(ctx context.Context, tx *sql.Tx, fileID in
 	defer stmt.Close()
 
 	for _, metric := range metrics {
-		_, err := stmt.ExecContext(ctx, fileID, metric.MetricName, metric.MetricValue)
+		var metricStd sql.NullFloat64
+		if value, ok := std[metric.MetricName]; ok {
+			metricStd = sql.NullFloat64{Float64: value, Valid: true}
+		}
+		_, err := stmt.ExecContext(ctx, fileID, metric.MetricName, metric.MetricValue, metricStd)
 		if err != nil {
 			return errors.Wrap(err, "failed to insert metric")
 		}
@@ -180,6 +266,85 @@ func (r *Repository) saveModelMetrics(ctx context.Context, tx *sql.Tx, fileID in
 	return nil
 }
 
+func saveClassificationReport(ctx context.Context, tx *sql.Tx, fileID int, report *models.ClassificationReport) error {
+	for i, class := range report.Classes {
+		_, err := tx.ExecContext(ctx, `
+            INSERT INTO model_class_metrics (file_id, class_index, label, precision, recall, f1_score, support)
+            VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+			fileID, i, class.Label, class.Precision, class.Recall, class.F1Score, class.Support)
+		if err != nil {
+			return errors.Wrap(err, "failed to insert class metrics")
+		}
+	}
+	for actual, row := range report.ConfusionMatrix {
+		for predicted, count := range row {
+			_, err := tx.ExecContext(ctx, `
+                INSERT INTO model_confusion_matrix (file_id, actual_index, predicted_index, count)
+                VALUES ($1, $2, $3, $4)`, fileID, actual, predicted, count)
+			if err != nil {
+				return errors.Wrap(err, "failed to insert confusion matrix cell")
+			}
+		}
+	}
+	return nil
+}
+
+// GetClassificationReport returns the per-class metrics and the confusion matrix of the model.
+// Regression models and models trained before the report was introduced have none, nil is returned for them.
+func (r *Repository) GetClassificationReport(ctx context.Context, fileID int) (*models.ClassificationReport, error) {
+	rows, err := r.Db.QueryContext(ctx, `
+        SELECT label, precision, recall, f1_score, support
+        FROM model_class_metrics
+        WHERE file_id = $1
+        ORDER BY class_index`, fileID)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to query class metrics")
+	}
+	defer rows.Close()
+
+	report := &models.ClassificationReport{}
+	for rows.Next() {
+		var class models.ClassMetrics
+		if err := rows.Scan(&class.Label, &class.Precision, &class.Recall, &class.F1Score, &class.Support); err != nil {
+			return nil, errors.Wrap(err, "failed to scan class metrics row")
+		}
+		report.Classes = append(report.Classes, class)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "error occurred during iteration")
+	}
+	if len(report.Classes) == 0 {
+		return nil, nil
+	}
+
+	report.ConfusionMatrix = make([][]int, len(report.Classes))
+	for i := range report.ConfusionMatrix {
+		report.ConfusionMatrix[i] = make([]int, len(report.Classes))
+	}
+	cells, err := r.Db.QueryContext(ctx, `
+        SELECT actual_index, predicted_index, count
+        FROM model_confusion_matrix
+        WHERE file_id = $1`, fileID)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to query confusion matrix")
+	}
+	defer cells.Close()
+
+	for cells.Next() {
+		var actual, predicted, count int
+		if err := cells.Scan(&actual, &predicted, &count); err != nil {
+			return nil, errors.Wrap(err, "failed to scan confusion matrix row")
+		}
+		if actual < len(report.Classes) && predicted < len(report.Classes) {
+			report.ConfusionMatrix[actual][predicted] = count
+		}
+	}
+	if err := cells.Err(); err != nil {
+		return nil, errors.Wrap(err, "error occurred during iteration")
+	}
+	return report, nil
+}
+
 func (r *Repository) GetMetricsByFileID(ctx context.Context, fileID int) (map[string]float64, error) {
 	metrics := make(map[string]float64)
 
@@ -207,3 +372,170 @@ func (r *Repository) GetMetricsByFileID(ctx context.Context, fileID int) (map[st
 
 	return metrics, nil
 }
+
+// GetMetricStdByFileID returns the deviation of the metrics over the cross-validation folds,
+// the map is empty for models evaluated on a single split
+func (r *Repository) GetMetricStdByFileID(ctx context.Context, fileID int) (map[string]float64, error) {
+	std := make(map[string]float64)
+
+	rows, err := r.Db.QueryContext(ctx, `
+        SELECT metric_name, metric_std
+        FROM model_metrics
+        WHERE file_id = $1 AND metric_std IS NOT NULL
+    `, fileID)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to execute query")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var metricName string
+		var metricStd float64
+		if err := rows.Scan(&metricName, &metricStd); err != nil {
+			return nil, errors.Wrap(err, "failed to scan row")
+		}
+		std[metricName] = metricStd
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "error occurred during iteration")
+	}
+
+	return std, nil
+}
+
+// GetMetricsByShipmentID returns the metrics of the models trained by the shipment
+func (r *Repository) GetMetricsByShipmentID(ctx context.Context, shipmentID int) (map[string]float64, error) {
+	metrics := make(map[string]float64)
+
+	rows, err := r.Db.QueryContext(ctx, `
+        SELECT m.metric_name, m.metric_value
+        FROM model_metrics m
+        JOIN model_files f ON f.file_id = m.file_id
+        WHERE f.shipment_id = $1
+    `, shipmentID)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to execute query")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var metricName string
+		var metricValue float64
+		if err := rows.Scan(&metricName, &metricValue); err != nil {
+			return nil, errors.Wrap(err, "failed to scan row")
+		}
+		metrics[metricName] = metricValue
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "error occurred during iteration")
+	}
+
+	return metrics, nil
+}
+
+func saveForecast(ctx context.Context, tx *sql.Tx, fileID int, forecast *models.Forecast) error {
+	stmt, err := tx.PrepareContext(ctx, `
+        INSERT INTO model_forecast_points (file_id, series_index, series_id, predicted, point_index, period, value, naive)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`)
+	if err != nil {
+		return errors.Wrap(err, "failed to prepare statement")
+	}
+	defer stmt.Close()
+
+	for i, series := range forecast.Series {
+		for j, point := range series.History {
+			if _, err := stmt.ExecContext(ctx, fileID, i, series.SeriesID, false, j, point.Period, point.Value, series.Naive); err != nil {
+				return errors.Wrap(err, "failed to insert forecast point")
+			}
+		}
+		for j, point := range series.Predictions {
+			if _, err := stmt.ExecContext(ctx, fileID, i, series.SeriesID, true, j, point.Period, point.Value, series.Naive); err != nil {
+				return errors.Wrap(err, "failed to insert forecast point")
+			}
+		}
+	}
+	return nil
+}
+
+// GetForecast returns the history and the forecast of the series shown on the chart.
+// Models other than forecasts have none, nil is returned for them.
+func (r *Repository) GetForecast(ctx context.Context, fileID int) (*models.Forecast, error) {
+	rows, err := r.Db.QueryContext(ctx, `
+        SELECT series_index, series_id, predicted, period, value, naive
+        FROM model_forecast_points
+        WHERE file_id = $1
+        ORDER BY series_index, predicted, point_index`, fileID)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to query forecast points")
+	}
+	defer rows.Close()
+
+	forecast := &models.Forecast{}
+	for rows.Next() {
+		var seriesIndex int
+		var seriesID string
+		var predicted, naive bool
+		var point models.ForecastPoint
+		if err := rows.Scan(&seriesIndex, &seriesID, &predicted, &point.Period, &point.Value, &naive); err != nil {
+			return nil, errors.Wrap(err, "failed to scan forecast point")
+		}
+		for len(forecast.Series) <= seriesIndex {
+			forecast.Series = append(forecast.Series, models.ForecastSeries{})
+		}
+		series := &forecast.Series[seriesIndex]
+		series.SeriesID = seriesID
+		series.Naive = naive
+		if predicted {
+			series.Predictions = append(series.Predictions, point)
+		} else {
+			series.History = append(series.History, point)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "error occurred during iteration")
+	}
+	if len(forecast.Series) == 0 {
+		return nil, nil
+	}
+	return forecast, nil
+}
+
+func saveDatasetSchema(ctx context.Context, tx *sql.Tx, fileID int, schema []models.DatasetColumn) error {
+	for i, column := range schema {
+		_, err := tx.ExecContext(ctx, `
+            INSERT INTO model_dataset_columns (file_id, column_index, name, type, detected)
+            VALUES ($1, $2, $3, $4, $5)`,
+			fileID, i, column.Name, column.Type, column.Detected)
+		if err != nil {
+			return errors.Wrap(err, "failed to insert dataset column")
+		}
+	}
+	return nil
+}
+
+// GetDatasetSchema returns the columns of the dataset with the types they were used as.
+// Only classification and regression scripts report the schema, for other models it is empty.
+func (r *Repository) GetDatasetSchema(ctx context.Context, fileID int) ([]models.DatasetColumn, error) {
+	rows, err := r.Db.QueryContext(ctx, `
+        SELECT name, type, detected
+        FROM model_dataset_columns
+        WHERE file_id = $1
+        ORDER BY column_index`, fileID)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to query dataset columns")
+	}
+	defer rows.Close()
+
+	var schema []models.DatasetColumn
+	for rows.Next() {
+		var column models.DatasetColumn
+		if err := rows.Scan(&column.Name, &column.Type, &column.Detected); err != nil {
+			return nil, errors.Wrap(err, "failed to scan dataset column")
+		}
+		schema = append(schema, column)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "error occurred during iteration")
+	}
+	return schema, nil
+}
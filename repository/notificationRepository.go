@@ -0,0 +1,41 @@
+package repository
+
+import (
+	"feklistova/models"
+	"context"
+	"database/sql"
+
+	"github.com/pkg/errors"
+)
+
+// GetNotificationPreferences returns the email notification settings of the user,
+// a user who never saved them gets all notifications switched off
+func (r *Repository) GetNotificationPreferences(ctx context.Context, userID int) (*models.NotificationPreferences, error) {
+	prefs := &models.NotificationPreferences{UserID: userID}
+	err := r.Db.QueryRowContext(ctx, `
+        SELECT notify_finished, notify_failed, notify_denied
+        FROM notification_preferences
+        WHERE user_id = $1`, userID).Scan(&prefs.Finished, &prefs.Failed, &prefs.Denied)
+	if errors.Is(err, sql.ErrNoRows) {
+		return prefs, nil
+	} else if err != nil {
+		return nil, errors.Wrap(err, "failed to query notification preferences")
+	}
+	return prefs, nil
+}
+
+// SaveNotificationPreferences creates or replaces the email notification settings of the user
+func (r *Repository) SaveNotificationPreferences(ctx context.Context, prefs *models.NotificationPreferences) error {
+	_, err := r.Db.ExecContext(ctx, `
+        INSERT INTO notification_preferences (user_id, notify_finished, notify_failed, notify_denied)
+        VALUES ($1, $2, $3, $4)
+        ON CONFLICT (user_id) DO UPDATE
+        SET notify_finished = EXCLUDED.notify_finished,
+            notify_failed = EXCLUDED.notify_failed,
+            notify_denied = EXCLUDED.notify_denied`,
+		prefs.UserID, prefs.Finished, prefs.Failed, prefs.Denied)
+	if err != nil {
+		return errors.Wrap(err, "failed to save notification preferences")
+	}
+	return nil
+}
@@ -0,0 +1,171 @@
+package repository
+
+import (
+	"feklistova/models"
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/pkg/errors"
+)
+
+const projectColumns = "project_id, user_id, name, description, default_model_type, default_target_column, created_at"
+
+// CreateProject registers a new project in the database and sets it's ID
+func (r *Repository) CreateProject(ctx context.Context, project *models.Project) error {
+	err := r.Db.QueryRowContext(ctx, `
+        INSERT INTO projects (user_id, name, description, default_model_type, default_target_column, created_at)
+        VALUES ($1, $2, $3, $4, $5, $6)
+        RETURNING project_id`,
+		project.UserID,
+		project.Name,
+		project.Description,
+		project.DefaultModelType,
+		project.DefaultTargetColumn,
+		project.CreatedAt,
+	).Scan(&project.ProjectID)
+	if err != nil {
+		return errors.Wrap(err, "failed to insert project")
+	}
+	return nil
+}
+
+// GetOrCreateProject returns the user's project with the name, a new project is created when there is none
+func (r *Repository) GetOrCreateProject(ctx context.Context, project *models.Project) error {
+	err := r.Db.QueryRowContext(ctx, `
+        INSERT INTO projects (user_id, name, description, default_model_type, default_target_column, created_at)
+        VALUES ($1, $2, $3, $4, $5, $6)
+        ON CONFLICT (user_id, name) DO UPDATE SET name = EXCLUDED.name
+        RETURNING `+projectColumns,
+		project.UserID,
+		project.Name,
+		project.Description,
+		project.DefaultModelType,
+		project.DefaultTargetColumn,
+		project.CreatedAt,
+	).Scan(
+		&project.ProjectID,
+		&project.UserID,
+		&project.Name,
+		&project.Description,
+		&project.DefaultModelType,
+		&project.DefaultTargetColumn,
+		&project.CreatedAt,
+	)
+	if err != nil {
+		return errors.Wrap(err, "failed to get or create project")
+	}
+	return nil
+}
+
+func (r *Repository) GetProjectByID(ctx context.Context, projectID int) (*models.Project, error) {
+	project, err := scanProject(r.Db.QueryRowContext(ctx, `
+        SELECT `+projectColumns+` FROM projects WHERE project_id = $1`, projectID))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("project with ID %d not found", projectID)
+		}
+		return nil, errors.Wrap(err, "failed to scan project")
+	}
+	return project, nil
+}
+
+// GetProjectsByUserID returns all projects of the user ordered by name
+func (r *Repository) GetProjectsByUserID(ctx context.Context, userID int) ([]models.Project, error) {
+	rows, err := r.Db.QueryContext(ctx, `
+        SELECT `+projectColumns+` FROM projects WHERE user_id = $1 ORDER BY name`, userID)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to query projects")
+	}
+	defer rows.Close()
+
+	var projects []models.Project
+	for rows.Next() {
+		project, err := scanProject(rows)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to scan project row")
+		}
+		projects = append(projects, *project)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "error occurred during iteration")
+	}
+
+	return projects, nil
+}
+
+// UpdateProject saves the name, description and defaults of the project
+func (r *Repository) UpdateProject(ctx context.Context, project *models.Project) error {
+	tx, err := r.Db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `
+        UPDATE projects
+        SET name = $1, description = $2, default_model_type = $3, default_target_column = $4
+        WHERE project_id = $5`,
+		project.Name, project.Description, project.DefaultModelType, project.DefaultTargetColumn, project.ProjectID)
+	if err != nil {
+		return errors.Wrap(err, "failed to update project")
+	}
+
+	// the project name is kept on the runs as well
+	_, err = tx.ExecContext(ctx, "UPDATE shipments SET projectName = $1 WHERE project_id = $2", project.Name, project.ProjectID)
+	if err != nil {
+		return errors.Wrap(err, "failed to update project runs")
+	}
+
+	return tx.Commit()
+}
+
+// GetProjectRunMetrics returns metrics of all runs of the project by shipment ID
+func (r *Repository) GetProjectRunMetrics(ctx context.Context, projectID int) (map[int]map[string]float64, error) {
+	rows, err := r.Db.QueryContext(ctx, `
+        SELECT f.shipment_id, m.metric_name, m.metric_value
+        FROM model_metrics m
+        JOIN model_files f ON f.file_id = m.file_id
+        JOIN shipments s ON s.shipment_id = f.shipment_id
+        WHERE s.project_id = $1`, projectID)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to query project metrics")
+	}
+	defer rows.Close()
+
+	metrics := make(map[int]map[string]float64)
+	for rows.Next() {
+		var shipmentID int
+		var metricName string
+		var metricValue float64
+		if err := rows.Scan(&shipmentID, &metricName, &metricValue); err != nil {
+			return nil, errors.Wrap(err, "failed to scan metric row")
+		}
+		if metrics[shipmentID] == nil {
+			metrics[shipmentID] = make(map[string]float64)
+		}
+		metrics[shipmentID][metricName] = metricValue
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "error occurred during iteration")
+	}
+
+	return metrics, nil
+}
+
+func scanProject(row rowScanner) (*models.Project, error) {
+	var project models.Project
+	err := row.Scan(
+		&project.ProjectID,
+		&project.UserID,
+		&project.Name,
+		&project.Description,
+		&project.DefaultModelType,
+		&project.DefaultTargetColumn,
+		&project.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &project, nil
+}
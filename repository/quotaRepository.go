@@ -0,0 +1,73 @@
+package repository
+
+import (
+	"feklistova/models"
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ApplyQuotaOverrides replaces the plan limits in quota with the limits set for the user personally
+func (r *Repository) ApplyQuotaOverrides(ctx context.Context, userID int, quota *models.Quota) error {
+	var maxStorage, maxDataset sql.NullInt64
+	var maxConcurrent, maxTraining sql.NullInt32
+
+	err := r.Db.QueryRowContext(ctx, `
+        SELECT max_storage_bytes, max_concurrent_shipments, max_training_minutes, max_dataset_bytes
+        FROM user_quotas
+        WHERE user_id = $1`, userID).Scan(&maxStorage, &maxConcurrent, &maxTraining, &maxDataset)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	} else if err != nil {
+		return errors.Wrap(err, "failed to query user quota")
+	}
+
+	if maxStorage.Valid {
+		quota.MaxStorageBytes = maxStorage.Int64
+	}
+	if maxConcurrent.Valid {
+		quota.MaxConcurrentShipments = int(maxConcurrent.Int32)
+	}
+	if maxTraining.Valid {
+		quota.MaxTrainingMinutes = int(maxTraining.Int32)
+	}
+	if maxDataset.Valid {
+		quota.MaxDatasetBytes = maxDataset.Int64
+	}
+	return nil
+}
+
+// GetQuotaUsage returns the resources used by the user, training time is counted from the since moment
+func (r *Repository) GetQuotaUsage(ctx context.Context, userID int, since time.Time) (*models.QuotaUsage, error) {
+	usage := &models.QuotaUsage{}
+
+	err := r.Db.QueryRowContext(ctx, `
+        SELECT
+            COALESCE((SELECT SUM(f.size) FROM downloaded_files f JOIN shipments s ON s.shipment_id = f.shipment_id WHERE s.user_id = $1), 0) +
+            COALESCE((SELECT SUM(f.size) FROM model_files f JOIN shipments s ON s.shipment_id = f.shipment_id WHERE s.user_id = $1), 0)`,
+		userID).Scan(&usage.StoredBytes)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to query stored bytes")
+	}
+
+	err = r.Db.QueryRowContext(ctx, `
+        SELECT COUNT(*) FROM shipments
+        WHERE user_id = $1 AND status IN ('accepted', 'in progress')`,
+		userID).Scan(&usage.RunningShipments)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to count running shipments")
+	}
+
+	err = r.Db.QueryRowContext(ctx, `
+        SELECT COALESCE(SUM(EXTRACT(EPOCH FROM (COALESCE(finished_at, $3) - timestamp))), 0) / 60
+        FROM shipments
+        WHERE user_id = $1 AND timestamp >= $2`,
+		userID, since, time.Now()).Scan(&usage.TrainingMinutes)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to query training time")
+	}
+
+	return usage, nil
+}
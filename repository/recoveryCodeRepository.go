@@ -0,0 +1,72 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/pkg/errors"
+)
+
+// ReplaceRecoveryCodes deletes all recovery codes of the user and stores the new ones
+func (r *Repository) ReplaceRecoveryCodes(ctx context.Context, userID int, codeHashes []string) (err error) {
+	tx, err := r.Db.BeginTx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "failed to begin transaction")
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		} else {
+			err = tx.Commit()
+		}
+	}()
+
+	return r.replaceRecoveryCodes(ctx, tx, userID, codeHashes)
+}
+
+func (r *Repository) replaceRecoveryCodes(ctx context.Context, tx *sql.Tx, userID int, codeHashes []string) error {
+	if _, err := tx.ExecContext(ctx, "DELETE FROM recovery_codes WHERE user_id = $1", userID); err != nil {
+		return errors.Wrap(err, "failed to delete recovery codes")
+	}
+
+	stmt, err := tx.PrepareContext(ctx, "INSERT INTO recovery_codes (user_id, code_hash) VALUES ($1, $2)")
+	if err != nil {
+		return errors.Wrap(err, "failed to prepare statement")
+	}
+	defer stmt.Close()
+
+	for _, codeHash := range codeHashes {
+		if _, err := stmt.ExecContext(ctx, userID, codeHash); err != nil {
+			return errors.Wrap(err, "failed to insert recovery code")
+		}
+	}
+	return nil
+}
+
+// UseRecoveryCode marks an unused recovery code as used and reports whether it was found
+func (r *Repository) UseRecoveryCode(ctx context.Context, userID int, codeHash string) (bool, error) {
+	res, err := r.Db.ExecContext(ctx, `
+        UPDATE recovery_codes
+        SET used_at = NOW()
+        WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL`,
+		userID, codeHash)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to use recovery code")
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return false, errors.Wrap(err, "failed to get affected rows")
+	}
+	return affected > 0, nil
+}
+
+// CountRecoveryCodes returns the number of recovery codes the user has not used yet
+func (r *Repository) CountRecoveryCodes(ctx context.Context, userID int) (int, error) {
+	var count int
+	err := r.Db.QueryRowContext(ctx, "SELECT COUNT(*) FROM recovery_codes WHERE user_id = $1 AND used_at IS NULL", userID).Scan(&count)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to count recovery codes")
+	}
+	return count, nil
+}
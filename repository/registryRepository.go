@@ -0,0 +1,312 @@
+package repository
+
+import (
+	"feklistova/models"
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/pkg/errors"
+)
+
+const registeredModelColumns = "model_id, user_id, project_id, name, description, created_at"
+
+const modelVersionColumns = "version_id, model_id, version, shipment_id, model_file_id, stage, created_by, created_at"
+
+// CreateRegisteredModel registers a new named model in the registry and sets it's ID
+func (r *Repository) CreateRegisteredModel(ctx context.Context, model *models.RegisteredModel) error {
+	err := r.Db.QueryRowContext(ctx, `
+        INSERT INTO registered_models (user_id, project_id, name, description, created_at)
+        VALUES ($1, $2, $3, $4, $5)
+        RETURNING model_id`,
+		model.UserID, model.ProjectID, model.Name, model.Description, model.CreatedAt,
+	).Scan(&model.ModelID)
+	if err != nil {
+		return errors.Wrap(err, "failed to insert registered model")
+	}
+	return nil
+}
+
+func (r *Repository) GetRegisteredModelByID(ctx context.Context, modelID int) (*models.RegisteredModel, error) {
+	model, err := scanRegisteredModel(r.Db.QueryRowContext(ctx, `
+        SELECT `+registeredModelColumns+` FROM registered_models WHERE model_id = $1`, modelID))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("registered model with ID %d not found", modelID)
+		}
+		return nil, errors.Wrap(err, "failed to scan registered model")
+	}
+	return model, nil
+}
+
+func (r *Repository) GetRegisteredModelByName(ctx context.Context, userID int, name string) (*models.RegisteredModel, error) {
+	model, err := scanRegisteredModel(r.Db.QueryRowContext(ctx, `
+        SELECT `+registeredModelColumns+` FROM registered_models WHERE user_id = $1 AND name = $2`, userID, name))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("registered model %s not found", name)
+		}
+		return nil, errors.Wrap(err, "failed to scan registered model")
+	}
+	return model, nil
+}
+
+// GetRegisteredModelsByUserID returns all registered models of the user ordered by name
+func (r *Repository) GetRegisteredModelsByUserID(ctx context.Context, userID int) ([]models.RegisteredModel, error) {
+	rows, err := r.Db.QueryContext(ctx, `
+        SELECT `+registeredModelColumns+` FROM registered_models WHERE user_id = $1 ORDER BY name`, userID)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to query registered models")
+	}
+	defer rows.Close()
+
+	var registeredModels []models.RegisteredModel
+	for rows.Next() {
+		model, err := scanRegisteredModel(rows)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to scan registered model row")
+		}
+		registeredModels = append(registeredModels, *model)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "error occurred during iteration")
+	}
+	return registeredModels, nil
+}
+
+// CreateModelVersion adds the next version to the registered model and records its creation in the history
+func (r *Repository) CreateModelVersion(ctx context.Context, version *models.ModelVersion) (err error) {
+	tx, err := r.Db.BeginTx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "failed to begin transaction")
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		} else {
+			err = tx.Commit()
+		}
+	}()
+
+	// the model row is locked so that parallel registrations get different version numbers
+	_, err = tx.ExecContext(ctx, "SELECT model_id FROM registered_models WHERE model_id = $1 FOR UPDATE", version.ModelID)
+	if err != nil {
+		return errors.Wrap(err, "failed to lock registered model")
+	}
+
+	err = tx.QueryRowContext(ctx, `
+        INSERT INTO model_versions (model_id, version, shipment_id, model_file_id, stage, created_by, created_at)
+        SELECT $1, COALESCE(MAX(version), 0) + 1, $2, $3, $4, $5, $6
+        FROM model_versions WHERE model_id = $1
+        RETURNING version_id, version`,
+		version.ModelID, version.ShipmentID, version.ModelFileID, version.Stage, version.CreatedBy, version.CreatedAt,
+	).Scan(&version.VersionID, &version.Version)
+	if err != nil {
+		return errors.Wrap(err, "failed to insert model version")
+	}
+
+	err = insertStageTransition(ctx, tx, version.VersionID, "", version.Stage, version.CreatedBy)
+	return err
+}
+
+func (r *Repository) GetModelVersionByID(ctx context.Context, versionID int) (*models.ModelVersion, error) {
+	version, err := scanModelVersion(r.Db.QueryRowContext(ctx, `
+        SELECT `+modelVersionColumns+` FROM model_versions WHERE version_id = $1`, versionID))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("model version with ID %d not found", versionID)
+		}
+		return nil, errors.Wrap(err, "failed to scan model version")
+	}
+	return version, nil
+}
+
+// GetModelVersionsByModelID returns all versions of the registered model from the newest
+func (r *Repository) GetModelVersionsByModelID(ctx context.Context, modelID int) ([]models.ModelVersion, error) {
+	rows, err := r.Db.QueryContext(ctx, `
+        SELECT `+modelVersionColumns+` FROM model_versions WHERE model_id = $1 ORDER BY version DESC`, modelID)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to query model versions")
+	}
+	defer rows.Close()
+
+	var versions []models.ModelVersion
+	for rows.Next() {
+		version, err := scanModelVersion(rows)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to scan model version row")
+		}
+		versions = append(versions, *version)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "error occurred during iteration")
+	}
+	return versions, nil
+}
+
+// CountModelVersionsByShipmentID returns how many registered model versions were created from the shipment
+func (r *Repository) CountModelVersionsByShipmentID(ctx context.Context, shipmentID int) (int, error) {
+	var count int
+	err := r.Db.QueryRowContext(ctx, "SELECT COUNT(*) FROM model_versions WHERE shipment_id = $1", shipmentID).Scan(&count)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to count model versions")
+	}
+	return count, nil
+}
+
+// GetLatestModelVersionByStage returns the highest version of the model in the stage
+func (r *Repository) GetLatestModelVersionByStage(ctx context.Context, modelID int, stage string) (*models.ModelVersion, error) {
+	version, err := scanModelVersion(r.Db.QueryRowContext(ctx, `
+        SELECT `+modelVersionColumns+` FROM model_versions
+        WHERE model_id = $1 AND stage = $2
+        ORDER BY version DESC
+        LIMIT 1`, modelID, stage))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("model %d has no versions in stage %s", modelID, stage)
+		}
+		return nil, errors.Wrap(err, "failed to scan model version")
+	}
+	return version, nil
+}
+
+// TransitionModelVersion moves the version to another stage and records the transition.
+// With archiveStage set, the other versions of the model in the target stage are moved to archiveStage.
+func (r *Repository) TransitionModelVersion(ctx context.Context, version *models.ModelVersion, toStage, archiveStage string, userID int) (err error) {
+	tx, err := r.Db.BeginTx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "failed to begin transaction")
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		} else {
+			err = tx.Commit()
+		}
+	}()
+
+	var fromStage string
+	err = tx.QueryRowContext(ctx, "SELECT stage FROM model_versions WHERE version_id = $1 FOR UPDATE", version.VersionID).Scan(&fromStage)
+	if err != nil {
+		return errors.Wrap(err, "failed to lock model version")
+	}
+
+	if archiveStage != "" {
+		rows, err := tx.QueryContext(ctx, `
+            UPDATE model_versions SET stage = $1
+            WHERE model_id = $2 AND stage = $3 AND version_id <> $4
+            RETURNING version_id`, archiveStage, version.ModelID, toStage, version.VersionID)
+		if err != nil {
+			return errors.Wrap(err, "failed to archive model versions")
+		}
+		var archived []int
+		for rows.Next() {
+			var versionID int
+			if err := rows.Scan(&versionID); err != nil {
+				rows.Close()
+				return errors.Wrap(err, "failed to scan archived version")
+			}
+			archived = append(archived, versionID)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return errors.Wrap(err, "error occurred during iteration")
+		}
+		for _, versionID := range archived {
+			if err := insertStageTransition(ctx, tx, versionID, toStage, archiveStage, userID); err != nil {
+				return err
+			}
+		}
+	}
+
+	if _, err = tx.ExecContext(ctx, "UPDATE model_versions SET stage = $1 WHERE version_id = $2", toStage, version.VersionID); err != nil {
+		return errors.Wrap(err, "failed to update model version stage")
+	}
+	if err = insertStageTransition(ctx, tx, version.VersionID, fromStage, toStage, userID); err != nil {
+		return err
+	}
+	version.Stage = toStage
+	return nil
+}
+
+// GetModelStageTransitions returns the stage history of all versions of the model from the newest
+func (r *Repository) GetModelStageTransitions(ctx context.Context, modelID int) ([]models.ModelStageTransition, error) {
+	rows, err := r.Db.QueryContext(ctx, `
+        SELECT t.transition_id, t.version_id, v.version, t.from_stage, t.to_stage, t.user_id,
+               COALESCE(u.username, ''), t.created_at
+        FROM model_stage_transitions t
+        JOIN model_versions v ON v.version_id = t.version_id
+        LEFT JOIN users u ON u.user_id = t.user_id
+        WHERE v.model_id = $1
+        ORDER BY t.created_at DESC, t.transition_id DESC`, modelID)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to query stage transitions")
+	}
+	defer rows.Close()
+
+	var transitions []models.ModelStageTransition
+	for rows.Next() {
+		var transition models.ModelStageTransition
+		if err := rows.Scan(
+			&transition.TransitionID,
+			&transition.VersionID,
+			&transition.Version,
+			&transition.FromStage,
+			&transition.ToStage,
+			&transition.UserID,
+			&transition.UserName,
+			&transition.CreatedAt,
+		); err != nil {
+			return nil, errors.Wrap(err, "failed to scan stage transition row")
+		}
+		transitions = append(transitions, transition)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "error occurred during iteration")
+	}
+	return transitions, nil
+}
+
+func insertStageTransition(ctx context.Context, tx *sql.Tx, versionID int, fromStage, toStage string, userID int) error {
+	_, err := tx.ExecContext(ctx, `
+        INSERT INTO model_stage_transitions (version_id, from_stage, to_stage, user_id, created_at)
+        VALUES ($1, $2, $3, $4, NOW())`, versionID, fromStage, toStage, userID)
+	if err != nil {
+		return errors.Wrap(err, "failed to insert stage transition")
+	}
+	return nil
+}
+
+func scanRegisteredModel(row rowScanner) (*models.RegisteredModel, error) {
+	var model models.RegisteredModel
+	err := row.Scan(
+		&model.ModelID,
+		&model.UserID,
+		&model.ProjectID,
+		&model.Name,
+		&model.Description,
+		&model.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &model, nil
+}
+
+func scanModelVersion(row rowScanner) (*models.ModelVersion, error) {
+	var version models.ModelVersion
+	err := row.Scan(
+		&version.VersionID,
+		&version.ModelID,
+		&version.Version,
+		&version.ShipmentID,
+		&version.ModelFileID,
+		&version.Stage,
+		&version.CreatedBy,
+		&version.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &version, nil
+}
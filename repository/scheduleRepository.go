@@ -0,0 +1,250 @@
+package repository
+
+import (
+	"feklistova/models"
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const scheduleColumns = `schedule_id, user_id, project_id, COALESCE(template_shipment_id, 0), cron_expr,
+        dataset_path, dataset_name, dataset_size, enabled, next_run_at, created_at`
+
+// CreateSchedule registers a new schedule in the database and sets it's ID
+func (r *Repository) CreateSchedule(ctx context.Context, schedule *models.Schedule) error {
+	err := r.Db.QueryRowContext(ctx, `
+        INSERT INTO schedules (user_id, project_id, template_shipment_id, cron_expr, dataset_path, dataset_name, dataset_size,
+                               enabled, next_run_at, created_at)
+        VALUES ($1, $2, NULLIF($3, 0), $4, $5, $6, $7, $8, $9, $10)
+        RETURNING schedule_id`,
+		schedule.UserID,
+		schedule.ProjectID,
+		schedule.TemplateShipmentID,
+		schedule.CronExpr,
+		schedule.DatasetPath,
+		schedule.DatasetName,
+		schedule.DatasetSize,
+		schedule.Enabled,
+		schedule.NextRunAt,
+		schedule.CreatedAt,
+	).Scan(&schedule.ScheduleID)
+	if err != nil {
+		return errors.Wrap(err, "failed to insert schedule")
+	}
+	return nil
+}
+
+func (r *Repository) GetScheduleByID(ctx context.Context, scheduleID int) (*models.Schedule, error) {
+	schedule, err := scanSchedule(r.Db.QueryRowContext(ctx, `
+        SELECT `+scheduleColumns+` FROM schedules WHERE schedule_id = $1`, scheduleID))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("schedule with ID %d not found", scheduleID)
+		}
+		return nil, errors.Wrap(err, "failed to scan schedule")
+	}
+	return schedule, nil
+}
+
+// GetSchedulesByProjectID returns all schedules of the project ordered by creation
+func (r *Repository) GetSchedulesByProjectID(ctx context.Context, projectID int) ([]models.Schedule, error) {
+	return r.querySchedules(ctx, `
+        SELECT `+scheduleColumns+` FROM schedules WHERE project_id = $1 ORDER BY created_at`, projectID)
+}
+
+// GetSchedulesByUserID returns all schedules of the user
+func (r *Repository) GetSchedulesByUserID(ctx context.Context, userID int) ([]models.Schedule, error) {
+	return r.querySchedules(ctx, `
+        SELECT `+scheduleColumns+` FROM schedules WHERE user_id = $1 ORDER BY created_at`, userID)
+}
+
+// UpdateScheduleDataset replaces the dataset new runs of the schedule are trained on
+func (r *Repository) UpdateScheduleDataset(ctx context.Context, schedule *models.Schedule) error {
+	_, err := r.Db.ExecContext(ctx, `
+        UPDATE schedules SET dataset_path = $1, dataset_name = $2, dataset_size = $3
+        WHERE schedule_id = $4`,
+		schedule.DatasetPath, schedule.DatasetName, schedule.DatasetSize, schedule.ScheduleID)
+	if err != nil {
+		return errors.Wrap(err, "failed to update schedule dataset")
+	}
+	return nil
+}
+
+// UpdateScheduleState enables or pauses the schedule and saves its next run time
+func (r *Repository) UpdateScheduleState(ctx context.Context, schedule *models.Schedule) error {
+	_, err := r.Db.ExecContext(ctx, `
+        UPDATE schedules SET enabled = $1, next_run_at = $2
+        WHERE schedule_id = $3`,
+		schedule.Enabled, schedule.NextRunAt, schedule.ScheduleID)
+	if err != nil {
+		return errors.Wrap(err, "failed to update schedule")
+	}
+	return nil
+}
+
+// DeleteSchedule deletes the schedule together with its run history
+func (r *Repository) DeleteSchedule(ctx context.Context, scheduleID int) error {
+	_, err := r.Db.ExecContext(ctx, "DELETE FROM schedules WHERE schedule_id = $1", scheduleID)
+	if err != nil {
+		return errors.Wrap(err, "failed to delete schedule")
+	}
+	return nil
+}
+
+// ClaimDueSchedules returns the enabled schedules whose run time has come and moves them to
+// their next run time. Rows are locked with SKIP LOCKED, so with several server instances
+// every due run is claimed by exactly one of them.
+func (r *Repository) ClaimDueSchedules(ctx context.Context, now time.Time,
+	nextRun func(schedule *models.Schedule) time.Time) (schedules []models.Schedule, err error) {
+	tx, err := r.Db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to begin transaction")
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		} else {
+			err = tx.Commit()
+		}
+	}()
+
+	rows, err := tx.QueryContext(ctx, `
+        SELECT `+scheduleColumns+` FROM schedules
+        WHERE enabled AND next_run_at <= $1
+        ORDER BY next_run_at
+        FOR UPDATE SKIP LOCKED`, now)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to query due schedules")
+	}
+	for rows.Next() {
+		schedule, err := scanSchedule(rows)
+		if err != nil {
+			rows.Close()
+			return nil, errors.Wrap(err, "failed to scan schedule row")
+		}
+		schedules = append(schedules, *schedule)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "error occurred during iteration")
+	}
+
+	for i := range schedules {
+		schedules[i].NextRunAt = nextRun(&schedules[i])
+		_, err = tx.ExecContext(ctx, "UPDATE schedules SET next_run_at = $1 WHERE schedule_id = $2",
+			schedules[i].NextRunAt, schedules[i].ScheduleID)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to move schedule to the next run")
+		}
+	}
+	return schedules, nil
+}
+
+// CreateScheduleRun adds a record to the run history of the schedule
+func (r *Repository) CreateScheduleRun(ctx context.Context, run *models.ScheduleRun) error {
+	err := r.Db.QueryRowContext(ctx, `
+        INSERT INTO schedule_runs (schedule_id, shipment_id, status, error, started_at)
+        VALUES ($1, NULLIF($2, 0), $3, $4, $5)
+        RETURNING run_id`,
+		run.ScheduleID, run.ShipmentID, run.Status, run.Error, run.StartedAt,
+	).Scan(&run.RunID)
+	if err != nil {
+		return errors.Wrap(err, "failed to insert schedule run")
+	}
+	return nil
+}
+
+// UpdateScheduleRun saves the shipment, the status and the error of a scheduled run
+func (r *Repository) UpdateScheduleRun(ctx context.Context, run *models.ScheduleRun) error {
+	_, err := r.Db.ExecContext(ctx, `
+        UPDATE schedule_runs SET shipment_id = NULLIF($1, 0), status = $2, error = $3
+        WHERE run_id = $4`,
+		run.ShipmentID, run.Status, run.Error, run.RunID)
+	if err != nil {
+		return errors.Wrap(err, "failed to update schedule run")
+	}
+	return nil
+}
+
+// GetScheduleRunsByProjectID returns the latest scheduled runs of the project from the newest
+func (r *Repository) GetScheduleRunsByProjectID(ctx context.Context, projectID, limit int) ([]models.ScheduleRun, error) {
+	rows, err := r.Db.QueryContext(ctx, `
+        SELECT sr.run_id, sr.schedule_id, COALESCE(sr.shipment_id, 0), COALESCE(s.status, ''),
+               sr.status, sr.error, sr.started_at
+        FROM schedule_runs sr
+        JOIN schedules sc ON sc.schedule_id = sr.schedule_id
+        LEFT JOIN shipments s ON s.shipment_id = sr.shipment_id
+        WHERE sc.project_id = $1
+        ORDER BY sr.started_at DESC, sr.run_id DESC
+        LIMIT $2`, projectID, limit)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to query schedule runs")
+	}
+	defer rows.Close()
+
+	var runs []models.ScheduleRun
+	for rows.Next() {
+		var run models.ScheduleRun
+		if err := rows.Scan(
+			&run.RunID,
+			&run.ScheduleID,
+			&run.ShipmentID,
+			&run.ShipmentStatus,
+			&run.Status,
+			&run.Error,
+			&run.StartedAt,
+		); err != nil {
+			return nil, errors.Wrap(err, "failed to scan schedule run row")
+		}
+		runs = append(runs, run)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "error occurred during iteration")
+	}
+	return runs, nil
+}
+
+func (r *Repository) querySchedules(ctx context.Context, query string, args ...interface{}) ([]models.Schedule, error) {
+	rows, err := r.Db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to query schedules")
+	}
+	defer rows.Close()
+
+	var schedules []models.Schedule
+	for rows.Next() {
+		schedule, err := scanSchedule(rows)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to scan schedule row")
+		}
+		schedules = append(schedules, *schedule)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "error occurred during iteration")
+	}
+	return schedules, nil
+}
+
+func scanSchedule(row rowScanner) (*models.Schedule, error) {
+	var schedule models.Schedule
+	err := row.Scan(
+		&schedule.ScheduleID,
+		&schedule.UserID,
+		&schedule.ProjectID,
+		&schedule.TemplateShipmentID,
+		&schedule.CronExpr,
+		&schedule.DatasetPath,
+		&schedule.DatasetName,
+		&schedule.DatasetSize,
+		&schedule.Enabled,
+		&schedule.NextRunAt,
+		&schedule.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &schedule, nil
+}
@@ -4,25 +4,55 @@ import (
 	"feklistova/models"
 	"context"
 	"database/sql"
+	"encoding/json"
 	"fmt"
+	"time"
 
+	"github.com/lib/pq"
 	"github.com/pkg/errors"
 )
 
+const shipmentColumns = `shipment_id, user_id, COALESCE(project_id, 0), COALESCE(parent_shipment_id, 0), projectName, modelType, algorithm, targetColumn,
+        hyperparameters, forecast, validation, column_spec, note, status, timestamp, finished_at, deleted_at`
+
 // CreateShipment registers a new shipment in the database and sets it's ID
 func (r *Repository) CreateShipment(ctx context.Context, shipment *models.Shipment) error {
+	hyperparameters, err := encodeHyperparameters(shipment.Hyperparameters)
+	if err != nil {
+		return err
+	}
+	forecast, err := encodeForecastSettings(shipment.Forecast)
+	if err != nil {
+		return err
+	}
+	validation, err := encodeValidationSettings(shipment.Validation)
+	if err != nil {
+		return err
+	}
+	columnSpec, err := encodeColumnSpec(shipment.Columns)
+	if err != nil {
+		return err
+	}
+
 	query := `
-		INSERT INTO shipments (user_id, projectName, modelType, algorithm, targetColumn, status, timestamp)
-		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		INSERT INTO shipments (user_id, project_id, parent_shipment_id, projectName, modelType, algorithm, targetColumn,
+		                       hyperparameters, forecast, validation, column_spec, status, timestamp)
+		VALUES ($1, NULLIF($2, 0), NULLIF($3, 0), $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
 		RETURNING shipment_id
 	`
 
-	err := r.Db.QueryRowContext(ctx, query,
+	err = r.Db.QueryRowContext(ctx, query,
 		shipment.UserID,
+		shipment.ProjectID,
+		shipment.ParentID,
 		shipment.ProjectName,
 		shipment.ModelType,
 		shipment.Algorithm,
 		shipment.TargetColumn,
+		hyperparameters,
+		forecast,
+		validation,
+		columnSpec,
 		shipment.Status,
 		shipment.Timestamp,
 	).Scan(
@@ -36,42 +66,401 @@ func (r *Repository) CreateShipment(ctx context.Context, shipment *models.Shipme
 }
 
 func (r *Repository) GetShipmentByID(ctx context.Context, shipmentID int) (*models.Shipment, error) {
-	var shipment models.Shipment
-
 	query := `
-        SELECT user_id, projectName, modelType, algorithm, targetColumn, status, timestamp
+        SELECT ` + shipmentColumns + `
         FROM shipments
-        WHERE shipment_id = $1
+        WHERE shipment_id = $1 AND deleted_at IS NULL
     `
 
-	err := r.Db.QueryRowContext(ctx, query, shipmentID).Scan(
+	shipment, err := scanShipment(r.Db.QueryRowContext(ctx, query, shipmentID))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("shipment with ID %d not found", shipmentID)
+		}
+		return nil, errors.Wrap(err, "failed to scan shipment")
+	}
+
+	return shipment, nil
+}
+
+// GetShipmentsByUserID returns all shipments of the user including the trashed ones ordered from the newest
+func (r *Repository) GetShipmentsByUserID(ctx context.Context, userID int) ([]models.Shipment, error) {
+	return r.queryShipments(ctx, `
+        SELECT `+shipmentColumns+`
+        FROM shipments
+        WHERE user_id = $1
+        ORDER BY timestamp DESC
+    `, userID)
+}
+
+// GetShipmentsByProjectID returns all runs of the project ordered from the newest
+func (r *Repository) GetShipmentsByProjectID(ctx context.Context, projectID int) ([]models.Shipment, error) {
+	return r.queryShipments(ctx, `
+        SELECT `+shipmentColumns+`
+        FROM shipments
+        WHERE project_id = $1 AND deleted_at IS NULL
+        ORDER BY timestamp DESC
+    `, projectID)
+}
+
+// CountShipmentsByUserID returns the number of shipments of the user outside the trash
+func (r *Repository) CountShipmentsByUserID(ctx context.Context, userID int) (int, error) {
+	var count int
+	err := r.Db.QueryRowContext(ctx, "SELECT COUNT(*) FROM shipments WHERE user_id = $1 AND deleted_at IS NULL", userID).Scan(&count)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to count shipments")
+	}
+	return count, nil
+}
+
+// MoveShipmentToProject attaches the shipment to another project of the same user
+func (r *Repository) MoveShipmentToProject(ctx context.Context, shipmentID int, project *models.Project) error {
+	_, err := r.Db.ExecContext(ctx, `
+        UPDATE shipments SET project_id = $1, projectName = $2
+        WHERE shipment_id = $3`, project.ProjectID, project.Name, shipmentID)
+	if err != nil {
+		return errors.Wrap(err, "failed to move shipment")
+	}
+	return nil
+}
+
+// metricOperators are the comparisons allowed in metric thresholds of the shipment search
+var metricOperators = map[string]bool{">=": true, "<=": true, ">": true, "<": true}
+
+// SearchShipments returns the user's shipments outside the trash matching the filter ordered from the newest.
+// Every condition is backed by an index on shipments, shipment_tags or model_metrics.
+func (r *Repository) SearchShipments(ctx context.Context, filter models.ShipmentFilter) ([]models.Shipment, error) {
+	var conditions []string
+	args := []interface{}{filter.UserID}
+	addCondition := func(condition string, values ...interface{}) {
+		placeholders := make([]interface{}, len(values))
+		for i, value := range values {
+			args = append(args, value)
+			placeholders[i] = len(args)
+		}
+		conditions = append(conditions, fmt.Sprintf(condition, placeholders...))
+	}
+
+	if filter.ProjectName != "" {
+		addCondition("LOWER(projectName) = LOWER($%d)", filter.ProjectName)
+	}
+	if filter.Algorithm != "" {
+		addCondition("algorithm = $%d", filter.Algorithm)
+	}
+	if filter.ModelType != "" {
+		addCondition("modelType = $%d", filter.ModelType)
+	}
+	if filter.Status != "" {
+		addCondition("status = $%d", filter.Status)
+	}
+	for _, tag := range filter.Tags {
+		addCondition(`EXISTS (
+            SELECT 1 FROM shipment_tags t WHERE t.shipment_id = shipments.shipment_id AND t.tag = $%d)`, tag)
+	}
+	if !filter.From.IsZero() {
+		addCondition("timestamp >= $%d", filter.From)
+	}
+	if !filter.To.IsZero() {
+		addCondition("timestamp < $%d", filter.To)
+	}
+	for _, metric := range filter.Metrics {
+		if !metricOperators[metric.Operator] {
+			return nil, fmt.Errorf("unknown metric operator %s", metric.Operator)
+		}
+		addCondition(`EXISTS (
+            SELECT 1 FROM model_files f JOIN model_metrics m ON m.file_id = f.file_id
+            WHERE f.shipment_id = shipments.shipment_id AND m.metric_name = $%d AND m.metric_value `+metric.Operator+` $%d)`,
+			metric.Name, metric.Value)
+	}
+
+	query := `
+        SELECT ` + shipmentColumns + `
+        FROM shipments
+        WHERE user_id = $1 AND deleted_at IS NULL`
+	for _, condition := range conditions {
+		query += " AND " + condition
+	}
+	query += " ORDER BY timestamp DESC, shipment_id DESC"
+	if filter.Limit > 0 {
+		args = append(args, filter.Limit)
+		query += fmt.Sprintf(" LIMIT $%d", len(args))
+	}
+	if filter.Offset > 0 {
+		args = append(args, filter.Offset)
+		query += fmt.Sprintf(" OFFSET $%d", len(args))
+	}
+
+	return r.queryShipments(ctx, query, args...)
+}
+
+// UpdateShipmentNote saves the markdown note of the shipment
+func (r *Repository) UpdateShipmentNote(ctx context.Context, shipmentID int, note string) error {
+	_, err := r.Db.ExecContext(ctx, "UPDATE shipments SET note = $1 WHERE shipment_id = $2", note, shipmentID)
+	if err != nil {
+		return errors.Wrap(err, "failed to update shipment note")
+	}
+	return nil
+}
+
+// SetShipmentTags replaces the tags of the shipment
+func (r *Repository) SetShipmentTags(ctx context.Context, shipmentID int, tags []string) (err error) {
+	tx, err := r.Db.BeginTx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "failed to begin transaction")
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		} else {
+			err = tx.Commit()
+		}
+	}()
+
+	if _, err = tx.ExecContext(ctx, "DELETE FROM shipment_tags WHERE shipment_id = $1", shipmentID); err != nil {
+		return errors.Wrap(err, "failed to delete shipment tags")
+	}
+	for _, tag := range tags {
+		_, err = tx.ExecContext(ctx, `
+            INSERT INTO shipment_tags (shipment_id, tag) VALUES ($1, $2)
+            ON CONFLICT DO NOTHING`, shipmentID, tag)
+		if err != nil {
+			return errors.Wrap(err, "failed to insert shipment tag")
+		}
+	}
+	return nil
+}
+
+// GetShipmentTags returns the sorted tags of the shipments by shipment ID
+func (r *Repository) GetShipmentTags(ctx context.Context, shipmentIDs []int) (map[int][]string, error) {
+	tags := make(map[int][]string)
+	if len(shipmentIDs) == 0 {
+		return tags, nil
+	}
+
+	rows, err := r.Db.QueryContext(ctx, `
+        SELECT shipment_id, tag FROM shipment_tags
+        WHERE shipment_id = ANY($1)
+        ORDER BY tag`, pq.Array(shipmentIDs))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to query shipment tags")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var shipmentID int
+		var tag string
+		if err := rows.Scan(&shipmentID, &tag); err != nil {
+			return nil, errors.Wrap(err, "failed to scan shipment tag row")
+		}
+		tags[shipmentID] = append(tags[shipmentID], tag)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "error occurred during iteration")
+	}
+	return tags, nil
+}
+
+// GetTagsByUserID returns all tags the user has put on shipments
+func (r *Repository) GetTagsByUserID(ctx context.Context, userID int) ([]string, error) {
+	rows, err := r.Db.QueryContext(ctx, `
+        SELECT DISTINCT t.tag FROM shipment_tags t
+        JOIN shipments s ON s.shipment_id = t.shipment_id
+        WHERE s.user_id = $1 AND s.deleted_at IS NULL
+        ORDER BY t.tag`, userID)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to query tags")
+	}
+	defer rows.Close()
+
+	var tags []string
+	for rows.Next() {
+		var tag string
+		if err := rows.Scan(&tag); err != nil {
+			return nil, errors.Wrap(err, "failed to scan tag row")
+		}
+		tags = append(tags, tag)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "error occurred during iteration")
+	}
+	return tags, nil
+}
+
+// GetMetricsByShipmentIDs returns metrics of the shipments by shipment ID
+func (r *Repository) GetMetricsByShipmentIDs(ctx context.Context, shipmentIDs []int) (map[int]map[string]float64, error) {
+	metrics := make(map[int]map[string]float64)
+	if len(shipmentIDs) == 0 {
+		return metrics, nil
+	}
+
+	rows, err := r.Db.QueryContext(ctx, `
+        SELECT f.shipment_id, m.metric_name, m.metric_value
+        FROM model_metrics m
+        JOIN model_files f ON f.file_id = m.file_id
+        WHERE f.shipment_id = ANY($1)`, pq.Array(shipmentIDs))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to query shipment metrics")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var shipmentID int
+		var metricName string
+		var metricValue float64
+		if err := rows.Scan(&shipmentID, &metricName, &metricValue); err != nil {
+			return nil, errors.Wrap(err, "failed to scan metric row")
+		}
+		if metrics[shipmentID] == nil {
+			metrics[shipmentID] = make(map[string]float64)
+		}
+		metrics[shipmentID][metricName] = metricValue
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "error occurred during iteration")
+	}
+	return metrics, nil
+}
+
+func (r *Repository) queryShipments(ctx context.Context, query string, args ...interface{}) ([]models.Shipment, error) {
+	var shipments []models.Shipment
+
+	rows, err := r.Db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to query shipments")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		shipment, err := scanShipment(rows)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to scan shipment row")
+		}
+		shipments = append(shipments, *shipment)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "error occurred during iteration")
+	}
+
+	return shipments, nil
+}
+
+func scanShipment(row rowScanner) (*models.Shipment, error) {
+	var shipment models.Shipment
+	var hyperparameters string
+	var forecast, validation sql.NullString
+	var columnSpec string
+	var finishedAt, deletedAt sql.NullTime
+	err := row.Scan(
+		&shipment.ShipmentID,
 		&shipment.UserID,
+		&shipment.ProjectID,
+		&shipment.ParentID,
 		&shipment.ProjectName,
 		&shipment.ModelType,
 		&shipment.Algorithm,
 		&shipment.TargetColumn,
+		&hyperparameters,
+		&forecast,
+		&validation,
+		&columnSpec,
+		&shipment.Note,
 		&shipment.Status,
 		&shipment.Timestamp,
+		&finishedAt,
+		&deletedAt,
 	)
 	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			return nil, fmt.Errorf("shipment with ID %d not found", shipmentID)
-		}
-		return nil, errors.Wrap(err, "failed to scan shipment")
+		return nil, err
 	}
 
-	shipment.ShipmentID = shipmentID
+	if err := json.Unmarshal([]byte(hyperparameters), &shipment.Hyperparameters); err != nil {
+		return nil, errors.Wrap(err, "failed to decode hyperparameters")
+	}
+	if forecast.Valid {
+		shipment.Forecast = &models.ForecastSettings{}
+		if err := json.Unmarshal([]byte(forecast.String), shipment.Forecast); err != nil {
+			return nil, errors.Wrap(err, "failed to decode forecast settings")
+		}
+	}
+	if validation.Valid {
+		shipment.Validation = &models.ValidationSettings{}
+		if err := json.Unmarshal([]byte(validation.String), shipment.Validation); err != nil {
+			return nil, errors.Wrap(err, "failed to decode validation settings")
+		}
+	}
+	if err := json.Unmarshal([]byte(columnSpec), &shipment.Columns); err != nil {
+		return nil, errors.Wrap(err, "failed to decode column spec")
+	}
+	if finishedAt.Valid {
+		shipment.FinishedAt = &finishedAt.Time
+	}
+	if deletedAt.Valid {
+		shipment.DeletedAt = &deletedAt.Time
+	}
 	return &shipment, nil
 }
 
+func encodeHyperparameters(hyperparameters map[string]float64) (string, error) {
+	if hyperparameters == nil {
+		return "{}", nil
+	}
+	data, err := json.Marshal(hyperparameters)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to encode hyperparameters")
+	}
+	return string(data), nil
+}
+
+// encodeForecastSettings returns NULL for shipments that are not forecasts
+func encodeForecastSettings(settings *models.ForecastSettings) (sql.NullString, error) {
+	if settings == nil {
+		return sql.NullString{}, nil
+	}
+	data, err := json.Marshal(settings)
+	if err != nil {
+		return sql.NullString{}, errors.Wrap(err, "failed to encode forecast settings")
+	}
+	return sql.NullString{String: string(data), Valid: true}, nil
+}
+
+// encodeValidationSettings returns NULL for model types without a validation strategy
+func encodeValidationSettings(settings *models.ValidationSettings) (sql.NullString, error) {
+	if settings == nil {
+		return sql.NullString{}, nil
+	}
+	data, err := json.Marshal(settings)
+	if err != nil {
+		return sql.NullString{}, errors.Wrap(err, "failed to encode validation settings")
+	}
+	return sql.NullString{String: string(data), Valid: true}, nil
+}
+
+func encodeColumnSpec(columns map[string]string) (string, error) {
+	if columns == nil {
+		return "{}", nil
+	}
+	data, err := json.Marshal(columns)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to encode column spec")
+	}
+	return string(data), nil
+}
+
+// UpdateShipmentStatus saves the shipment status, for final statuses the finish time is saved as well
 func (r *Repository) UpdateShipmentStatus(ctx context.Context, shipment *models.Shipment) error {
+	var finishedAt sql.NullTime
+	switch shipment.Status {
+	case "finished", "failed", "denied":
+		finishedAt = sql.NullTime{Time: time.Now(), Valid: true}
+	}
+
 	query := `
         UPDATE shipments
-        SET status = $1
-        WHERE shipment_id = $2
+        SET status = $1, finished_at = COALESCE($2, finished_at)
+        WHERE shipment_id = $3
     `
 
-	_, err := r.Db.ExecContext(ctx, query, shipment.Status, shipment.ShipmentID)
+	_, err := r.Db.ExecContext(ctx, query, shipment.Status, finishedAt, shipment.ShipmentID)
 	if err != nil {
 		return errors.Wrap(err, "failed to update shipment status")
 	}
@@ -79,11 +468,114 @@ func (r *Repository) UpdateShipmentStatus(ctx context.Context, shipment *models.
 	return nil
 }
 
-// DeleteShipment deletes a shipment from the database by its shipment ID
-func (r *Repository) DeleteShipment(ctx context.Context, shipmentID int) error {
-	_, err := r.Db.ExecContext(ctx, "DELETE FROM shipments WHERE shipment_id = $1", shipmentID)
+// FailStaleShipments marks the shipments still accepted or in progress since before the moment as failed
+// and returns their IDs. The status is changed in one statement, so with several server instances
+// every stale run is returned to one of them only.
+func (r *Repository) FailStaleShipments(ctx context.Context, startedBefore time.Time) ([]int, error) {
+	rows, err := r.Db.QueryContext(ctx, `
+        UPDATE shipments SET status = 'failed'
+        WHERE status IN ('accepted', 'in progress') AND timestamp < $1
+        RETURNING shipment_id`, startedBefore)
 	if err != nil {
-		return err
+		return nil, errors.Wrap(err, "failed to fail stale shipments")
+	}
+	defer rows.Close()
+
+	var shipmentIDs []int
+	for rows.Next() {
+		var shipmentID int
+		if err := rows.Scan(&shipmentID); err != nil {
+			return nil, errors.Wrap(err, "failed to scan stale shipment")
+		}
+		shipmentIDs = append(shipmentIDs, shipmentID)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "error occurred during iteration")
+	}
+	return shipmentIDs, nil
+}
+
+// TrashShipment moves the shipment to the trash, it is hidden everywhere until restored or purged
+func (r *Repository) TrashShipment(ctx context.Context, shipmentID int) error {
+	_, err := r.Db.ExecContext(ctx, `
+        UPDATE shipments SET deleted_at = $1
+        WHERE shipment_id = $2 AND deleted_at IS NULL`, time.Now(), shipmentID)
+	if err != nil {
+		return errors.Wrap(err, "failed to move shipment to the trash")
+	}
+	return nil
+}
+
+// RestoreShipment returns the shipment from the trash
+func (r *Repository) RestoreShipment(ctx context.Context, shipmentID int) error {
+	_, err := r.Db.ExecContext(ctx, "UPDATE shipments SET deleted_at = NULL WHERE shipment_id = $1", shipmentID)
+	if err != nil {
+		return errors.Wrap(err, "failed to restore shipment")
+	}
+	return nil
+}
+
+func (r *Repository) GetTrashedShipmentByID(ctx context.Context, shipmentID int) (*models.Shipment, error) {
+	shipment, err := scanShipment(r.Db.QueryRowContext(ctx, `
+        SELECT `+shipmentColumns+`
+        FROM shipments
+        WHERE shipment_id = $1 AND deleted_at IS NOT NULL`, shipmentID))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("trashed shipment with ID %d not found", shipmentID)
+		}
+		return nil, errors.Wrap(err, "failed to scan shipment")
+	}
+	return shipment, nil
+}
+
+// GetTrashedShipmentsByUserID returns the shipments in the user's trash from the last deleted
+func (r *Repository) GetTrashedShipmentsByUserID(ctx context.Context, userID int) ([]models.Shipment, error) {
+	return r.queryShipments(ctx, `
+        SELECT `+shipmentColumns+`
+        FROM shipments
+        WHERE user_id = $1 AND deleted_at IS NOT NULL
+        ORDER BY deleted_at DESC
+    `, userID)
+}
+
+// GetExpiredTrashedShipments returns the shipments moved to the trash before the moment,
+// except the skipped ones
+func (r *Repository) GetExpiredTrashedShipments(ctx context.Context, before time.Time, limit int, skipped []int) ([]models.Shipment, error) {
+	return r.queryShipments(ctx, `
+        SELECT `+shipmentColumns+`
+        FROM shipments
+        WHERE deleted_at < $1 AND NOT shipment_id = ANY($3)
+        ORDER BY deleted_at
+        LIMIT $2
+    `, before, limit, pq.Array(skipped))
+}
+
+// DeleteShipment deletes a shipment from the database by its shipment ID together with
+// its files and metrics. Physical files are not touched, the caller has to remove them.
+func (r *Repository) DeleteShipment(ctx context.Context, shipmentID int) (err error) {
+	tx, err := r.Db.BeginTx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "failed to begin transaction")
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		} else {
+			err = tx.Commit()
+		}
+	}()
+
+	queries := []string{
+		"DELETE FROM model_metrics WHERE file_id IN (SELECT file_id FROM model_files WHERE shipment_id = $1)",
+		"DELETE FROM model_files WHERE shipment_id = $1",
+		"DELETE FROM downloaded_files WHERE shipment_id = $1",
+		"DELETE FROM shipments WHERE shipment_id = $1",
+	}
+	for _, query := range queries {
+		if _, err = tx.ExecContext(ctx, query, shipmentID); err != nil {
+			return errors.Wrap(err, "failed to delete shipment")
+		}
 	}
 	return nil
 }
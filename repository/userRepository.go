@@ -3,14 +3,17 @@ package repository
 import (
 	"feklistova/models"
 	"context"
+	"database/sql"
+
+	"github.com/pkg/errors"
 )
 
 // GetUserByID retrieves a user from the database by ID.
 func (r *Repository) GetUserByID(ctx context.Context, id int) (*models.User, error) {
 	user := &models.User{}
-	query := "SELECT user_id, username FROM users WHERE user_id = $1"
+	query := "SELECT user_id, username, email, password, totp_enabled, is_admin, plan, created_at FROM users WHERE user_id = $1"
 	row := r.Db.QueryRowContext(ctx, query, id)
-	err := row.Scan(&user.ID, &user.Username)
+	err := row.Scan(&user.ID, &user.Username, &user.Email, &user.Password, &user.TOTPEnabled, &user.IsAdmin, &user.Plan, &user.CreatedAt)
 	if err != nil {
 		return nil, err
 	}
@@ -19,13 +22,15 @@ func (r *Repository) GetUserByID(ctx context.Context, id int) (*models.User, err
 
 // GetUserByID retrieves a user from the database by ID.
 func (r *Repository) GetUserByEmail(ctx context.Context, email string) (*models.User, error) {
-	query := "SELECT user_id, username, email, password, created_at FROM users WHERE email = $1"
+	query := "SELECT user_id, username, email, password, totp_secret, totp_enabled, created_at FROM users WHERE email = $1"
 
 	user := &models.User{}
-	err := r.Db.QueryRowContext(ctx, query, email).Scan(&user.ID, &user.Username, &user.Email, &user.Password, &user.CreatedAt)
+	var totpSecret sql.NullString
+	err := r.Db.QueryRowContext(ctx, query, email).Scan(&user.ID, &user.Username, &user.Email, &user.Password, &totpSecret, &user.TOTPEnabled, &user.CreatedAt)
 	if err != nil {
 		return nil, err
 	}
+	user.TOTPSecret = totpSecret.String
 
 	return user, nil
 }
@@ -33,14 +38,148 @@ func (r *Repository) GetUserByEmail(ctx context.Context, email string) (*models.
 // RegisterUser creates a new user in the database.
 func (r *Repository) RegisterUser(ctx context.Context, user models.User) (int, error) {
 	query := `
-        INSERT INTO users (username, email, password, created_at)
-        VALUES ($1, $2, $3, $4)
+        INSERT INTO users (username, email, password, plan, created_at)
+        VALUES ($1, $2, $3, $4, $5)
         RETURNING user_id
     `
 	var userID int
-	err := r.Db.QueryRowContext(ctx, query, user.Username, user.Email, user.Password, user.CreatedAt).Scan(&userID)
+	err := r.Db.QueryRowContext(ctx, query, user.Username, user.Email, user.Password, user.Plan, user.CreatedAt).Scan(&userID)
 	if err != nil {
 		return 0, err
 	}
 	return userID, nil
 }
+
+// GetUserTOTP retrieves a user with the two-factor authentication settings by ID.
+func (r *Repository) GetUserTOTP(ctx context.Context, id int) (*models.User, error) {
+	query := "SELECT user_id, username, email, totp_secret, totp_enabled FROM users WHERE user_id = $1"
+
+	user := &models.User{}
+	var totpSecret sql.NullString
+	err := r.Db.QueryRowContext(ctx, query, id).Scan(&user.ID, &user.Username, &user.Email, &totpSecret, &user.TOTPEnabled)
+	if err != nil {
+		return nil, err
+	}
+	user.TOTPSecret = totpSecret.String
+
+	return user, nil
+}
+
+// SetTOTPSecret stores a new not yet confirmed TOTP secret for the user.
+func (r *Repository) SetTOTPSecret(ctx context.Context, userID int, secret string) error {
+	_, err := r.Db.ExecContext(ctx, "UPDATE users SET totp_secret = $1, totp_enabled = FALSE WHERE user_id = $2", secret, userID)
+	return err
+}
+
+// UseTOTPStep records the time step of an accepted TOTP code. It returns false when a code of this
+// or a later step has already been accepted, so every code can be used only once.
+func (r *Repository) UseTOTPStep(ctx context.Context, userID int, step int64) (bool, error) {
+	res, err := r.Db.ExecContext(ctx, `
+        UPDATE users
+        SET totp_last_step = $2
+        WHERE user_id = $1 AND (totp_last_step IS NULL OR totp_last_step < $2)`,
+		userID, step)
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected > 0, nil
+}
+
+// EnableTOTP turns two-factor authentication on and replaces the user's recovery codes.
+func (r *Repository) EnableTOTP(ctx context.Context, userID int, recoveryCodeHashes []string) (err error) {
+	tx, err := r.Db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		} else {
+			err = tx.Commit()
+		}
+	}()
+
+	if _, err = tx.ExecContext(ctx, "UPDATE users SET totp_enabled = TRUE WHERE user_id = $1", userID); err != nil {
+		return err
+	}
+	return r.replaceRecoveryCodes(ctx, tx, userID, recoveryCodeHashes)
+}
+
+// DisableTOTP turns two-factor authentication off and forgets the secret and recovery codes.
+func (r *Repository) DisableTOTP(ctx context.Context, userID int) (err error) {
+	tx, err := r.Db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		} else {
+			err = tx.Commit()
+		}
+	}()
+
+	if _, err = tx.ExecContext(ctx, "UPDATE users SET totp_secret = NULL, totp_enabled = FALSE WHERE user_id = $1", userID); err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, "DELETE FROM recovery_codes WHERE user_id = $1", userID)
+	return err
+}
+
+// UpdateUserProfile changes the user's name and email.
+func (r *Repository) UpdateUserProfile(ctx context.Context, userID int, username, email string) error {
+	_, err := r.Db.ExecContext(ctx, "UPDATE users SET username = $1, email = $2 WHERE user_id = $3", username, email, userID)
+	return err
+}
+
+// UpdateUserPassword changes the user's password.
+func (r *Repository) UpdateUserPassword(ctx context.Context, userID int, password string) error {
+	_, err := r.Db.ExecContext(ctx, "UPDATE users SET password = $1 WHERE user_id = $2", password, userID)
+	return err
+}
+
+// DeleteUser deletes the user together with all shipments, files, metrics, recovery codes and sessions.
+// Physical files are not touched, the caller has to remove them using GetFilesByUserID
+// and GetDataExportsByUserID beforehand.
+func (r *Repository) DeleteUser(ctx context.Context, userID int) (err error) {
+	tx, err := r.Db.BeginTx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "failed to begin transaction")
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		} else {
+			err = tx.Commit()
+		}
+	}()
+
+	queries := []string{
+		`DELETE FROM model_metrics WHERE file_id IN (
+            SELECT f.file_id FROM model_files f JOIN shipments s ON s.shipment_id = f.shipment_id WHERE s.user_id = $1)`,
+		"DELETE FROM schedules WHERE user_id = $1",
+		"DELETE FROM webhooks WHERE user_id = $1",
+		"DELETE FROM registered_models WHERE user_id = $1",
+		"DELETE FROM model_files WHERE shipment_id IN (SELECT shipment_id FROM shipments WHERE user_id = $1)",
+		"DELETE FROM downloaded_files WHERE shipment_id IN (SELECT shipment_id FROM shipments WHERE user_id = $1)",
+		"DELETE FROM shipments WHERE user_id = $1",
+		"DELETE FROM projects WHERE user_id = $1",
+		"DELETE FROM recovery_codes WHERE user_id = $1",
+		"DELETE FROM data_exports WHERE user_id = $1",
+		"DELETE FROM user_quotas WHERE user_id = $1",
+		"DELETE FROM notification_preferences WHERE user_id = $1",
+		"DELETE FROM sessions WHERE user_id = $1",
+		"DELETE FROM users WHERE user_id = $1",
+	}
+	for _, query := range queries {
+		if _, err = tx.ExecContext(ctx, query, userID); err != nil {
+			return errors.Wrap(err, "failed to delete user data")
+		}
+	}
+	return nil
+}
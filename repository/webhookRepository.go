@@ -0,0 +1,286 @@
+package repository
+
+import (
+	"feklistova/models"
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+)
+
+const webhookColumns = "webhook_id, user_id, project_id, url, secret, events, enabled, created_at"
+
+const webhookDeliveryColumns = `d.delivery_id, d.webhook_id, d.event, d.payload, d.status, d.attempts, d.next_attempt_at,
+        d.response_code, d.error, d.created_at, d.delivered_at, w.url, w.secret`
+
+// CreateWebhook registers a new webhook of the project and sets it's ID
+func (r *Repository) CreateWebhook(ctx context.Context, webhook *models.Webhook) error {
+	err := r.Db.QueryRowContext(ctx, `
+        INSERT INTO webhooks (user_id, project_id, url, secret, events, enabled, created_at)
+        VALUES ($1, $2, $3, $4, $5, $6, $7)
+        RETURNING webhook_id`,
+		webhook.UserID,
+		webhook.ProjectID,
+		webhook.URL,
+		webhook.Secret,
+		pq.Array(webhook.Events),
+		webhook.Enabled,
+		webhook.CreatedAt,
+	).Scan(&webhook.WebhookID)
+	if err != nil {
+		return errors.Wrap(err, "failed to insert webhook")
+	}
+	return nil
+}
+
+func (r *Repository) GetWebhookByID(ctx context.Context, webhookID int) (*models.Webhook, error) {
+	webhook, err := scanWebhook(r.Db.QueryRowContext(ctx, `
+        SELECT `+webhookColumns+` FROM webhooks WHERE webhook_id = $1`, webhookID))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("webhook with ID %d not found", webhookID)
+		}
+		return nil, errors.Wrap(err, "failed to scan webhook")
+	}
+	return webhook, nil
+}
+
+// GetWebhooksByProjectID returns all webhooks of the project ordered by creation
+func (r *Repository) GetWebhooksByProjectID(ctx context.Context, projectID int) ([]models.Webhook, error) {
+	return r.queryWebhooks(ctx, `
+        SELECT `+webhookColumns+` FROM webhooks WHERE project_id = $1 ORDER BY created_at`, projectID)
+}
+
+// GetWebhooksByUserID returns all webhooks of the user ordered by creation
+func (r *Repository) GetWebhooksByUserID(ctx context.Context, userID int) ([]models.Webhook, error) {
+	return r.queryWebhooks(ctx, `
+        SELECT `+webhookColumns+` FROM webhooks WHERE user_id = $1 ORDER BY created_at`, userID)
+}
+
+// GetWebhooksForEvent returns the enabled webhooks of the project subscribed to the event
+func (r *Repository) GetWebhooksForEvent(ctx context.Context, projectID int, event string) ([]models.Webhook, error) {
+	return r.queryWebhooks(ctx, `
+        SELECT `+webhookColumns+` FROM webhooks
+        WHERE project_id = $1 AND enabled AND $2 = ANY(events)`, projectID, event)
+}
+
+// UpdateWebhookEnabled enables or pauses the webhook
+func (r *Repository) UpdateWebhookEnabled(ctx context.Context, webhookID int, enabled bool) error {
+	_, err := r.Db.ExecContext(ctx, "UPDATE webhooks SET enabled = $1 WHERE webhook_id = $2", enabled, webhookID)
+	if err != nil {
+		return errors.Wrap(err, "failed to update webhook")
+	}
+	return nil
+}
+
+// DeleteWebhook deletes the webhook together with its delivery log
+func (r *Repository) DeleteWebhook(ctx context.Context, webhookID int) error {
+	_, err := r.Db.ExecContext(ctx, "DELETE FROM webhooks WHERE webhook_id = $1", webhookID)
+	if err != nil {
+		return errors.Wrap(err, "failed to delete webhook")
+	}
+	return nil
+}
+
+// CreateWebhookDelivery queues an event for delivery to the webhook
+func (r *Repository) CreateWebhookDelivery(ctx context.Context, delivery *models.WebhookDelivery) error {
+	err := r.Db.QueryRowContext(ctx, `
+        INSERT INTO webhook_deliveries (webhook_id, event, payload, status, next_attempt_at, created_at)
+        VALUES ($1, $2, $3, $4, $5, $6)
+        RETURNING delivery_id`,
+		delivery.WebhookID,
+		delivery.Event,
+		delivery.Payload,
+		delivery.Status,
+		delivery.NextAttemptAt,
+		delivery.CreatedAt,
+	).Scan(&delivery.DeliveryID)
+	if err != nil {
+		return errors.Wrap(err, "failed to insert webhook delivery")
+	}
+	return nil
+}
+
+// ClaimDueWebhookDeliveries returns the pending deliveries whose attempt time has come and
+// postpones them by lease, so a delivery interrupted by a restart is retried after the lease.
+// Rows are locked with SKIP LOCKED, so with several server instances every attempt is made once.
+// Deliveries of disabled webhooks stay pending until the webhook is enabled again.
+func (r *Repository) ClaimDueWebhookDeliveries(ctx context.Context, now time.Time, lease time.Duration,
+	limit int) (deliveries []models.WebhookDelivery, err error) {
+	tx, err := r.Db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to begin transaction")
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		} else {
+			err = tx.Commit()
+		}
+	}()
+
+	rows, err := tx.QueryContext(ctx, `
+        SELECT `+webhookDeliveryColumns+`
+        FROM webhook_deliveries d
+        JOIN webhooks w ON w.webhook_id = d.webhook_id AND w.enabled
+        WHERE d.status = 'pending' AND d.next_attempt_at <= $1
+        ORDER BY d.next_attempt_at
+        LIMIT $2
+        FOR UPDATE OF d SKIP LOCKED`, now, limit)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to query due webhook deliveries")
+	}
+	for rows.Next() {
+		delivery, err := scanWebhookDelivery(rows)
+		if err != nil {
+			rows.Close()
+			return nil, errors.Wrap(err, "failed to scan webhook delivery row")
+		}
+		deliveries = append(deliveries, *delivery)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "error occurred during iteration")
+	}
+
+	for i := range deliveries {
+		deliveries[i].NextAttemptAt = now.Add(lease)
+		_, err = tx.ExecContext(ctx, "UPDATE webhook_deliveries SET next_attempt_at = $1 WHERE delivery_id = $2",
+			deliveries[i].NextAttemptAt, deliveries[i].DeliveryID)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to lease webhook delivery")
+		}
+	}
+	return deliveries, nil
+}
+
+// UpdateWebhookDelivery saves the result of a delivery attempt
+func (r *Repository) UpdateWebhookDelivery(ctx context.Context, delivery *models.WebhookDelivery) error {
+	_, err := r.Db.ExecContext(ctx, `
+        UPDATE webhook_deliveries
+        SET status = $1, attempts = $2, next_attempt_at = $3, response_code = $4, error = $5, delivered_at = $6
+        WHERE delivery_id = $7`,
+		delivery.Status,
+		delivery.Attempts,
+		delivery.NextAttemptAt,
+		delivery.ResponseCode,
+		delivery.Error,
+		delivery.DeliveredAt,
+		delivery.DeliveryID,
+	)
+	if err != nil {
+		return errors.Wrap(err, "failed to update webhook delivery")
+	}
+	return nil
+}
+
+// GetWebhookDeliveriesByProjectID returns the latest deliveries of the project's webhooks from the newest
+func (r *Repository) GetWebhookDeliveriesByProjectID(ctx context.Context, projectID, limit int) ([]models.WebhookDelivery, error) {
+	return r.queryWebhookDeliveries(ctx, `
+        SELECT `+webhookDeliveryColumns+`
+        FROM webhook_deliveries d
+        JOIN webhooks w ON w.webhook_id = d.webhook_id
+        WHERE w.project_id = $1
+        ORDER BY d.created_at DESC, d.delivery_id DESC
+        LIMIT $2`, projectID, limit)
+}
+
+// GetWebhookDeliveriesByWebhookID returns all deliveries of the webhook from the oldest
+func (r *Repository) GetWebhookDeliveriesByWebhookID(ctx context.Context, webhookID int) ([]models.WebhookDelivery, error) {
+	return r.queryWebhookDeliveries(ctx, `
+        SELECT `+webhookDeliveryColumns+`
+        FROM webhook_deliveries d
+        JOIN webhooks w ON w.webhook_id = d.webhook_id
+        WHERE d.webhook_id = $1
+        ORDER BY d.created_at, d.delivery_id`, webhookID)
+}
+
+func (r *Repository) queryWebhookDeliveries(ctx context.Context, query string, args ...interface{}) ([]models.WebhookDelivery, error) {
+	rows, err := r.Db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to query webhook deliveries")
+	}
+	defer rows.Close()
+
+	var deliveries []models.WebhookDelivery
+	for rows.Next() {
+		delivery, err := scanWebhookDelivery(rows)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to scan webhook delivery row")
+		}
+		deliveries = append(deliveries, *delivery)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "error occurred during iteration")
+	}
+	return deliveries, nil
+}
+
+func (r *Repository) queryWebhooks(ctx context.Context, query string, args ...interface{}) ([]models.Webhook, error) {
+	rows, err := r.Db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to query webhooks")
+	}
+	defer rows.Close()
+
+	var webhooks []models.Webhook
+	for rows.Next() {
+		webhook, err := scanWebhook(rows)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to scan webhook row")
+		}
+		webhooks = append(webhooks, *webhook)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "error occurred during iteration")
+	}
+	return webhooks, nil
+}
+
+func scanWebhook(row rowScanner) (*models.Webhook, error) {
+	var webhook models.Webhook
+	err := row.Scan(
+		&webhook.WebhookID,
+		&webhook.UserID,
+		&webhook.ProjectID,
+		&webhook.URL,
+		&webhook.Secret,
+		pq.Array(&webhook.Events),
+		&webhook.Enabled,
+		&webhook.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &webhook, nil
+}
+
+func scanWebhookDelivery(row rowScanner) (*models.WebhookDelivery, error) {
+	var delivery models.WebhookDelivery
+	var deliveredAt sql.NullTime
+	err := row.Scan(
+		&delivery.DeliveryID,
+		&delivery.WebhookID,
+		&delivery.Event,
+		&delivery.Payload,
+		&delivery.Status,
+		&delivery.Attempts,
+		&delivery.NextAttemptAt,
+		&delivery.ResponseCode,
+		&delivery.Error,
+		&delivery.CreatedAt,
+		&deliveredAt,
+		&delivery.URL,
+		&delivery.Secret,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if deliveredAt.Valid {
+		delivery.DeliveredAt = &deliveredAt.Time
+	}
+	return &delivery, nil
+}
@@ -1,6 +1,24 @@
+DROP TABLE if exists model_dataset_columns;
+DROP TABLE if exists model_forecast_points;
+DROP TABLE if exists model_confusion_matrix;
+DROP TABLE if exists model_class_metrics;
+DROP TABLE if exists notification_preferences;
+DROP TABLE if exists webhook_deliveries;
+DROP TABLE if exists webhooks;
+DROP TABLE if exists shipment_tags;
+DROP TABLE if exists model_stage_transitions;
+DROP TABLE if exists model_versions;
+DROP TABLE if exists registered_models;
+DROP TABLE if exists schedule_runs;
+DROP TABLE if exists schedules;
+DROP TABLE if exists audit_events;
+DROP TABLE if exists user_quotas;
+DROP TABLE if exists data_exports;
+DROP TABLE if exists recovery_codes;
 DROP TABLE if exists users;
 DROP TABLE if exists sessions;
 DROP TABLE if exists shipments;
+DROP TABLE if exists projects;
 DROP TABLE if exists downloaded_files;
 DROP TABLE if exists model_files;
 DROP TABLE if exists model_metrics;
\ No newline at end of file
@@ -4,44 +4,386 @@ CREATE TABLE if not exists users
     username VARCHAR(255) NOT NULL,
     email VARCHAR(255) NOT NULL,
     password VARCHAR(255) NOT NULL,
+    totp_secret VARCHAR(64),
+    totp_enabled BOOLEAN NOT NULL DEFAULT FALSE,
+    -- Последний принятый 30-секундный шаг TOTP: код этого и более ранних шагов повторно не принимается
+    totp_last_step BIGINT,
+    is_admin BOOLEAN NOT NULL DEFAULT FALSE,
+    plan VARCHAR(50) NOT NULL DEFAULT 'free',
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 );
 
+-- Столбцы, добавленные после первой версии таблицы, для уже созданных баз
+ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_secret VARCHAR(64);
+ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_enabled BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_last_step BIGINT;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS is_admin BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS plan VARCHAR(50) NOT NULL DEFAULT 'free';
+
+CREATE TABLE if not exists sessions (
+    session_id SERIAL PRIMARY KEY,
+    user_id INT NOT NULL,
+    expiration_time TIMESTAMP NOT NULL,
+    ip_address VARCHAR(64),
+    user_agent TEXT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES users(user_id)
+);
+
+CREATE TABLE if not exists recovery_codes (
+    code_id SERIAL PRIMARY KEY,
+    user_id INT NOT NULL,
+    code_hash VARCHAR(64) NOT NULL,
+    used_at TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES users(user_id)
+);
+
+-- Скрипт выполняется и на уже созданных базах, поэтому тестовый пользователь добавляется только один раз
 INSERT INTO users (username, email, password)
-VALUES ('a', 'a@gmail.com', 'aaa');
+SELECT 'a', 'a@gmail.com', 'aaa'
+WHERE NOT EXISTS (SELECT 1 FROM users WHERE username = 'a');
+
+CREATE TABLE if not exists projects (
+    project_id SERIAL PRIMARY KEY,
+    user_id INT NOT NULL,
+    name VARCHAR(255) NOT NULL,
+    description TEXT NOT NULL DEFAULT '',
+    default_model_type VARCHAR(50) NOT NULL DEFAULT '',
+    default_target_column VARCHAR(255) NOT NULL DEFAULT '',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (user_id, name),
+    FOREIGN KEY (user_id) REFERENCES users(user_id)
+);
 
 CREATE TABLE if not exists shipments (
     shipment_id SERIAL PRIMARY KEY,
     user_id INT NOT NULL,
+    project_id INT,
+    parent_shipment_id INT,
     modelType VARCHAR(50) NOT NULL,
     projectName VARCHAR(255) NOT NULL,
     algorithm VARCHAR(255) NOT NULL,
     targetColumn VARCHAR(255) NOT NULL,
+    hyperparameters TEXT NOT NULL DEFAULT '{}',
+    forecast TEXT,
+    validation TEXT,
+    column_spec TEXT NOT NULL DEFAULT '{}',
+    note TEXT NOT NULL DEFAULT '',
     status VARCHAR(50) NOT NULL,
     timestamp TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-    FOREIGN KEY (user_id) REFERENCES users(user_id)
+    finished_at TIMESTAMP,
+    deleted_at TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES users(user_id),
+    FOREIGN KEY (project_id) REFERENCES projects(project_id),
+    FOREIGN KEY (parent_shipment_id) REFERENCES shipments(shipment_id) ON DELETE SET NULL
+);
+
+-- Столбцы, добавленные после первой версии таблицы, для уже созданных баз
+ALTER TABLE shipments ADD COLUMN IF NOT EXISTS finished_at TIMESTAMP;
+-- завершенные до появления finished_at запуски иначе считались бы обучающимися до сих пор
+UPDATE shipments SET finished_at = timestamp WHERE finished_at IS NULL AND status IN ('finished', 'failed', 'denied');
+ALTER TABLE shipments ADD COLUMN IF NOT EXISTS project_id INT REFERENCES projects(project_id);
+-- запуски, созданные до появления проектов, объединяются в проекты по названию; тип задачи
+-- и целевой столбец по умолчанию берутся из последнего запуска проекта
+INSERT INTO projects (user_id, name, default_model_type, default_target_column, created_at)
+SELECT DISTINCT ON (user_id, projectName) user_id, projectName, modelType, targetColumn, timestamp
+FROM shipments
+WHERE project_id IS NULL
+ORDER BY user_id, projectName, timestamp DESC
+ON CONFLICT (user_id, name) DO NOTHING;
+UPDATE shipments s SET project_id = p.project_id
+FROM projects p
+WHERE s.project_id IS NULL AND p.user_id = s.user_id AND p.name = s.projectName;
+ALTER TABLE shipments ADD COLUMN IF NOT EXISTS hyperparameters TEXT NOT NULL DEFAULT '{}';
+ALTER TABLE shipments ADD COLUMN IF NOT EXISTS parent_shipment_id INT REFERENCES shipments(shipment_id) ON DELETE SET NULL;
+ALTER TABLE shipments ADD COLUMN IF NOT EXISTS note TEXT NOT NULL DEFAULT '';
+ALTER TABLE shipments ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP;
+ALTER TABLE shipments ADD COLUMN IF NOT EXISTS forecast TEXT;
+ALTER TABLE shipments ADD COLUMN IF NOT EXISTS column_spec TEXT NOT NULL DEFAULT '{}';
+-- текстовые столбцы прежнего поля text_columns переходят в роли столбцов
+DO $$
+BEGIN
+    IF EXISTS (SELECT 1 FROM information_schema.columns WHERE table_name = 'shipments' AND column_name = 'text_columns') THEN
+        UPDATE shipments SET column_spec = (
+            SELECT COALESCE(jsonb_object_agg(c, 'text'), '{}'::jsonb)::text FROM jsonb_array_elements_text(text_columns::jsonb) c)
+        WHERE column_spec = '{}' AND text_columns <> '[]';
+        ALTER TABLE shipments DROP COLUMN text_columns;
+    END IF;
+END $$;
+ALTER TABLE shipments ADD COLUMN IF NOT EXISTS validation TEXT;
+
+CREATE INDEX if not exists shipments_project_idx ON shipments (project_id, timestamp);
+
+-- Корзина: запуски с deleted_at скрыты и окончательно удаляются через config.TrashRetention
+CREATE INDEX if not exists shipments_deleted_idx ON shipments (deleted_at) WHERE deleted_at IS NOT NULL;
+CREATE INDEX if not exists shipments_finished_idx ON shipments (finished_at);
+
+-- Индексы для поиска по запускам пользователя
+CREATE INDEX if not exists shipments_user_idx ON shipments (user_id, timestamp);
+CREATE INDEX if not exists shipments_user_project_name_idx ON shipments (user_id, LOWER(projectName));
+CREATE INDEX if not exists shipments_user_algorithm_idx ON shipments (user_id, algorithm);
+CREATE INDEX if not exists shipments_user_type_status_idx ON shipments (user_id, modelType, status);
+
+-- Произвольные теги запусков, хранятся в нижнем регистре
+CREATE TABLE if not exists shipment_tags (
+    shipment_id INT NOT NULL,
+    tag VARCHAR(50) NOT NULL,
+    PRIMARY KEY (shipment_id, tag),
+    FOREIGN KEY (shipment_id) REFERENCES shipments(shipment_id) ON DELETE CASCADE
+);
+
+CREATE INDEX if not exists shipment_tags_tag_idx ON shipment_tags (tag, shipment_id);
+
+-- Расписания периодического переобучения. Запуск-шаблон задает параметры обучения,
+-- NULL означает последний успешный запуск проекта
+CREATE TABLE if not exists schedules (
+    schedule_id SERIAL PRIMARY KEY,
+    user_id INT NOT NULL,
+    project_id INT NOT NULL,
+    template_shipment_id INT,
+    cron_expr VARCHAR(100) NOT NULL,
+    dataset_path VARCHAR(255) NOT NULL DEFAULT '',
+    dataset_name VARCHAR(255) NOT NULL DEFAULT '',
+    dataset_size BIGINT NOT NULL DEFAULT 0,
+    enabled BOOLEAN NOT NULL DEFAULT TRUE,
+    next_run_at TIMESTAMP NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES users(user_id),
+    FOREIGN KEY (project_id) REFERENCES projects(project_id),
+    FOREIGN KEY (template_shipment_id) REFERENCES shipments(shipment_id) ON DELETE SET NULL
+);
+
+CREATE INDEX if not exists schedules_due_idx ON schedules (next_run_at) WHERE enabled;
+
+CREATE TABLE if not exists schedule_runs (
+    run_id SERIAL PRIMARY KEY,
+    schedule_id INT NOT NULL,
+    shipment_id INT,
+    status VARCHAR(50) NOT NULL,
+    error TEXT NOT NULL DEFAULT '',
+    started_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (schedule_id) REFERENCES schedules(schedule_id) ON DELETE CASCADE,
+    FOREIGN KEY (shipment_id) REFERENCES shipments(shipment_id) ON DELETE SET NULL
 );
 
 CREATE TABLE if not exists downloaded_files (
     file_id SERIAL PRIMARY KEY,
     shipment_id INT NOT NULL,
     filepath VARCHAR(255) NOT NULL,
+    original_name VARCHAR(255) NOT NULL DEFAULT '',
+    size BIGINT NOT NULL DEFAULT 0,
     timestamp TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
     FOREIGN KEY (shipment_id) REFERENCES shipments(shipment_id)
 );
 
+-- Столбцы, добавленные после первой версии таблицы, для уже созданных баз
+ALTER TABLE downloaded_files ADD COLUMN IF NOT EXISTS size BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE downloaded_files ADD COLUMN IF NOT EXISTS original_name VARCHAR(255) NOT NULL DEFAULT '';
+
+CREATE INDEX if not exists downloaded_files_shipment_idx ON downloaded_files (shipment_id);
+
 CREATE TABLE if not exists model_files (
     file_id SERIAL PRIMARY KEY,
     shipment_id INT NOT NULL,
     filepath VARCHAR(255) NOT NULL,
+    size BIGINT NOT NULL DEFAULT 0,
     timestamp TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
     FOREIGN KEY (shipment_id) REFERENCES shipments(shipment_id)
 );
 
+-- Столбцы, добавленные после первой версии таблицы, для уже созданных баз
+ALTER TABLE model_files ADD COLUMN IF NOT EXISTS size BIGINT NOT NULL DEFAULT 0;
+
 CREATE TABLE if not exists model_metrics (
     metric_id SERIAL PRIMARY KEY,
     file_id INT NOT NULL,
     metric_name VARCHAR(255) NOT NULL,
     metric_value FLOAT NOT NULL,
+    metric_std FLOAT, -- стандартное отклонение по фолдам, NULL без кросс-валидации
     FOREIGN KEY (file_id) REFERENCES model_files(file_id)
-);
\ No newline at end of file
+);
+
+-- Столбцы, добавленные после первой версии таблицы, для уже созданных баз
+ALTER TABLE model_metrics ADD COLUMN IF NOT EXISTS metric_std FLOAT;
+
+-- Метрики классификатора по каждому классу, class_index задает порядок классов в матрице ошибок
+CREATE TABLE if not exists model_class_metrics (
+    file_id INT NOT NULL,
+    class_index INT NOT NULL,
+    label VARCHAR(255) NOT NULL,
+    precision FLOAT NOT NULL,
+    recall FLOAT NOT NULL,
+    f1_score FLOAT NOT NULL,
+    support INT NOT NULL,
+    PRIMARY KEY (file_id, class_index),
+    FOREIGN KEY (file_id) REFERENCES model_files(file_id) ON DELETE CASCADE
+);
+
+-- Матрица ошибок классификатора: число объектов класса actual_index, отнесенных к классу predicted_index
+CREATE TABLE if not exists model_confusion_matrix (
+    file_id INT NOT NULL,
+    actual_index INT NOT NULL,
+    predicted_index INT NOT NULL,
+    count INT NOT NULL,
+    PRIMARY KEY (file_id, actual_index, predicted_index),
+    FOREIGN KEY (file_id) REFERENCES model_files(file_id) ON DELETE CASCADE
+);
+
+-- История и прогноз рядов для графика, point_index упорядочивает точки внутри ряда
+CREATE TABLE if not exists model_forecast_points (
+    file_id INT NOT NULL,
+    series_index INT NOT NULL,
+    series_id VARCHAR(255) NOT NULL,
+    predicted BOOLEAN NOT NULL,
+    point_index INT NOT NULL,
+    period VARCHAR(64) NOT NULL,
+    value FLOAT NOT NULL,
+    naive BOOLEAN NOT NULL DEFAULT FALSE, -- прогноз ряда построен сезонной наивной моделью
+    PRIMARY KEY (file_id, series_index, predicted, point_index),
+    FOREIGN KEY (file_id) REFERENCES model_files(file_id) ON DELETE CASCADE
+);
+
+-- Столбцы, добавленные после первой версии таблицы, для уже созданных баз
+ALTER TABLE model_forecast_points ADD COLUMN IF NOT EXISTS naive BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- Столбцы датасета и типы, с которыми они использовались при обучении
+CREATE TABLE if not exists model_dataset_columns (
+    file_id INT NOT NULL,
+    column_index INT NOT NULL,
+    name VARCHAR(255) NOT NULL,
+    type VARCHAR(32) NOT NULL,
+    detected BOOLEAN NOT NULL,
+    PRIMARY KEY (file_id, column_index),
+    FOREIGN KEY (file_id) REFERENCES model_files(file_id) ON DELETE CASCADE
+);
+
+CREATE INDEX if not exists model_files_shipment_idx ON model_files (shipment_id);
+CREATE INDEX if not exists model_metrics_file_name_idx ON model_metrics (file_id, metric_name, metric_value);
+
+-- Реестр моделей: именованные модели проекта и их версии из завершенных запусков
+CREATE TABLE if not exists registered_models (
+    model_id SERIAL PRIMARY KEY,
+    user_id INT NOT NULL,
+    project_id INT NOT NULL,
+    name VARCHAR(255) NOT NULL,
+    description TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (user_id, name),
+    FOREIGN KEY (user_id) REFERENCES users(user_id),
+    FOREIGN KEY (project_id) REFERENCES projects(project_id)
+);
+
+CREATE TABLE if not exists model_versions (
+    version_id SERIAL PRIMARY KEY,
+    model_id INT NOT NULL,
+    version INT NOT NULL,
+    shipment_id INT NOT NULL,
+    model_file_id INT NOT NULL,
+    stage VARCHAR(50) NOT NULL DEFAULT 'none',
+    created_by INT NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (model_id, version),
+    FOREIGN KEY (model_id) REFERENCES registered_models(model_id) ON DELETE CASCADE,
+    FOREIGN KEY (shipment_id) REFERENCES shipments(shipment_id),
+    FOREIGN KEY (model_file_id) REFERENCES model_files(file_id)
+);
+
+CREATE INDEX if not exists model_versions_stage_idx ON model_versions (model_id, stage, version);
+
+CREATE TABLE if not exists model_stage_transitions (
+    transition_id SERIAL PRIMARY KEY,
+    version_id INT NOT NULL,
+    from_stage VARCHAR(50) NOT NULL,
+    to_stage VARCHAR(50) NOT NULL,
+    user_id INT NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (version_id) REFERENCES model_versions(version_id) ON DELETE CASCADE
+);
+
+-- Вебхуки проекта: события жизненного цикла запусков отправляются на url с подписью HMAC-SHA256
+CREATE TABLE if not exists webhooks (
+    webhook_id SERIAL PRIMARY KEY,
+    user_id INT NOT NULL,
+    project_id INT NOT NULL,
+    url VARCHAR(2048) NOT NULL,
+    secret VARCHAR(64) NOT NULL,
+    events TEXT[] NOT NULL,
+    enabled BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES users(user_id),
+    FOREIGN KEY (project_id) REFERENCES projects(project_id)
+);
+
+CREATE INDEX if not exists webhooks_project_idx ON webhooks (project_id);
+
+-- Очередь и журнал доставки событий, неудачные попытки повторяются с экспоненциальной задержкой
+CREATE TABLE if not exists webhook_deliveries (
+    delivery_id SERIAL PRIMARY KEY,
+    webhook_id INT NOT NULL,
+    event VARCHAR(100) NOT NULL,
+    payload TEXT NOT NULL,
+    status VARCHAR(50) NOT NULL,
+    attempts INT NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMP NOT NULL,
+    response_code INT NOT NULL DEFAULT 0,
+    error TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    delivered_at TIMESTAMP,
+    FOREIGN KEY (webhook_id) REFERENCES webhooks(webhook_id) ON DELETE CASCADE
+);
+
+CREATE INDEX if not exists webhook_deliveries_due_idx ON webhook_deliveries (next_attempt_at) WHERE status = 'pending';
+CREATE INDEX if not exists webhook_deliveries_webhook_idx ON webhook_deliveries (webhook_id, created_at);
+
+CREATE TABLE if not exists data_exports (
+    export_id SERIAL PRIMARY KEY,
+    user_id INT NOT NULL,
+    token VARCHAR(64) NOT NULL UNIQUE,
+    status VARCHAR(50) NOT NULL,
+    filepath VARCHAR(255) NOT NULL DEFAULT '',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    expires_at TIMESTAMP NOT NULL,
+    FOREIGN KEY (user_id) REFERENCES users(user_id)
+);
+
+-- Индивидуальные лимиты пользователя, NULL означает лимит тарифного плана
+CREATE TABLE if not exists user_quotas (
+    user_id INT PRIMARY KEY,
+    max_storage_bytes BIGINT,
+    max_concurrent_shipments INT,
+    max_training_minutes INT,
+    max_dataset_bytes BIGINT,
+    FOREIGN KEY (user_id) REFERENCES users(user_id)
+);
+
+-- Настройки email уведомлений пользователя, без записи уведомления выключены
+CREATE TABLE if not exists notification_preferences (
+    user_id INT PRIMARY KEY,
+    notify_finished BOOLEAN NOT NULL DEFAULT FALSE,
+    notify_failed BOOLEAN NOT NULL DEFAULT FALSE,
+    notify_denied BOOLEAN NOT NULL DEFAULT FALSE,
+    FOREIGN KEY (user_id) REFERENCES users(user_id)
+);
+
+-- Журнал событий безопасности: записи только добавляются, изменение и удаление запрещены правилами
+CREATE TABLE if not exists audit_events (
+    event_id BIGSERIAL PRIMARY KEY,
+    actor_id INT,
+    action VARCHAR(100) NOT NULL,
+    target TEXT NOT NULL DEFAULT '',
+    ip_address TEXT NOT NULL DEFAULT '',
+    user_agent TEXT NOT NULL DEFAULT '',
+    outcome VARCHAR(50) NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Столбцы, тип которых изменился после первой версии таблицы, для уже созданных баз:
+-- длинный объект (например, путь запроса при ошибке CSRF) или адрес клиента не должен приводить к ошибке записи события
+ALTER TABLE audit_events ALTER COLUMN target TYPE TEXT, ALTER COLUMN ip_address TYPE TEXT;
+
+CREATE INDEX if not exists audit_events_actor_idx ON audit_events (actor_id, created_at);
+CREATE INDEX if not exists audit_events_action_idx ON audit_events (action, created_at);
+CREATE INDEX if not exists audit_events_created_idx ON audit_events (created_at);
+
+CREATE OR REPLACE RULE audit_events_no_update AS ON UPDATE TO audit_events DO INSTEAD NOTHING;
+CREATE OR REPLACE RULE audit_events_no_delete AS ON DELETE TO audit_events DO INSTEAD NOTHING;
\ No newline at end of file
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"feklistova/models"
+	"context"
+	"database/sql"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/csrf"
+	"github.com/pkg/errors"
+)
+
+type ProfileEditPage struct {
+	CSRFField template.HTML
+	Username  string
+	Email     string
+	Message   string
+	Notify    *models.NotificationPreferences
+}
+
+var profileEditMessages = map[string]string{
+	"profile":       "Данные профиля сохранены",
+	"password":      "Пароль изменен",
+	"notifications": "Настройки уведомлений сохранены",
+}
+
+func ProfileEditHandlerTmpl(w http.ResponseWriter, r *http.Request) {
+	if !IsAuthorized(r) {
+		http.Redirect(w, r, "/users/enter", http.StatusSeeOther)
+		return
+	}
+	userID := GetUserID(r)
+
+	ctx, cancel := context.WithTimeout(r.Context(), time.Second*5)
+	defer cancel()
+
+	user, err := repo.GetUserByID(ctx, userID)
+	if err != nil {
+		log.Printf("Failed to load user %d: %v", userID, err)
+		http.Error(w, "Unable to identify user", http.StatusInternalServerError)
+		return
+	}
+	prefs, err := repo.GetNotificationPreferences(ctx, userID)
+	if err != nil {
+		log.Printf("Failed to load notification preferences of user %d: %v", userID, err)
+		http.Error(w, "Error loading profile", http.StatusInternalServerError)
+		return
+	}
+
+	renderTmpl(w, "web/profile_edit.html", ProfileEditPage{
+		CSRFField: csrf.TemplateField(r),
+		Username:  user.Username,
+		Email:     user.Email,
+		Message:   profileEditMessages[r.URL.Query().Get("updated")],
+		Notify:    prefs,
+	})
+}
+
+func ProfileUpdateHandler(w http.ResponseWriter, r *http.Request) {
+	if !IsAuthorized(r) {
+		http.Redirect(w, r, "/users/enter", http.StatusSeeOther)
+		return
+	}
+	userID := GetUserID(r)
+
+	err := r.ParseMultipartForm(10 << 20)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Error parsing form data", http.StatusBadRequest)
+		return
+	}
+
+	name := strings.TrimSpace(r.FormValue("name"))
+	email := strings.TrimSpace(r.FormValue("email"))
+	if name == "" || email == "" {
+		http.Error(w, "Name and email must not be empty", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), time.Second*5)
+	defer cancel()
+
+	existing, err := repo.GetUserByEmail(ctx, email)
+	if err == nil && existing.ID != userID {
+		http.Error(w, "Email is already in use", http.StatusConflict)
+		return
+	} else if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		log.Printf("Failed to check email for user %d: %v", userID, err)
+		http.Error(w, "Error updating profile", http.StatusInternalServerError)
+		return
+	}
+
+	if err := repo.UpdateUserProfile(ctx, userID, name, email); err != nil {
+		log.Printf("Failed to update profile of user %d: %v", userID, err)
+		http.Error(w, "Error updating profile", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("User %d updated profile", userID)
+	recordAudit(r, userID, auditProfileUpdate, fmt.Sprintf("user:%d", userID), outcomeSuccess)
+
+	http.Redirect(w, r, "/profile/edit?updated=profile", http.StatusSeeOther)
+}
+
+func PasswordChangeHandler(w http.ResponseWriter, r *http.Request) {
+	if !IsAuthorized(r) {
+		http.Redirect(w, r, "/users/enter", http.StatusSeeOther)
+		return
+	}
+	userID := GetUserID(r)
+
+	err := r.ParseMultipartForm(10 << 20)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Error parsing form data", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), time.Second*5)
+	defer cancel()
+
+	if !checkCurrentPassword(ctx, w, userID, r.FormValue("current_password")) {
+		recordAudit(r, userID, auditPasswordChange, fmt.Sprintf("user:%d", userID), outcomeFailure)
+		return
+	}
+
+	password := r.FormValue("password")
+	confirmPassword := r.FormValue("confirm_password")
+	if password == "" {
+		http.Error(w, "password must not be empty", http.StatusBadRequest)
+		return
+	}
+	if password != confirmPassword {
+		http.Error(w, "password doesn't match confirm password", http.StatusBadRequest)
+		return
+	}
+
+	if err := repo.UpdateUserPassword(ctx, userID, password); err != nil {
+		log.Printf("Failed to change password of user %d: %v", userID, err)
+		http.Error(w, "Error changing password", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("User %d changed password", userID)
+	recordAudit(r, userID, auditPasswordChange, fmt.Sprintf("user:%d", userID), outcomeSuccess)
+
+	http.Redirect(w, r, "/profile/edit?updated=password", http.StatusSeeOther)
+}
+
+func AccountDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	if !IsAuthorized(r) {
+		http.Redirect(w, r, "/users/enter", http.StatusSeeOther)
+		return
+	}
+	userID := GetUserID(r)
+
+	err := r.ParseMultipartForm(10 << 20)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Error parsing form data", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), time.Second*30)
+	defer cancel()
+
+	if !checkCurrentPassword(ctx, w, userID, r.FormValue("current_password")) {
+		recordAudit(r, userID, auditAccountDelete, fmt.Sprintf("user:%d", userID), outcomeFailure)
+		return
+	}
+
+	downloadedFiles, uploadedFiles, err := repo.GetFilesByUserID(ctx, userID)
+	if err != nil {
+		log.Printf("Failed to get files of user %d: %v", userID, err)
+		http.Error(w, "Error deleting account", http.StatusInternalServerError)
+		return
+	}
+
+	exports, err := repo.GetDataExportsByUserID(ctx, userID)
+	if err != nil {
+		log.Printf("Failed to get data exports of user %d: %v", userID, err)
+		http.Error(w, "Error deleting account", http.StatusInternalServerError)
+		return
+	}
+
+	schedules, err := repo.GetSchedulesByUserID(ctx, userID)
+	if err != nil {
+		log.Printf("Failed to get schedules of user %d: %v", userID, err)
+		http.Error(w, "Error deleting account", http.StatusInternalServerError)
+		return
+	}
+
+	if err := repo.DeleteUser(ctx, userID); err != nil {
+		log.Printf("Failed to delete user %d: %v", userID, err)
+		http.Error(w, "Error deleting account", http.StatusInternalServerError)
+		return
+	}
+
+	for _, file := range append(downloadedFiles, uploadedFiles...) {
+		if file.FilePath == "" {
+			continue
+		}
+		if err := fileRepo.DeleteFileByPath(file.FilePath); err != nil {
+			log.Printf("Failed to delete file with ID %d: %v", file.FileID, err)
+		}
+	}
+	for _, export := range exports {
+		if export.FilePath == "" {
+			continue
+		}
+		if err := fileRepo.DeleteFileByPath(export.FilePath); err != nil {
+			log.Printf("Failed to delete data export %d: %v", export.ExportID, err)
+		}
+	}
+	for _, schedule := range schedules {
+		if schedule.DatasetPath == "" {
+			continue
+		}
+		if err := fileRepo.DeleteFileByPath(schedule.DatasetPath); err != nil {
+			log.Printf("Failed to delete dataset of schedule %d: %v", schedule.ScheduleID, err)
+		}
+	}
+	log.Printf("User %d deleted account with %d files", userID, len(downloadedFiles)+len(uploadedFiles))
+	recordAudit(r, userID, auditAccountDelete, fmt.Sprintf("user:%d", userID), outcomeSuccess)
+
+	clearSession(w, r)
+	http.Redirect(w, r, "/home", http.StatusSeeOther)
+}
+
+// checkCurrentPassword re-authenticates the user before a sensitive action.
+// On failure it writes the response itself.
+func checkCurrentPassword(ctx context.Context, w http.ResponseWriter, userID int, password string) bool {
+	user, err := repo.GetUserByID(ctx, userID)
+	if err != nil {
+		log.Printf("Failed to load user %d: %v", userID, err)
+		http.Error(w, "Unable to identify user", http.StatusInternalServerError)
+		return false
+	}
+	if password != user.Password {
+		http.Error(w, "Invalid password", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"feklistova/models"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/csrf"
+)
+
+// Действия, которые попадают в журнал событий безопасности
+const (
+	auditLogin              = "user.login"
+	auditLoginNewIP         = "user.login_new_ip"
+	auditLoginTwoFactor     = "user.login_2fa"
+	auditRegister           = "user.register"
+	auditProfileUpdate      = "user.profile_update"
+	auditPasswordChange     = "user.password_change"
+	auditAccountDelete      = "user.delete"
+	auditTwoFactorEnable    = "user.2fa_enable"
+	auditTwoFactorDisable   = "user.2fa_disable"
+	auditTwoFactorVerify    = "user.2fa_verify"
+	auditRecoveryCodes      = "user.recovery_codes"
+	auditLockout            = "security.lockout"
+	auditCSRFFailure        = "security.csrf_failure"
+	auditShipmentCreate     = "shipment.create"
+	auditShipmentDownload   = "shipment.download"
+	auditShipmentDelete     = "shipment.delete"
+	auditShipmentRestore    = "shipment.restore"
+	auditShipmentPurge      = "shipment.purge"
+	auditModelVersionCreate = "registry.version_create"
+	auditModelStageChange   = "registry.stage_change"
+	auditDataExport         = "data_export.create"
+	auditDataExportDownload = "data_export.download"
+	auditAdminAccess        = "admin.access"
+	auditAdminAuditView     = "admin.audit_view"
+	auditAdminAuditExport   = "admin.audit_export"
+)
+
+// Результаты действий в журнале событий
+const (
+	outcomeSuccess = "success"
+	outcomeFailure = "failure"
+	outcomeDenied  = "denied"
+	// пароль верный, но вход ждет второго фактора
+	outcomeTwoFactorRequired = "2fa_required"
+)
+
+const auditPageSize = 100
+
+type AuditPage struct {
+	CSRFField template.HTML
+	Events    []models.AuditEvent
+	Filter    url.Values
+	CSVQuery  template.URL
+	PrevQuery template.URL
+	NextQuery template.URL
+}
+
+// recordAudit appends an event to the audit log. Failures are only logged so that
+// the audited action itself is never interrupted by the audit log.
+func recordAudit(r *http.Request, actorID int, action, target, outcome string) {
+	event := &models.AuditEvent{
+		ActorID:   actorID,
+		Action:    action,
+		Target:    target,
+		IPAddress: clientIP(r),
+		UserAgent: r.UserAgent(),
+		Outcome:   outcome,
+		CreatedAt: time.Now(),
+	}
+
+	// the request context may already be cancelled, the event must be saved anyway
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	if err := repo.CreateAuditEvent(ctx, event); err != nil {
+		log.Printf("Failed to record audit event %s for actor %d: %v", action, actorID, err)
+	}
+}
+
+// recordLogin records a successful login and marks logins from an IP address the user has never used before
+func recordLogin(r *http.Request, userID int) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	known, err := repo.HasAuditEventFromIP(ctx, userID, auditLogin, clientIP(r))
+	if err != nil {
+		log.Printf("Failed to check login history of user %d: %v", userID, err)
+	} else if !known {
+		recordAudit(r, userID, auditLoginNewIP, fmt.Sprintf("user:%d", userID), outcomeSuccess)
+	}
+	recordAudit(r, userID, auditLogin, fmt.Sprintf("user:%d", userID), outcomeSuccess)
+}
+
+// currentActorID returns the logged in user or 0 for anonymous requests
+func currentActorID(r *http.Request) int {
+	if !IsAuthorized(r) {
+		return 0
+	}
+	userID := GetUserID(r)
+	if userID == -1 {
+		return 0
+	}
+	return userID
+}
+
+// requireAdmin checks that the request comes from an administrator.
+// On failure it writes the response itself.
+func requireAdmin(w http.ResponseWriter, r *http.Request) (int, bool) {
+	if !IsAuthorized(r) {
+		http.Redirect(w, r, "/users/enter", http.StatusSeeOther)
+		return 0, false
+	}
+	userID := GetUserID(r)
+
+	ctx, cancel := context.WithTimeout(r.Context(), time.Second*5)
+	defer cancel()
+
+	user, err := repo.GetUserByID(ctx, userID)
+	if err != nil {
+		log.Printf("Failed to load user %d: %v", userID, err)
+		http.Error(w, "Unable to identify user", http.StatusInternalServerError)
+		return 0, false
+	}
+	if !user.IsAdmin {
+		log.Printf("User %d attempted to access %s without admin rights", userID, r.URL.Path)
+		recordAudit(r, userID, auditAdminAccess, r.URL.Path, outcomeDenied)
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return 0, false
+	}
+	return userID, true
+}
+
+func parseAuditFilter(query url.Values) (models.AuditFilter, error) {
+	filter := models.AuditFilter{
+		Action:  query.Get("action"),
+		Target:  query.Get("target"),
+		Outcome: query.Get("outcome"),
+		IP:      query.Get("ip"),
+		Limit:   auditPageSize,
+	}
+
+	var err error
+	if value := query.Get("actor_id"); value != "" {
+		if filter.ActorID, err = strconv.Atoi(value); err != nil {
+			return filter, fmt.Errorf("invalid actor_id: %s", value)
+		}
+	}
+	if value := query.Get("from"); value != "" {
+		if filter.From, err = time.Parse("2006-01-02", value); err != nil {
+			return filter, fmt.Errorf("invalid from date: %s", value)
+		}
+	}
+	if value := query.Get("to"); value != "" {
+		if filter.To, err = time.Parse("2006-01-02", value); err != nil {
+			return filter, fmt.Errorf("invalid to date: %s", value)
+		}
+		// the end date is inclusive
+		filter.To = filter.To.AddDate(0, 0, 1)
+	}
+	if value := query.Get("limit"); value != "" {
+		if filter.Limit, err = strconv.Atoi(value); err != nil || filter.Limit <= 0 {
+			return filter, fmt.Errorf("invalid limit: %s", value)
+		}
+	}
+	if value := query.Get("offset"); value != "" {
+		if filter.Offset, err = strconv.Atoi(value); err != nil || filter.Offset < 0 {
+			return filter, fmt.Errorf("invalid offset: %s", value)
+		}
+	}
+	return filter, nil
+}
+
+func AuditHandlerTmpl(w http.ResponseWriter, r *http.Request) {
+	adminID, ok := requireAdmin(w, r)
+	if !ok {
+		return
+	}
+
+	query := r.URL.Query()
+	filter, err := parseAuditFilter(query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), time.Second*10)
+	defer cancel()
+
+	events, err := repo.GetAuditEvents(ctx, filter)
+	if err != nil {
+		log.Printf("Failed to load audit events: %v", err)
+		http.Error(w, "Error loading audit events", http.StatusInternalServerError)
+		return
+	}
+	recordAudit(r, adminID, auditAdminAuditView, query.Encode(), outcomeSuccess)
+
+	page := AuditPage{
+		CSRFField: csrf.TemplateField(r),
+		Events:    events,
+		Filter:    query,
+	}
+
+	csvQuery := cloneValues(query)
+	csvQuery.Set("format", "csv")
+	csvQuery.Del("limit")
+	csvQuery.Del("offset")
+	page.CSVQuery = template.URL(csvQuery.Encode())
+
+	if filter.Offset > 0 {
+		prev := cloneValues(query)
+		prev.Set("offset", strconv.Itoa(max(filter.Offset-filter.Limit, 0)))
+		page.PrevQuery = template.URL(prev.Encode())
+	}
+	if len(events) == filter.Limit {
+		next := cloneValues(query)
+		next.Set("offset", strconv.Itoa(filter.Offset+filter.Limit))
+		page.NextQuery = template.URL(next.Encode())
+	}
+
+	renderTmpl(w, "web/audit.html", page)
+}
+
+// AuditAPIHandler returns audit events as JSON, or as a CSV file when format=csv is passed
+func AuditAPIHandler(w http.ResponseWriter, r *http.Request) {
+	adminID, ok := requireAdmin(w, r)
+	if !ok {
+		return
+	}
+
+	query := r.URL.Query()
+	filter, err := parseAuditFilter(query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	asCSV := query.Get("format") == "csv"
+	if asCSV && query.Get("limit") == "" {
+		filter.Limit = 0
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), time.Second*30)
+	defer cancel()
+
+	events, err := repo.GetAuditEvents(ctx, filter)
+	if err != nil {
+		log.Printf("Failed to load audit events: %v", err)
+		http.Error(w, "Error loading audit events", http.StatusInternalServerError)
+		return
+	}
+
+	if !asCSV {
+		recordAudit(r, adminID, auditAdminAuditView, query.Encode(), outcomeSuccess)
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(events); err != nil {
+			log.Printf("Failed to send audit events: %v", err)
+		}
+		return
+	}
+
+	recordAudit(r, adminID, auditAdminAuditExport, query.Encode(), outcomeSuccess)
+	w.Header().Set("Content-Disposition", "attachment; filename=audit.csv")
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+
+	writer := csv.NewWriter(w)
+	writer.Write([]string{"event_id", "created_at", "actor_id", "action", "target", "ip_address", "user_agent", "outcome"})
+	for _, event := range events {
+		writer.Write([]string{
+			strconv.FormatInt(event.EventID, 10),
+			event.CreatedAt.Format(time.RFC3339),
+			strconv.Itoa(event.ActorID),
+			event.Action,
+			event.Target,
+			event.IPAddress,
+			event.UserAgent,
+			event.Outcome,
+		})
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		log.Printf("Failed to send audit events: %v", err)
+	}
+}
+
+func cloneValues(values url.Values) url.Values {
+	clone := make(url.Values, len(values))
+	for key, value := range values {
+		clone[key] = append([]string(nil), value...)
+	}
+	return clone
+}
@@ -1,10 +1,10 @@
 package main
 
 import (
+	"feklistova/config"
 	"feklistova/models"
 	"log"
 	"net/http"
-	"os"
 
 	"context"
 	"time"
@@ -27,21 +27,30 @@ func LoginHandler(w http.ResponseWriter, r *http.Request) {
 
 	user, err := repo.GetUserByEmail(ctx, email)
 	if err != nil {
+		recordAudit(r, 0, auditLogin, email, outcomeFailure)
 		http.Error(w, "Invalid username or password", http.StatusUnauthorized)
 		return
 	}
 
 	if password != user.Password {
+		recordAudit(r, 0, auditLogin, email, outcomeFailure)
 		http.Error(w, "Invalid username or password", http.StatusUnauthorized)
 		return
 	}
 
-	session, _ := store.Get(r, "secret")
-	session.Values["authenticated"] = true
-	session.Values["user_id"] = user.ID
-	session.Save(r, w)
+	if user.TOTPEnabled {
+		recordAudit(r, user.ID, auditLogin, email, outcomeTwoFactorRequired)
+		session, _ := store.Get(r, "secret")
+		session.Values["2fa_pending_user_id"] = user.ID
+		session.Values["2fa_pending_until"] = time.Now().Add(config.TwoFactorPendingTimeout).Unix()
+		session.Save(r, w)
 
-	http.Redirect(w, r, "/profile", http.StatusSeeOther)
+		http.Redirect(w, r, "/users/enter/2fa", http.StatusSeeOther)
+		return
+	}
+
+	recordLogin(r, user.ID)
+	startSession(w, r, user.ID, config.Require2FA)
 }
 
 func RegisterHandler(w http.ResponseWriter, r *http.Request) {
@@ -71,28 +80,56 @@ func RegisterHandler(w http.ResponseWriter, r *http.Request) {
 		Username:  name,
 		Email:     email,
 		Password:  password,
+		Plan:      config.DefaultPlan,
 		CreatedAt: time.Now(),
 	})
 	if err != nil {
+		recordAudit(r, 0, auditRegister, email, outcomeFailure)
 		http.Error(w, "Error registering user", http.StatusInternalServerError)
 		return
 	}
 
 	log.Printf("User %s registered successfully with ID: %d", name, userID)
+	recordAudit(r, userID, auditRegister, email, outcomeSuccess)
 
 	// создаем сессию
+	startSession(w, r, userID, config.Require2FA)
+}
+
+// startSession authenticates the user in the session and redirects to the profile.
+// If two-factor setup is required, the user is sent to the enrolment page instead
+// and stays unauthorized for the rest of the site until the setup is finished.
+func startSession(w http.ResponseWriter, r *http.Request, userID int, setupRequired bool) {
 	session, _ := store.Get(r, "secret")
+	delete(session.Values, "2fa_pending_user_id")
+	delete(session.Values, "2fa_pending_until")
 	session.Values["authenticated"] = true
 	session.Values["user_id"] = userID
+	session.Values["2fa_setup_required"] = setupRequired
 	session.Save(r, w)
 
+	if setupRequired {
+		http.Redirect(w, r, "/profile/2fa", http.StatusSeeOther)
+		return
+	}
 	http.Redirect(w, r, "/profile", http.StatusSeeOther)
 }
 
+// clearSession logs the user out by expiring the session cookie
+func clearSession(w http.ResponseWriter, r *http.Request) {
+	session, _ := store.Get(r, "secret")
+	for key := range session.Values {
+		delete(session.Values, key)
+	}
+	session.Options.MaxAge = -1
+	session.Save(r, w)
+}
+
 func IsAuthorized(r *http.Request) bool {
 	session, _ := store.Get(r, "secret")
 	authenticated := session.Values["authenticated"]
-	return authenticated != nil && authenticated.(bool)
+	setupRequired, _ := session.Values["2fa_setup_required"].(bool)
+	return authenticated != nil && authenticated.(bool) && !setupRequired
 }
 
 func GetUserID(r *http.Request) int {
@@ -105,20 +142,3 @@ func GetUserID(r *http.Request) int {
 	}
 	return intValue
 }
-
-func ProfileHandler(w http.ResponseWriter, r *http.Request) {
-	if IsAuthorized(r) {
-		htmlData, err := os.ReadFile("web/profile.html")
-		if err != nil {
-			http.Error(w, "Error reading HTML file", http.StatusInternalServerError)
-			return
-		}
-		w.Header().Set("Content-Type", "text/html; charset=utf-8")
-		_, err = w.Write(htmlData)
-		if err != nil {
-			log.Println("Error serving HTML file:", err)
-		}
-	} else {
-		http.Redirect(w, r, "/users/enter", http.StatusSeeOther)
-	}
-}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"feklistova/models"
+	"feklistova/python"
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/csrf"
+	"github.com/gorilla/mux"
+)
+
+// datasetSource is the dataset of a new run: a freshly uploaded file or the dataset of the cloned run
+type datasetSource struct {
+	io.ReadCloser
+	Name      string
+	Extension string
+	Size      int64
+}
+
+// CloneShipmentHandlerTmpl shows the model form prefilled with the settings and the dataset of an existing run
+func CloneShipmentHandlerTmpl(w http.ResponseWriter, r *http.Request) {
+	if !IsAuthorized(r) {
+		http.Redirect(w, r, "/users/enter", http.StatusSeeOther)
+		return
+	}
+	userID := GetUserID(r)
+
+	shipmentID, err := strconv.Atoi(mux.Vars(r)["shipment_id"])
+	if err != nil {
+		http.Error(w, "Invalid shipment ID", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), time.Second*5)
+	defer cancel()
+
+	parent, dataset, ok := parentShipment(ctx, w, userID, shipmentID)
+	if !ok {
+		return
+	}
+	if !python.ValidModelType(parent.ModelType) {
+		log.Printf("Unknown ModelType for shipment ID %d: %s", shipmentID, parent.ModelType)
+		http.Error(w, "Failed to parse ModelType", http.StatusInternalServerError)
+		return
+	}
+
+	projects, err := repo.GetProjectsByUserID(ctx, userID)
+	if err != nil {
+		log.Printf("Failed to load projects of user %d: %v", userID, err)
+		http.Error(w, "Error loading projects", http.StatusInternalServerError)
+		return
+	}
+
+	page := ModelFormPage{
+		CSRFField:    csrf.TemplateField(r),
+		Projects:     projects,
+		ProjectName:  parent.ProjectName,
+		TargetColumn: parent.TargetColumn,
+		Algorithm:    parent.Algorithm,
+		Algorithms:   algorithmOptions(parent.ModelType, parent.Algorithm, parent.Hyperparameters),
+		ParentID:     parent.ShipmentID,
+	}
+	page.ColumnRoles = columnRoleValues(parent.Columns)
+	page.Validation = validationFormValues(parent.Validation)
+	if description, ok := python.LookupModelType(parent.ModelType); ok {
+		page.ValidationStrategies = description.ValidationStrategies()
+		page.WeightColumn = description.AcceptsColumnRole("weight")
+	}
+	if parent.Forecast != nil {
+		page.Forecast = *parent.Forecast
+	}
+	if dataset != nil {
+		page.ParentDataset = dataset.OriginalName
+		if page.ParentDataset == "" {
+			page.ParentDataset = filepath.Base(dataset.FilePath)
+		}
+	}
+
+	renderTmpl(w, "web/model_form_"+parent.ModelType+".html", page, modelFormPartial)
+}
+
+// parentShipment loads a run of the user that is being cloned together with its dataset.
+// The dataset is nil when the files of the run were already removed, so the form asks for a new upload.
+// On failure it writes the response itself.
+func parentShipment(ctx context.Context, w http.ResponseWriter, userID, shipmentID int) (*models.Shipment, *models.File, bool) {
+	parent, err := repo.GetShipmentByID(ctx, shipmentID)
+	if err != nil || parent.UserID != userID {
+		log.Printf("User %d requested unknown shipment %d: %v", userID, shipmentID, err)
+		http.Error(w, "Shipment not found", http.StatusNotFound)
+		return nil, nil, false
+	}
+
+	files, err := repo.GetDownloadedFilesByShipmentID(ctx, shipmentID)
+	if err != nil {
+		log.Printf("Failed to get download files for shipment ID %d: %v", shipmentID, err)
+		http.Error(w, "Failed to get download files", http.StatusInternalServerError)
+		return nil, nil, false
+	}
+	if len(files) == 0 || files[0].FilePath == "" {
+		return parent, nil, true
+	}
+	// the record outlives the file when the run was purged from the trash or the storage was cleaned
+	if _, err := os.Stat(files[0].FilePath); os.IsNotExist(err) {
+		log.Printf("Dataset %s of shipment %d is missing", files[0].FilePath, shipmentID)
+		return parent, nil, true
+	}
+	return parent, &files[0], true
+}
+
+// shipmentDataset returns the dataset uploaded with the form. When a cloned run is submitted
+// without a file, the dataset of the parent run is reused.
+func shipmentDataset(r *http.Request, parentDataset *models.File) (*datasetSource, error) {
+	file, fileHeader, err := r.FormFile("file")
+	if err == nil {
+		return &datasetSource{
+			ReadCloser: file,
+			Name:       fileHeader.Filename,
+			Extension:  fileExtension(fileHeader.Filename),
+			Size:       fileHeader.Size,
+		}, nil
+	}
+	if !errors.Is(err, http.ErrMissingFile) || parentDataset == nil {
+		return nil, err
+	}
+
+	return openStoredDataset(parentDataset.FilePath, parentDataset.OriginalName, parentDataset.Size)
+}
+
+func fileExtension(filename string) string {
+	filenameParts := strings.Split(filepath.Base(filename), ".")
+	if len(filenameParts) > 1 {
+		return filenameParts[len(filenameParts)-1]
+	}
+	return ""
+}
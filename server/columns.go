@@ -0,0 +1,261 @@
+package main
+
+import (
+	"feklistova/python"
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+)
+
+// Поля формы обучения со столбцами каждой роли через запятую, в порядке отображения
+var columnRoleFields = []struct {
+	Role  string
+	Field string
+}{
+	{"ignore", "ignore_columns"},
+	{"id", "id_columns"},
+	{"categorical", "categorical_columns"},
+	{"numeric", "numeric_columns"},
+	{"text", "text_columns"},
+	{"datetime", "datetime_columns"},
+	{"weight", "weight_column"},
+}
+
+// parseColumnSpec reads the roles the user set for dataset columns. Every column gets at most one role,
+// the target can not get any and only one weight column is allowed. nil is returned when no roles are set.
+func parseColumnSpec(r *http.Request, modelType python.ModelType, targetColumn string) (map[string]string, error) {
+	var spec map[string]string
+	for _, field := range columnRoleFields {
+		value := strings.TrimSpace(r.FormValue(field.Field))
+		if value == "" {
+			continue
+		}
+		if !modelType.AcceptsColumnRole(field.Role) {
+			return nil, fmt.Errorf("column role %s is not supported for model type %s", field.Role, modelType.Name)
+		}
+
+		count := 0
+		for _, column := range strings.Split(value, ",") {
+			column = strings.TrimSpace(column)
+			if column == "" {
+				continue
+			}
+			if column == targetColumn {
+				return nil, fmt.Errorf("target column %s can not have a role", column)
+			}
+			if role, ok := spec[column]; ok && role != field.Role {
+				return nil, fmt.Errorf("column %s has two roles: %s and %s", column, role, field.Role)
+			}
+			if spec == nil {
+				spec = make(map[string]string)
+			}
+			spec[column] = field.Role
+			count++
+		}
+		if field.Role == "weight" && count > 1 {
+			return nil, fmt.Errorf("only one weight column is allowed")
+		}
+	}
+	return spec, nil
+}
+
+// columnRoleValues returns the columns of every role joined for the form fields
+func columnRoleValues(spec map[string]string) map[string]string {
+	values := make(map[string]string)
+	for _, field := range columnRoleFields {
+		var columns []string
+		for column, role := range spec {
+			if role == field.Role {
+				columns = append(columns, column)
+			}
+		}
+		sort.Strings(columns)
+		values[field.Role] = strings.Join(columns, ", ")
+	}
+	return values
+}
+
+// validateDatasetColumns checks that every column the shipment refers to is in the dataset header.
+// Headers of csv and xlsx datasets are read in Go, for other formats the check is left to the training script.
+func validateDatasetColumns(dataset *datasetSource, columns []string) error {
+	header, err := datasetHeader(dataset)
+	if err != nil {
+		return fmt.Errorf("unable to read dataset header: %w", err)
+	}
+	if header == nil {
+		return nil
+	}
+
+	known := make(map[string]bool, len(header))
+	for _, name := range header {
+		known[name] = true
+	}
+	var missing []string
+	for _, column := range columns {
+		if column != "" && !known[column] {
+			missing = append(missing, column)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("columns not found in the dataset: %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// datasetHeader reads the column names of the dataset without consuming it, nil is returned for unsupported formats
+func datasetHeader(dataset *datasetSource) ([]string, error) {
+	readerAt, ok := dataset.ReadCloser.(io.ReaderAt)
+	if !ok || dataset.Size <= 0 {
+		return nil, nil
+	}
+
+	switch dataset.Extension {
+	case "csv":
+		reader := csv.NewReader(io.NewSectionReader(readerAt, 0, dataset.Size))
+		reader.FieldsPerRecord = -1
+		reader.LazyQuotes = true
+		header, err := reader.Read()
+		if err != nil {
+			return nil, err
+		}
+		if len(header) > 0 {
+			header[0] = strings.TrimPrefix(header[0], "\ufeff")
+		}
+		return header, nil
+	case "xlsx":
+		return xlsxHeader(readerAt, dataset.Size)
+	}
+	return nil, nil
+}
+
+// xlsxHeader reads the first row of the first sheet, the one pandas reads by default
+func xlsxHeader(readerAt io.ReaderAt, size int64) ([]string, error) {
+	archive, err := zip.NewReader(readerAt, size)
+	if err != nil {
+		return nil, err
+	}
+
+	var workbook struct {
+		Sheets []struct {
+			RelationID string `xml:"http://schemas.openxmlformats.org/officeDocument/2006/relationships id,attr"`
+		} `xml:"sheets>sheet"`
+	}
+	if err := decodeZipXML(archive, "xl/workbook.xml", &workbook); err != nil {
+		return nil, err
+	}
+	if len(workbook.Sheets) == 0 {
+		return nil, fmt.Errorf("workbook has no sheets")
+	}
+	var relationships struct {
+		Items []struct {
+			ID     string `xml:"Id,attr"`
+			Target string `xml:"Target,attr"`
+		} `xml:"Relationship"`
+	}
+	if err := decodeZipXML(archive, "xl/_rels/workbook.xml.rels", &relationships); err != nil {
+		return nil, err
+	}
+	sheetPath := ""
+	for _, item := range relationships.Items {
+		if item.ID == workbook.Sheets[0].RelationID {
+			sheetPath = item.Target
+		}
+	}
+	if strings.HasPrefix(sheetPath, "/") {
+		sheetPath = strings.TrimPrefix(sheetPath, "/")
+	} else {
+		sheetPath = path.Join("xl", sheetPath)
+	}
+
+	// the shared strings file is optional, workbooks with inline strings have none
+	var sharedStrings struct {
+		Items []struct {
+			Text string   `xml:"t"`
+			Runs []string `xml:"r>t"`
+		} `xml:"si"`
+	}
+	if err := decodeZipXML(archive, "xl/sharedStrings.xml", &sharedStrings); err != nil && err != errZipEntryNotFound {
+		return nil, err
+	}
+	shared := make([]string, len(sharedStrings.Items))
+	for i, item := range sharedStrings.Items {
+		shared[i] = item.Text + strings.Join(item.Runs, "")
+	}
+
+	sheet, err := openZipEntry(archive, sheetPath)
+	if err != nil {
+		return nil, err
+	}
+	defer sheet.Close()
+
+	// only the first row is decoded, the rest of the sheet is not read
+	decoder := xml.NewDecoder(sheet)
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return nil, err
+		}
+		start, ok := token.(xml.StartElement)
+		if !ok || start.Name.Local != "row" {
+			continue
+		}
+		var row struct {
+			Cells []struct {
+				Type   string `xml:"t,attr"`
+				Value  string `xml:"v"`
+				Inline string `xml:"is>t"`
+			} `xml:"c"`
+		}
+		if err := decoder.DecodeElement(&row, &start); err != nil {
+			return nil, err
+		}
+		header := make([]string, 0, len(row.Cells))
+		for _, cell := range row.Cells {
+			switch cell.Type {
+			case "s":
+				var index int
+				if _, err := fmt.Sscanf(cell.Value, "%d", &index); err != nil || index >= len(shared) {
+					return nil, fmt.Errorf("invalid shared string %q", cell.Value)
+				}
+				header = append(header, shared[index])
+			case "inlineStr":
+				header = append(header, cell.Inline)
+			default:
+				header = append(header, cell.Value)
+			}
+		}
+		return header, nil
+	}
+}
+
+var errZipEntryNotFound = fmt.Errorf("zip entry not found")
+
+func openZipEntry(archive *zip.Reader, name string) (io.ReadCloser, error) {
+	for _, file := range archive.File {
+		if file.Name == name {
+			return file.Open()
+		}
+	}
+	return nil, errZipEntryNotFound
+}
+
+func decodeZipXML(archive *zip.Reader, name string, v interface{}) error {
+	entry, err := openZipEntry(archive, name)
+	if err != nil {
+		return err
+	}
+	defer entry.Close()
+
+	data, err := io.ReadAll(entry)
+	if err != nil {
+		return err
+	}
+	return xml.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
@@ -0,0 +1,347 @@
+package main
+
+import (
+	"feklistova/python"
+	"archive/zip"
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func formRequest(values url.Values) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, "/api/shipment/progress", strings.NewReader(values.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return r
+}
+
+func lookupModelType(t *testing.T, name string) python.ModelType {
+	t.Helper()
+	modelType, ok := python.LookupModelType(name)
+	if !ok {
+		t.Fatalf("model type %s is not registered", name)
+	}
+	return modelType
+}
+
+func TestParseColumnSpec(t *testing.T) {
+	tests := []struct {
+		name      string
+		modelType string
+		target    string
+		form      url.Values
+		want      map[string]string
+		wantErr   string
+	}{
+		{
+			name:      "no roles",
+			modelType: "class",
+			target:    "label",
+			form:      url.Values{"ignore_columns": {" "}},
+			want:      nil,
+		},
+		{
+			name:      "roles of several columns",
+			modelType: "class",
+			target:    "label",
+			form: url.Values{
+				"id_columns":       {"user_id"},
+				"text_columns":     {"title, body,"},
+				"datetime_columns": {"created"},
+				"weight_column":    {"weight"},
+			},
+			want: map[string]string{
+				"user_id": "id",
+				"title":   "text",
+				"body":    "text",
+				"created": "datetime",
+				"weight":  "weight",
+			},
+		},
+		{
+			name:      "column repeated within one role",
+			modelType: "reg",
+			target:    "price",
+			form:      url.Values{"numeric_columns": {"area, area"}},
+			want:      map[string]string{"area": "numeric"},
+		},
+		{
+			name:      "duplicate column in two roles",
+			modelType: "class",
+			target:    "label",
+			form:      url.Values{"ignore_columns": {"city"}, "categorical_columns": {"city"}},
+			wantErr:   "column city has two roles: ignore and categorical",
+		},
+		{
+			name:      "target with a role",
+			modelType: "reg",
+			target:    "price",
+			form:      url.Values{"numeric_columns": {"area, price"}},
+			wantErr:   "target column price can not have a role",
+		},
+		{
+			name:      "role the model type does not accept",
+			modelType: "cluster",
+			form:      url.Values{"weight_column": {"weight"}},
+			wantErr:   "column role weight is not supported for model type cluster",
+		},
+		{
+			name:      "model type without roles",
+			modelType: "forecast",
+			target:    "sales",
+			form:      url.Values{"categorical_columns": {"store"}},
+			wantErr:   "column role categorical is not supported for model type forecast",
+		},
+		{
+			name:      "several weight columns",
+			modelType: "class",
+			target:    "label",
+			form:      url.Values{"weight_column": {"w1, w2"}},
+			wantErr:   "only one weight column is allowed",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spec, err := parseColumnSpec(formRequest(tt.form), lookupModelType(t, tt.modelType), tt.target)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("parseColumnSpec() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseColumnSpec() error = %v", err)
+			}
+			if !reflect.DeepEqual(spec, tt.want) {
+				t.Errorf("parseColumnSpec() = %v, want %v", spec, tt.want)
+			}
+		})
+	}
+}
+
+// memoryDataset is a dataset held in memory, it can be read at any offset like a stored file
+type memoryDataset struct {
+	*bytes.Reader
+}
+
+func (memoryDataset) Close() error {
+	return nil
+}
+
+func newDataset(name string, data []byte) *datasetSource {
+	return &datasetSource{
+		ReadCloser: memoryDataset{bytes.NewReader(data)},
+		Name:       name,
+		Extension:  fileExtension(name),
+		Size:       int64(len(data)),
+	}
+}
+
+// xlsxFile builds a workbook with the entries given by their path in the archive
+func xlsxFile(t *testing.T, entries map[string]string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+	for name, content := range entries {
+		w, err := archive.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := archive.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+const (
+	testWorkbook = `<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"
+    xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">
+  <sheets><sheet name="Data" sheetId="1" r:id="rId1"/><sheet name="Other" sheetId="2" r:id="rId2"/></sheets>
+</workbook>`
+	testSharedStrings = `<sst xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">
+  <si><t>city</t></si><si><r><t>pri</t></r><r><t>ce</t></r></si>
+</sst>`
+	testSheet = `<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>
+  <row r="1"><c r="A1" t="s"><v>0</v></c><c r="B1" t="inlineStr"><is><t>area</t></is></c><c r="C1"><v>2024</v></c><c r="D1" t="s"><v>1</v></c></row>
+  <row r="2"><c r="A2" t="s"><v>0</v></c><c r="B2"><v>50</v></c></row>
+</sheetData></worksheet>`
+	testOtherSheet = `<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>
+  <row r="1"><c r="A1" t="inlineStr"><is><t>other</t></is></c></row>
+</sheetData></worksheet>`
+)
+
+func testRelationships(sheetTarget string) string {
+	return `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+  <Relationship Id="rId2" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet2.xml"/>
+  <Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="` + sheetTarget + `"/>
+</Relationships>`
+}
+
+func TestDatasetHeader(t *testing.T) {
+	tests := []struct {
+		name    string
+		dataset func(t *testing.T) *datasetSource
+		want    []string
+		wantErr bool
+	}{
+		{
+			name: "csv",
+			dataset: func(t *testing.T) *datasetSource {
+				return newDataset("data.csv", []byte("city,area,price\nMoscow,50,100\n"))
+			},
+			want: []string{"city", "area", "price"},
+		},
+		{
+			name: "csv with a byte order mark and quoted names",
+			dataset: func(t *testing.T) *datasetSource {
+				return newDataset("data.csv", []byte("\ufeffcity,\"area, m2\",price\nMoscow,50,100\n"))
+			},
+			want: []string{"city", "area, m2", "price"},
+		},
+		{
+			name: "empty csv",
+			dataset: func(t *testing.T) *datasetSource {
+				return newDataset("data.csv", []byte{})
+			},
+			want: nil,
+		},
+		{
+			name: "xlsx with shared and inline strings",
+			dataset: func(t *testing.T) *datasetSource {
+				return newDataset("data.xlsx", xlsxFile(t, map[string]string{
+					"xl/workbook.xml":            testWorkbook,
+					"xl/_rels/workbook.xml.rels": testRelationships("worksheets/sheet1.xml"),
+					"xl/sharedStrings.xml":       testSharedStrings,
+					"xl/worksheets/sheet1.xml":   testSheet,
+					"xl/worksheets/sheet2.xml":   testOtherSheet,
+				}))
+			},
+			want: []string{"city", "area", "2024", "price"},
+		},
+		{
+			name: "xlsx with an absolute sheet path and without shared strings",
+			dataset: func(t *testing.T) *datasetSource {
+				return newDataset("data.xlsx", xlsxFile(t, map[string]string{
+					"xl/workbook.xml":            testWorkbook,
+					"xl/_rels/workbook.xml.rels": testRelationships("/xl/worksheets/sheet2.xml"),
+					"xl/worksheets/sheet2.xml":   testOtherSheet,
+				}))
+			},
+			want: []string{"other"},
+		},
+		{
+			name: "xlsx with a missing shared string",
+			dataset: func(t *testing.T) *datasetSource {
+				return newDataset("data.xlsx", xlsxFile(t, map[string]string{
+					"xl/workbook.xml":            testWorkbook,
+					"xl/_rels/workbook.xml.rels": testRelationships("worksheets/sheet1.xml"),
+					"xl/worksheets/sheet1.xml":   testSheet,
+				}))
+			},
+			wantErr: true,
+		},
+		{
+			name: "not a zip archive",
+			dataset: func(t *testing.T) *datasetSource {
+				return newDataset("data.xlsx", []byte("city,area\n"))
+			},
+			wantErr: true,
+		},
+		{
+			name: "format read by the training script",
+			dataset: func(t *testing.T) *datasetSource {
+				return newDataset("data.pkl", []byte("binary"))
+			},
+			want: nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header, err := datasetHeader(tt.dataset(t))
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("datasetHeader() = %v, want an error", header)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("datasetHeader() error = %v", err)
+			}
+			if !reflect.DeepEqual(header, tt.want) {
+				t.Errorf("datasetHeader() = %q, want %q", header, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateDatasetColumns(t *testing.T) {
+	csv := []byte("city,area,price\nMoscow,50,100\n")
+	tests := []struct {
+		name    string
+		dataset *datasetSource
+		columns []string
+		wantErr string
+	}{
+		{
+			name:    "known columns",
+			dataset: newDataset("data.csv", csv),
+			columns: []string{"price", "city", ""},
+		},
+		{
+			name:    "missing target",
+			dataset: newDataset("data.csv", csv),
+			columns: []string{"cost", "city"},
+			wantErr: "columns not found in the dataset: cost",
+		},
+		{
+			name:    "unknown columns",
+			dataset: newDataset("data.csv", csv),
+			columns: []string{"price", "rooms", "floor"},
+			wantErr: "columns not found in the dataset: rooms, floor",
+		},
+		{
+			name:    "column names are case sensitive",
+			dataset: newDataset("data.csv", csv),
+			columns: []string{"Price"},
+			wantErr: "columns not found in the dataset: Price",
+		},
+		{
+			name:    "unchecked format",
+			dataset: newDataset("data.xls", []byte("binary")),
+			columns: []string{"anything"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateDatasetColumns(tt.dataset, tt.columns)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("validateDatasetColumns() error = %v", err)
+				}
+				return
+			}
+			if err == nil || err.Error() != tt.wantErr {
+				t.Fatalf("validateDatasetColumns() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestColumnRoleValues(t *testing.T) {
+	values := columnRoleValues(map[string]string{"b": "text", "a": "text", "w": "weight"})
+	for _, field := range columnRoleFields {
+		if _, ok := values[field.Role]; !ok {
+			t.Errorf("columnRoleValues() has no value for role %s", field.Role)
+		}
+	}
+	if values["text"] != "a, b" || values["weight"] != "w" || values["id"] != "" {
+		t.Errorf("columnRoleValues() = %v", values)
+	}
+}
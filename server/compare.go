@@ -0,0 +1,268 @@
+package main
+
+import (
+	"feklistova/models"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/csrf"
+)
+
+const maxComparedRuns = 10
+
+// ComparedRun содержит параметры и результаты запуска для сравнения
+type ComparedRun struct {
+	models.Shipment
+	Dataset         string             `json:"dataset"`
+	DatasetSize     int64              `json:"dataset_size"`
+	DurationSeconds float64            `json:"duration_seconds"` // 0, если обучение не завершено
+	Metrics         map[string]float64 `json:"metrics"`
+}
+
+type Comparison struct {
+	Runs        []ComparedRun      `json:"runs"`
+	BestMetrics map[string]float64 `json:"best_metrics"`
+}
+
+// ComparisonRow представляет строку таблицы сравнения: один параметр или метрика для всех запусков
+type ComparisonRow struct {
+	Title  string
+	Metric bool
+	Cells  []ComparisonCell
+}
+
+type ComparisonCell struct {
+	Value string
+	Best  bool
+}
+
+type ComparisonPage struct {
+	CSRFField template.HTML
+	Runs      []ComparedRun
+	Rows      []ComparisonRow
+	CSVQuery  template.URL
+}
+
+func ComparisonHandlerTmpl(w http.ResponseWriter, r *http.Request) {
+	comparison, ok := loadComparison(w, r)
+	if !ok {
+		return
+	}
+
+	csvQuery := cloneValues(r.URL.Query())
+	csvQuery.Set("format", "csv")
+
+	renderTmpl(w, "web/compare.html", ComparisonPage{
+		CSRFField: csrf.TemplateField(r),
+		Runs:      comparison.Runs,
+		Rows:      comparisonRows(comparison),
+		CSVQuery:  template.URL(csvQuery.Encode()),
+	})
+}
+
+// ComparisonAPIHandler returns the comparison as JSON, or as a CSV file when format=csv is passed
+func ComparisonAPIHandler(w http.ResponseWriter, r *http.Request) {
+	comparison, ok := loadComparison(w, r)
+	if !ok {
+		return
+	}
+
+	if r.URL.Query().Get("format") != "csv" {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(comparison); err != nil {
+			log.Printf("Failed to send comparison: %v", err)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Disposition", "attachment; filename=comparison.csv")
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+
+	writer := csv.NewWriter(w)
+	header := []string{"field"}
+	for _, run := range comparison.Runs {
+		header = append(header, fmt.Sprintf("shipment_%d", run.ShipmentID))
+	}
+	writer.Write(header)
+	for _, row := range comparisonRows(comparison) {
+		record := []string{row.Title}
+		for _, cell := range row.Cells {
+			record = append(record, cell.Value)
+		}
+		writer.Write(record)
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		log.Printf("Failed to send comparison: %v", err)
+	}
+}
+
+// loadComparison collects the shipments passed as ?id= that belong to the logged in user.
+// On failure it writes the response itself.
+func loadComparison(w http.ResponseWriter, r *http.Request) (*Comparison, bool) {
+	if !IsAuthorized(r) {
+		http.Redirect(w, r, "/users/enter", http.StatusSeeOther)
+		return nil, false
+	}
+	userID := GetUserID(r)
+
+	values := r.URL.Query()["id"]
+	if len(values) < 2 || len(values) > maxComparedRuns {
+		http.Error(w, fmt.Sprintf("Select from 2 to %d shipments to compare", maxComparedRuns), http.StatusBadRequest)
+		return nil, false
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), time.Second*10)
+	defer cancel()
+
+	comparison := &Comparison{BestMetrics: make(map[string]float64)}
+	seen := make(map[int]bool)
+	for _, value := range values {
+		shipmentID, err := strconv.Atoi(value)
+		if err != nil {
+			http.Error(w, "Invalid shipment id", http.StatusBadRequest)
+			return nil, false
+		}
+		if seen[shipmentID] {
+			continue
+		}
+		seen[shipmentID] = true
+
+		shipment, err := repo.GetShipmentByID(ctx, shipmentID)
+		if err != nil || shipment.UserID != userID {
+			log.Printf("User %d requested unknown shipment %d for comparison: %v", userID, shipmentID, err)
+			http.Error(w, "Shipment not found", http.StatusNotFound)
+			return nil, false
+		}
+
+		run, err := comparedRun(ctx, shipment)
+		if err != nil {
+			log.Printf("Failed to load shipment %d for comparison: %v", shipmentID, err)
+			http.Error(w, "Error loading shipments", http.StatusInternalServerError)
+			return nil, false
+		}
+		comparison.Runs = append(comparison.Runs, *run)
+	}
+
+	for _, run := range comparison.Runs {
+		for name, value := range run.Metrics {
+			if metricInfo(name).Neutral {
+				continue
+			}
+			best, ok := comparison.BestMetrics[name]
+			if !ok || betterMetric(name, value, best) {
+				comparison.BestMetrics[name] = value
+			}
+		}
+	}
+	return comparison, true
+}
+
+func comparedRun(ctx context.Context, shipment *models.Shipment) (*ComparedRun, error) {
+	run := &ComparedRun{Shipment: *shipment}
+
+	datasets, err := repo.GetDownloadedFilesByShipmentID(ctx, shipment.ShipmentID)
+	if err != nil {
+		return nil, err
+	}
+	if len(datasets) > 0 {
+		run.Dataset = datasets[0].OriginalName
+		if run.Dataset == "" {
+			run.Dataset = filepath.Base(datasets[0].FilePath)
+		}
+		run.DatasetSize = datasets[0].Size
+	}
+
+	if shipment.FinishedAt != nil {
+		run.DurationSeconds = shipment.FinishedAt.Sub(shipment.Timestamp).Seconds()
+	}
+
+	run.Metrics, err = repo.GetMetricsByShipmentID(ctx, shipment.ShipmentID)
+	if err != nil {
+		return nil, err
+	}
+	return run, nil
+}
+
+// comparisonRows lays the comparison out as a table with a column for every run
+func comparisonRows(comparison *Comparison) []ComparisonRow {
+	field := func(title string, value func(run ComparedRun) string) ComparisonRow {
+		row := ComparisonRow{Title: title}
+		for _, run := range comparison.Runs {
+			row.Cells = append(row.Cells, ComparisonCell{Value: value(run)})
+		}
+		return row
+	}
+
+	rows := []ComparisonRow{
+		field("Проект", func(run ComparedRun) string { return run.ProjectName }),
+		field("Тип модели", func(run ComparedRun) string { return run.ModelType }),
+		field("Алгоритм", func(run ComparedRun) string { return run.Algorithm }),
+		field("Целевой столбец", func(run ComparedRun) string { return run.TargetColumn }),
+		field("Датасет", func(run ComparedRun) string { return run.Dataset }),
+		field("Размер датасета", func(run ComparedRun) string { return formatBytes(run.DatasetSize) }),
+		field("Статус", func(run ComparedRun) string { return run.Status }),
+		field("Длительность", func(run ComparedRun) string {
+			if run.FinishedAt == nil {
+				return ""
+			}
+			return (time.Duration(run.DurationSeconds) * time.Second).String()
+		}),
+	}
+
+	var hyperparameters, metrics []string
+	seenHyperparameters, seenMetrics := make(map[string]bool), make(map[string]bool)
+	for _, run := range comparison.Runs {
+		for name := range run.Metrics {
+			if !seenMetrics[name] {
+				seenMetrics[name] = true
+				metrics = append(metrics, name)
+			}
+		}
+		for name := range run.Hyperparameters {
+			if !seenHyperparameters[name] {
+				seenHyperparameters[name] = true
+				hyperparameters = append(hyperparameters, name)
+			}
+		}
+	}
+	sort.Strings(hyperparameters)
+	sort.Strings(metrics)
+
+	for _, name := range hyperparameters {
+		rows = append(rows, field("Гиперпараметр "+name, func(run ComparedRun) string {
+			value, ok := run.Hyperparameters[name]
+			if !ok {
+				return "по умолчанию"
+			}
+			return strconv.FormatFloat(value, 'f', -1, 64)
+		}))
+	}
+
+	for _, name := range metrics {
+		row := ComparisonRow{Title: name, Metric: true}
+		best, hasBest := comparison.BestMetrics[name]
+		for _, run := range comparison.Runs {
+			value, ok := run.Metrics[name]
+			if !ok {
+				row.Cells = append(row.Cells, ComparisonCell{})
+				continue
+			}
+			row.Cells = append(row.Cells, ComparisonCell{
+				Value: strconv.FormatFloat(value, 'f', 4, 64),
+				Best:  hasBest && value == best,
+			})
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
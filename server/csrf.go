@@ -0,0 +1,37 @@
+package main
+
+import (
+	"feklistova/config"
+	"log"
+	"net/http"
+
+	"github.com/gorilla/csrf"
+	"github.com/gorilla/mux"
+)
+
+func csrfMiddleware() mux.MiddlewareFunc {
+	protect := csrf.Protect(
+		csrfAuthKey,
+		csrf.Path("/"),
+		csrf.Secure(config.SecureCookies),
+		csrf.SameSite(csrf.SameSiteLaxMode),
+		csrf.ErrorHandler(http.HandlerFunc(csrfErrorHandler)),
+	)
+
+	return func(next http.Handler) http.Handler {
+		protected := protect(next)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// the server is reachable over plain HTTP, so the referer check must not require TLS
+			if r.TLS == nil {
+				r = csrf.PlaintextHTTPRequest(r)
+			}
+			protected.ServeHTTP(w, r)
+		})
+	}
+}
+
+func csrfErrorHandler(w http.ResponseWriter, r *http.Request) {
+	log.Printf("CSRF validation failed for %s %s: %v", r.Method, r.URL.Path, csrf.FailureReason(r))
+	recordAudit(r, currentActorID(r), auditCSRFFailure, r.Method+" "+r.URL.Path, outcomeDenied)
+	http.Error(w, "Недействительный CSRF токен, обновите страницу и повторите попытку", http.StatusForbidden)
+}
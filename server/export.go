@@ -0,0 +1,483 @@
+package main
+
+import (
+	"feklistova/config"
+	"feklistova/models"
+	"archive/zip"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/gorilla/csrf"
+	"github.com/gorilla/mux"
+)
+
+type DataExportPage struct {
+	CSRFField template.HTML
+	Export    *models.DataExport
+	Expired   bool
+}
+
+type exportedProfile struct {
+	UserID      int       `json:"user_id"`
+	Username    string    `json:"username"`
+	Email       string    `json:"email"`
+	TOTPEnabled bool      `json:"totp_enabled"`
+	CreatedAt   time.Time `json:"created_at"`
+
+	Notifications models.NotificationPreferences `json:"notifications"`
+}
+
+type exportedModel struct {
+	File    string                       `json:"file"`
+	Metrics map[string]float64           `json:"metrics"`
+	Report  *models.ClassificationReport `json:"classification_report,omitempty"`
+}
+
+type exportedShipment struct {
+	models.Shipment
+	Datasets []string        `json:"datasets"`
+	Models   []exportedModel `json:"models"`
+}
+
+type exportedSchedule struct {
+	models.Schedule
+	Dataset string `json:"dataset,omitempty"` // empty when the schedule trains on the dataset of its template shipment
+}
+
+type exportedRegisteredModel struct {
+	models.RegisteredModel
+	Versions    []models.ModelVersion         `json:"versions"`
+	Transitions []models.ModelStageTransition `json:"stage_transitions"`
+}
+
+type exportedWebhook struct {
+	models.Webhook
+	Deliveries []models.WebhookDelivery `json:"deliveries"`
+}
+
+func DataExportHandlerTmpl(w http.ResponseWriter, r *http.Request) {
+	if !IsAuthorized(r) {
+		http.Redirect(w, r, "/users/enter", http.StatusSeeOther)
+		return
+	}
+	userID := GetUserID(r)
+
+	ctx, cancel := context.WithTimeout(r.Context(), time.Second*5)
+	defer cancel()
+
+	export, err := repo.GetLatestDataExport(ctx, userID)
+	if err != nil {
+		log.Printf("Failed to load data export of user %d: %v", userID, err)
+		http.Error(w, "Error loading data export", http.StatusInternalServerError)
+		return
+	}
+
+	renderTmpl(w, "web/export.html", DataExportPage{
+		CSRFField: csrf.TemplateField(r),
+		Export:    export,
+		Expired:   export != nil && time.Now().After(export.ExpiresAt),
+	})
+}
+
+func DataExportHandler(w http.ResponseWriter, r *http.Request) {
+	if !IsAuthorized(r) {
+		http.Redirect(w, r, "/users/enter", http.StatusSeeOther)
+		return
+	}
+	userID := GetUserID(r)
+
+	ctx, cancel := context.WithTimeout(r.Context(), time.Second*5)
+	defer cancel()
+
+	latest, err := repo.GetLatestDataExport(ctx, userID)
+	if err != nil {
+		log.Printf("Failed to load data export of user %d: %v", userID, err)
+		http.Error(w, "Error creating data export", http.StatusInternalServerError)
+		return
+	}
+	// an export left pending by a restart is failed by failStaleDataExports after the timeout and stops blocking new ones
+	if latest != nil && latest.Status == "pending" {
+		http.Redirect(w, r, "/profile/export", http.StatusSeeOther)
+		return
+	}
+
+	token := make([]byte, 32)
+	if _, err := rand.Read(token); err != nil {
+		log.Printf("Failed to generate data export token: %v", err)
+		http.Error(w, "Error creating data export", http.StatusInternalServerError)
+		return
+	}
+
+	export := &models.DataExport{
+		UserID:    userID,
+		Token:     hex.EncodeToString(token),
+		Status:    "pending",
+		CreatedAt: time.Now(),
+		ExpiresAt: time.Now().Add(config.DataExportTTL),
+	}
+	if err := repo.CreateDataExport(ctx, export); err != nil {
+		log.Printf("Failed to create data export for user %d: %v", userID, err)
+		http.Error(w, "Error creating data export", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("User %d requested data export %d", userID, export.ExportID)
+	recordAudit(r, userID, auditDataExport, fmt.Sprintf("data_export:%d", export.ExportID), outcomeSuccess)
+
+	go runDataExport(export)
+
+	http.Redirect(w, r, "/profile/export", http.StatusSeeOther)
+}
+
+func DataExportDownloadHandler(w http.ResponseWriter, r *http.Request) {
+	if !IsAuthorized(r) {
+		http.Redirect(w, r, "/users/enter", http.StatusSeeOther)
+		return
+	}
+	userID := GetUserID(r)
+	token := mux.Vars(r)["token"]
+
+	ctx, cancel := context.WithTimeout(r.Context(), time.Second*5)
+	defer cancel()
+
+	export, err := repo.GetDataExportByToken(ctx, token)
+	if err != nil || export.UserID != userID {
+		log.Printf("User %d requested unknown data export: %v", userID, err)
+		recordAudit(r, userID, auditDataExportDownload, "data_export:unknown", outcomeDenied)
+		http.Error(w, "File not found", http.StatusNotFound)
+		return
+	}
+	if time.Now().After(export.ExpiresAt) {
+		http.Error(w, "Download link has expired", http.StatusGone)
+		return
+	}
+	if export.Status != "ready" {
+		http.Error(w, "Data export is not ready", http.StatusNotFound)
+		return
+	}
+
+	file, err := os.Open(export.FilePath)
+	if err != nil {
+		log.Printf("Failed to open data export %d: %v", export.ExportID, err)
+		http.Error(w, "Failed to open file", http.StatusInternalServerError)
+		return
+	}
+	defer file.Close()
+
+	recordAudit(r, userID, auditDataExportDownload, fmt.Sprintf("data_export:%d", export.ExportID), outcomeSuccess)
+	w.Header().Set("Content-Disposition", "attachment; filename=export.zip")
+	w.Header().Set("Content-Type", "application/zip")
+
+	_, err = io.Copy(w, file)
+	if err != nil {
+		log.Printf("Failed to send data export %d: %v", export.ExportID, err)
+		http.Error(w, "Failed to send file", http.StatusInternalServerError)
+		return
+	}
+}
+
+// runDataExport builds the archive in the background and records the result
+func runDataExport(export *models.DataExport) {
+	ctx, cancel := context.WithTimeout(context.Background(), config.DataExportTimeout)
+	defer cancel()
+
+	fileName := fmt.Sprintf("export_%d.zip", export.ExportID)
+	file, err := fileRepo.CreateExportFile(fileName)
+	if err == nil {
+		// the path is saved before the build, so a partial archive of an interrupted build can be deleted
+		export.FilePath = file.Name()
+		if err := repo.UpdateDataExport(ctx, export); err != nil {
+			log.Printf("Failed to update data export %d: %v", export.ExportID, err)
+		}
+		err = buildDataExport(ctx, export.UserID, file)
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+	}
+
+	if err != nil {
+		log.Printf("Data export %d failed: %v", export.ExportID, err)
+		export.Status = "failed"
+		if export.FilePath != "" {
+			if err := fileRepo.DeleteFileByPath(export.FilePath); err != nil {
+				log.Printf("Failed to delete data export file %s: %v", export.FilePath, err)
+			}
+			export.FilePath = ""
+		}
+	} else {
+		log.Printf("Data export %d is ready: %s", export.ExportID, export.FilePath)
+		export.Status = "ready"
+		// the link lives for the TTL from the moment the archive can be downloaded
+		export.ExpiresAt = time.Now().Add(config.DataExportTTL)
+	}
+
+	ctxFinal, cancelFinal := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancelFinal()
+
+	if err := repo.UpdateDataExport(ctxFinal, export); err != nil {
+		log.Printf("Failed to update data export %d: %v", export.ExportID, err)
+	}
+}
+
+// buildDataExport writes a zip archive with the profile, projects, shipments, metrics, datasets, models,
+// schedules, registered models, webhooks and audit events of the user
+func buildDataExport(ctx context.Context, userID int, w io.Writer) error {
+	user, err := repo.GetUserByID(ctx, userID)
+	if err != nil {
+		return fmt.Errorf("failed to load user: %v", err)
+	}
+
+	projects, err := repo.GetProjectsByUserID(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	shipments, err := repo.GetShipmentsByUserID(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	notifications, err := repo.GetNotificationPreferences(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	archive := zip.NewWriter(w)
+
+	profile := exportedProfile{
+		UserID:        user.ID,
+		Username:      user.Username,
+		Email:         user.Email,
+		TOTPEnabled:   user.TOTPEnabled,
+		CreatedAt:     user.CreatedAt,
+		Notifications: *notifications,
+	}
+	if err := writeJSONToZip(archive, "profile.json", profile); err != nil {
+		return err
+	}
+	if projects == nil {
+		projects = []models.Project{}
+	}
+	if err := writeJSONToZip(archive, "projects.json", projects); err != nil {
+		return err
+	}
+
+	exported := make([]exportedShipment, 0, len(shipments))
+	for _, shipment := range shipments {
+		item := exportedShipment{Shipment: shipment, Datasets: []string{}, Models: []exportedModel{}}
+
+		datasets, err := repo.GetDownloadedFilesByShipmentID(ctx, shipment.ShipmentID)
+		if err != nil {
+			return err
+		}
+		for _, dataset := range datasets {
+			name := fmt.Sprintf("datasets/%d/%s", shipment.ShipmentID, filepath.Base(dataset.FilePath))
+			if err := copyFileToZip(archive, name, dataset.FilePath); err != nil {
+				return err
+			}
+			item.Datasets = append(item.Datasets, name)
+		}
+
+		modelFiles, err := repo.GetUploadedFilesByShipmentID(ctx, shipment.ShipmentID)
+		if err != nil {
+			return err
+		}
+		for _, modelFile := range modelFiles {
+			metrics, err := repo.GetMetricsByFileID(ctx, modelFile.FileID)
+			if err != nil {
+				return err
+			}
+			report, err := repo.GetClassificationReport(ctx, modelFile.FileID)
+			if err != nil {
+				return err
+			}
+			name := fmt.Sprintf("models/%d/model_%d.joblib", shipment.ShipmentID, modelFile.FileID)
+			if err := copyFileToZip(archive, name, modelFile.FilePath); err != nil {
+				return err
+			}
+			item.Models = append(item.Models, exportedModel{File: name, Metrics: metrics, Report: report})
+		}
+
+		exported = append(exported, item)
+	}
+	if err := writeJSONToZip(archive, "shipments.json", exported); err != nil {
+		return err
+	}
+
+	if err := exportSchedules(ctx, archive, userID); err != nil {
+		return err
+	}
+	if err := exportRegistry(ctx, archive, userID); err != nil {
+		return err
+	}
+	if err := exportWebhooks(ctx, archive, userID); err != nil {
+		return err
+	}
+
+	// only the events the user performed, the journal may hold foreign events targeting the user's objects
+	events, err := repo.GetAuditEvents(ctx, models.AuditFilter{ActorID: userID})
+	if err != nil {
+		return err
+	}
+	if events == nil {
+		events = []models.AuditEvent{}
+	}
+	if err := writeJSONToZip(archive, "audit.json", events); err != nil {
+		return err
+	}
+
+	return archive.Close()
+}
+
+// exportSchedules adds the user's schedules with their own datasets to the archive
+func exportSchedules(ctx context.Context, archive *zip.Writer, userID int) error {
+	schedules, err := repo.GetSchedulesByUserID(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	exported := make([]exportedSchedule, 0, len(schedules))
+	for _, schedule := range schedules {
+		item := exportedSchedule{Schedule: schedule}
+		if schedule.DatasetPath != "" {
+			item.Dataset = fmt.Sprintf("schedules/%d/%s", schedule.ScheduleID, filepath.Base(schedule.DatasetPath))
+			if err := copyFileToZip(archive, item.Dataset, schedule.DatasetPath); err != nil {
+				return err
+			}
+		}
+		exported = append(exported, item)
+	}
+	return writeJSONToZip(archive, "schedules.json", exported)
+}
+
+// exportRegistry adds the user's registered models with their versions and stage history to the archive
+func exportRegistry(ctx context.Context, archive *zip.Writer, userID int) error {
+	registered, err := repo.GetRegisteredModelsByUserID(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	exported := make([]exportedRegisteredModel, 0, len(registered))
+	for _, model := range registered {
+		item := exportedRegisteredModel{RegisteredModel: model}
+		if item.Versions, err = repo.GetModelVersionsByModelID(ctx, model.ModelID); err != nil {
+			return err
+		}
+		if item.Transitions, err = repo.GetModelStageTransitions(ctx, model.ModelID); err != nil {
+			return err
+		}
+		if item.Versions == nil {
+			item.Versions = []models.ModelVersion{}
+		}
+		if item.Transitions == nil {
+			item.Transitions = []models.ModelStageTransition{}
+		}
+		exported = append(exported, item)
+	}
+	return writeJSONToZip(archive, "registry.json", exported)
+}
+
+// exportWebhooks adds the user's webhooks with the log of their deliveries to the archive, signing secrets are left out
+func exportWebhooks(ctx context.Context, archive *zip.Writer, userID int) error {
+	webhooks, err := repo.GetWebhooksByUserID(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	exported := make([]exportedWebhook, 0, len(webhooks))
+	for _, webhook := range webhooks {
+		item := exportedWebhook{Webhook: webhook}
+		if item.Deliveries, err = repo.GetWebhookDeliveriesByWebhookID(ctx, webhook.WebhookID); err != nil {
+			return err
+		}
+		if item.Deliveries == nil {
+			item.Deliveries = []models.WebhookDelivery{}
+		}
+		exported = append(exported, item)
+	}
+	return writeJSONToZip(archive, "webhooks.json", exported)
+}
+
+func writeJSONToZip(archive *zip.Writer, name string, data interface{}) error {
+	w, err := archive.Create(name)
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(data)
+}
+
+// copyFileToZip adds a physical file to the archive, missing files are skipped
+func copyFileToZip(archive *zip.Writer, name, filePath string) error {
+	file, err := os.Open(filePath)
+	if os.IsNotExist(err) {
+		log.Printf("File %s is missing, skipped in data export", filePath)
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	w, err := archive.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, file)
+	return err
+}
+
+// cleanupDataExports deletes archives whose download link has expired
+func cleanupDataExports(ctx context.Context) {
+	exports, err := repo.GetExpiredDataExports(ctx, time.Now())
+	if err != nil {
+		log.Printf("Failed to get expired data exports: %v", err)
+		return
+	}
+	for _, export := range exports {
+		if export.FilePath != "" {
+			if err := fileRepo.DeleteFileByPath(export.FilePath); err != nil {
+				log.Printf("Failed to delete data export file %s: %v", export.FilePath, err)
+				continue
+			}
+		}
+		if err := repo.DeleteDataExport(ctx, export.ExportID); err != nil {
+			log.Printf("Failed to delete data export %d: %v", export.ExportID, err)
+		}
+	}
+}
+
+// failStaleDataExports fails the exports left pending since before the moment by a restart or a stuck build
+// and deletes their partial archives, so the users can request a new export
+func failStaleDataExports(ctx context.Context, createdBefore time.Time) {
+	exports, err := repo.GetStaleDataExports(ctx, createdBefore)
+	if err != nil {
+		log.Printf("Failed to get stale data exports: %v", err)
+		return
+	}
+	for i := range exports {
+		export := &exports[i]
+		if export.FilePath != "" {
+			if err := fileRepo.DeleteFileByPath(export.FilePath); err != nil {
+				log.Printf("Failed to delete data export file %s: %v", export.FilePath, err)
+				continue
+			}
+		}
+		export.Status = "failed"
+		export.FilePath = ""
+		if err := repo.UpdateDataExport(ctx, export); err != nil {
+			log.Printf("Failed to update data export %d: %v", export.ExportID, err)
+			continue
+		}
+		log.Printf("Data export %d was interrupted and is marked as failed", export.ExportID)
+	}
+}
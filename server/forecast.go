@@ -0,0 +1,37 @@
+package main
+
+import (
+	"feklistova/config"
+	"feklistova/models"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// parseForecastSettings reads the time column, the optional series column and the horizon of a forecast shipment
+func parseForecastSettings(r *http.Request, targetColumn string) (*models.ForecastSettings, error) {
+	settings := &models.ForecastSettings{
+		TimeColumn:   strings.TrimSpace(r.FormValue("time_column")),
+		SeriesColumn: strings.TrimSpace(r.FormValue("series_column")),
+	}
+	if settings.TimeColumn == "" {
+		return nil, fmt.Errorf("time column must not be empty")
+	}
+	if settings.TimeColumn == targetColumn || settings.SeriesColumn == targetColumn {
+		return nil, fmt.Errorf("time and series columns must differ from the target column")
+	}
+	if settings.SeriesColumn == settings.TimeColumn {
+		return nil, fmt.Errorf("series column must differ from the time column")
+	}
+
+	horizon, err := strconv.Atoi(strings.TrimSpace(r.FormValue("horizon")))
+	if err != nil {
+		return nil, fmt.Errorf("horizon must be an integer")
+	}
+	if horizon < 1 || horizon > config.MaxForecastHorizon {
+		return nil, fmt.Errorf("horizon must be between 1 and %d", config.MaxForecastHorizon)
+	}
+	settings.Horizon = horizon
+	return settings, nil
+}
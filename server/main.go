@@ -1,13 +1,17 @@
 package main
 
 import (
+	"feklistova/config"
 	_ "feklistova/docs"
 	"feklistova/filestorage"
+	"feklistova/mailer"
 	"feklistova/python"
+	"feklistova/ratelimit"
 	"feklistova/repository"
 	"context"
 	"database/sql"
 	"log"
+	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
@@ -15,10 +19,14 @@ import (
 	"github.com/gorilla/sessions"
 )
 
-var store = sessions.NewCookieStore([]byte("secret"))
+var store *sessions.CookieStore
 var repo repository.Repository
 var fileRepo filestorage.FileStorage
 var pyModel python.PyModel
+var emailSender mailer.Mailer
+var loginLimiter, registerLimiter ratelimit.Limiter
+var shipmentLimiter ratelimit.Bucket
+var csrfAuthKey []byte
 
 //	@title			Social Network API
 //	@version		1.0
@@ -34,10 +42,60 @@ func main() {
 	log.Println("Starting")
 
 	log.Println("Setting up file storage")
-	if err := fileRepo.NewFileStorage("/root/uploads", "/root/downloads"); err != nil {
+	if err := fileRepo.NewFileStorage("/root/uploads", "/root/downloads", "/root/exports"); err != nil {
 		panic(err)
 	}
 
+	log.Println("Loading session key")
+	sessionKey, err := loadSecretKey("session key", config.SessionAuthKeyEnv, config.SessionAuthKeyFile)
+	if err != nil {
+		panic(err)
+	}
+	store = sessions.NewCookieStore(sessionKey)
+	store.Options = &sessions.Options{
+		Path:     "/",
+		MaxAge:   86400 * 7,
+		HttpOnly: true,
+		Secure:   config.SecureCookies,
+		SameSite: http.SameSiteLaxMode,
+	}
+
+	log.Println("Loading CSRF key")
+	csrfAuthKey, err = loadSecretKey("CSRF key", config.CSRFAuthKeyEnv, config.CSRFAuthKeyFile)
+	if err != nil {
+		panic(err)
+	}
+
+	log.Println("Setting up rate limiters")
+	loginLimiter.NewLimiter(ratelimit.Config{
+		MaxAttempts: config.LoginMaxAttempts,
+		BaseDelay:   config.LoginBaseDelay,
+		MaxDelay:    config.LoginMaxDelay,
+		Lockout:     config.LoginLockout,
+		Window:      config.LoginWindow,
+	})
+	registerLimiter.NewLimiter(ratelimit.Config{
+		MaxAttempts: config.RegisterMaxAttempts,
+		BaseDelay:   config.RegisterBaseDelay,
+		MaxDelay:    config.RegisterMaxDelay,
+		Lockout:     config.RegisterLockout,
+		Window:      config.RegisterWindow,
+	})
+	shipmentLimiter.NewBucket(ratelimit.BucketConfig{
+		Burst:    config.ShipmentBurst,
+		Interval: config.ShipmentInterval,
+		Window:   config.ShipmentWindow,
+	})
+
+	log.Println("Setting up mailer")
+	emailSender = mailer.New(mailer.Config{
+		Host:     config.SMTPHost,
+		Port:     config.SMTPPort,
+		Username: config.SMTPUsername,
+		Password: config.SMTPPassword,
+		From:     config.MailFrom,
+	})
+
 	log.Println("Opening database connection")
 	repo.NewRepository()
 	defer func(db *sql.DB) {
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+)
+
+// MetricInfo описывает, как показывать метрику пользователю
+type MetricInfo struct {
+	Title          string // название на странице результатов
+	HigherIsBetter bool   // лучшим считается наибольшее значение
+	Neutral        bool   // значение описывает результат, а не качество, лучшее не выделяется
+	Format         string // формат значения для fmt
+}
+
+// Метрики, которые печатают скрипты обучения, в порядке отображения.
+// Неизвестные метрики показываются после них под собственным именем.
+var metricCatalog = []struct {
+	Name string
+	MetricInfo
+}{
+	{"Accuracy", MetricInfo{Title: "Accuracy", HigherIsBetter: true, Format: "%.4f"}},
+	{"Precision", MetricInfo{Title: "Precision", HigherIsBetter: true, Format: "%.4f"}},
+	{"Recall", MetricInfo{Title: "Recall", HigherIsBetter: true, Format: "%.4f"}},
+	{"F1-score", MetricInfo{Title: "F1 Score", HigherIsBetter: true, Format: "%.4f"}},
+	{"Balanced Accuracy", MetricInfo{Title: "Balanced Accuracy", HigherIsBetter: true, Format: "%.4f"}},
+	{"ROC AUC", MetricInfo{Title: "ROC AUC", HigherIsBetter: true, Format: "%.4f"}},
+	{"Log Loss", MetricInfo{Title: "Log Loss", Format: "%.4f"}},
+	{"R2 Score", MetricInfo{Title: "R2", HigherIsBetter: true, Format: "%.4f"}},
+	{"RMSE", MetricInfo{Title: "RMSE", Format: "%.4f"}},
+	{"MAE", MetricInfo{Title: "MAE", Format: "%.4f"}},
+	{"MSE", MetricInfo{Title: "MSE", Format: "%.4f"}},
+	{"Silhouette", MetricInfo{Title: "Коэффициент силуэта", HigherIsBetter: true, Format: "%.4f"}},
+	{"Davies-Bouldin", MetricInfo{Title: "Индекс Дэвиса-Болдина", Format: "%.4f"}},
+	{"Clusters", MetricInfo{Title: "Число кластеров", Neutral: true, Format: "%.0f"}},
+	{"Noise Share", MetricInfo{Title: "Доля шума", Format: "%.4f"}},
+	{"MASE", MetricInfo{Title: "MASE", Format: "%.4f"}},
+	{"sMAPE", MetricInfo{Title: "sMAPE, %", Format: "%.2f"}},
+	{"MAPE", MetricInfo{Title: "MAPE, %", Format: "%.2f"}},
+	{"Anomalies", MetricInfo{Title: "Найдено аномалий", Neutral: true, Format: "%.0f"}},
+	{"Anomaly Share", MetricInfo{Title: "Доля аномалий", Neutral: true, Format: "%.4f"}},
+	{"Average Precision", MetricInfo{Title: "Average Precision", HigherIsBetter: true, Format: "%.4f"}},
+}
+
+var metricOrder = make(map[string]int)
+
+func init() {
+	for i, metric := range metricCatalog {
+		metricOrder[metric.Name] = i
+	}
+}
+
+// ResultMetric is a metric of a trained model prepared for the result page and API
+type ResultMetric struct {
+	Name           string   `json:"name"`
+	Title          string   `json:"title"`
+	Value          float64  `json:"value"`
+	Std            *float64 `json:"std,omitempty"` // стандартное отклонение по фолдам кросс-валидации
+	Display        string   `json:"display"`
+	HigherIsBetter bool     `json:"higher_is_better"`
+	Neutral        bool     `json:"neutral,omitempty"`
+}
+
+// metricInfo returns the metadata of the metric, unknown metrics are shown as is with higher values being better
+func metricInfo(name string) MetricInfo {
+	if i, ok := metricOrder[name]; ok {
+		return metricCatalog[i].MetricInfo
+	}
+	return MetricInfo{Title: name, HigherIsBetter: true, Format: "%.4f"}
+}
+
+// betterMetric reports whether value is better than best for the metric, values of neutral metrics never are
+func betterMetric(name string, value, best float64) bool {
+	info := metricInfo(name)
+	if info.Neutral {
+		return false
+	}
+	if info.HigherIsBetter {
+		return value > best
+	}
+	return value < best
+}
+
+// sortMetricNames orders metric names as in metricCatalog, unknown metrics go last alphabetically
+func sortMetricNames(names []string) {
+	sort.Slice(names, func(i, j int) bool {
+		oi, knownI := metricOrder[names[i]]
+		oj, knownJ := metricOrder[names[j]]
+		if knownI != knownJ {
+			return knownI
+		}
+		if knownI {
+			return oi < oj
+		}
+		return names[i] < names[j]
+	})
+}
+
+// resultMetrics prepares every metric of the model for display, cross-validated metrics are shown as mean ± std
+func resultMetrics(metrics, std map[string]float64) []ResultMetric {
+	names := make([]string, 0, len(metrics))
+	for name := range metrics {
+		names = append(names, name)
+	}
+	sortMetricNames(names)
+
+	result := make([]ResultMetric, 0, len(names))
+	for _, name := range names {
+		info := metricInfo(name)
+		metric := ResultMetric{
+			Name:           name,
+			Title:          info.Title,
+			Value:          metrics[name],
+			Display:        fmt.Sprintf(info.Format, metrics[name]),
+			HigherIsBetter: info.HigherIsBetter,
+			Neutral:        info.Neutral,
+		}
+		if value, ok := std[name]; ok {
+			metric.Std = &value
+			metric.Display += " ± " + fmt.Sprintf(info.Format, value)
+		}
+		result = append(result, metric)
+	}
+	return result
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"feklistova/config"
+	"feklistova/mailer"
+	"feklistova/models"
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+var notificationSubjects = map[string]string{
+	"finished": "Обучение модели завершено",
+	"failed":   "Ошибка обучения модели",
+	"denied":   "Запуск отклонен",
+}
+
+// NotificationPreferencesHandler saves which shipment results the user is emailed about
+func NotificationPreferencesHandler(w http.ResponseWriter, r *http.Request) {
+	if !IsAuthorized(r) {
+		http.Redirect(w, r, "/users/enter", http.StatusSeeOther)
+		return
+	}
+	userID := GetUserID(r)
+
+	if err := r.ParseMultipartForm(10 << 20); err != nil {
+		log.Println(err)
+		http.Error(w, "Error parsing form data", http.StatusBadRequest)
+		return
+	}
+	prefs := &models.NotificationPreferences{
+		UserID:   userID,
+		Finished: r.FormValue("notify_finished") != "",
+		Failed:   r.FormValue("notify_failed") != "",
+		Denied:   r.FormValue("notify_denied") != "",
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), time.Second*5)
+	defer cancel()
+
+	if err := repo.SaveNotificationPreferences(ctx, prefs); err != nil {
+		log.Printf("Failed to save notification preferences of user %d: %v", userID, err)
+		http.Error(w, "Error saving notification preferences", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("User %d updated notification preferences", userID)
+
+	http.Redirect(w, r, "/profile/edit?updated=notifications", http.StatusSeeOther)
+}
+
+// notifyShipmentStatus emails the owner of the shipment about its result if they asked for it.
+// The email is prepared right away, while the metrics and files still exist, and sent in the background.
+func notifyShipmentStatus(ctx context.Context, shipment *models.Shipment) {
+	if _, ok := notificationSubjects[shipment.Status]; !ok {
+		return
+	}
+	prefs, err := repo.GetNotificationPreferences(ctx, shipment.UserID)
+	if err != nil {
+		log.Printf("Failed to load notification preferences of user %d: %v", shipment.UserID, err)
+		return
+	}
+	if !prefs.Wants(shipment.Status) {
+		return
+	}
+	user, err := repo.GetUserByID(ctx, shipment.UserID)
+	if err != nil {
+		log.Printf("Failed to load user %d for notification: %v", shipment.UserID, err)
+		return
+	}
+
+	var metrics map[string]float64
+	if shipment.Status == "finished" {
+		metrics, err = shipmentResultMetrics(ctx, shipment.ShipmentID)
+		if err != nil {
+			log.Printf("Failed to load metrics of shipment %d for notification: %v", shipment.ShipmentID, err)
+		}
+	}
+	msg := shipmentNotification(user.Email, shipment, metrics)
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), config.MailTimeout)
+		defer cancel()
+		if err := emailSender.Send(ctx, msg); err != nil {
+			log.Printf("Failed to notify user %d about shipment %d: %v", shipment.UserID, shipment.ShipmentID, err)
+			return
+		}
+		log.Printf("User %d notified about shipment %d %s", shipment.UserID, shipment.ShipmentID, shipment.Status)
+	}()
+}
+
+// shipmentResultMetrics returns the metrics of the trained model of the shipment
+func shipmentResultMetrics(ctx context.Context, shipmentID int) (map[string]float64, error) {
+	files, err := repo.GetUploadedFilesByShipmentID(ctx, shipmentID)
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("shipment %d has no model file", shipmentID)
+	}
+	return repo.GetMetricsByFileID(ctx, files[0].FileID)
+}
+
+func shipmentNotification(to string, shipment *models.Shipment, metrics map[string]float64) mailer.Message {
+	var body strings.Builder
+	fmt.Fprintf(&body, "Запуск №%d", shipment.ShipmentID)
+	if shipment.ProjectName != "" {
+		fmt.Fprintf(&body, " проекта «%s»", shipment.ProjectName)
+	}
+	fmt.Fprintf(&body, " (%s, %s) ", shipment.ModelType, shipment.Algorithm)
+	switch shipment.Status {
+	case "finished":
+		body.WriteString("успешно завершен.\n")
+	case "failed":
+		body.WriteString("завершился с ошибкой.\n")
+	case "denied":
+		body.WriteString("был отклонен.\n")
+	}
+
+	if len(metrics) > 0 {
+		body.WriteString("\nМетрики модели:\n")
+		for _, name := range headlineOrder(shipment.ModelType, metrics) {
+			fmt.Fprintf(&body, "  %s: %.4f\n", name, metrics[name])
+		}
+	}
+
+	// the result page exists only for trained models
+	if shipment.Status == "finished" {
+		fmt.Fprintf(&body, "\nРезультаты: %s/shipment/result/%d\n", config.BaseURL, shipment.ShipmentID)
+	} else {
+		fmt.Fprintf(&body, "\nПодробнее: %s/shipments/%d\n", config.BaseURL, shipment.ShipmentID)
+	}
+	fmt.Fprintf(&body, "\nНастроить уведомления можно в профиле: %s/profile/edit\n", config.BaseURL)
+
+	return mailer.Message{
+		To:      to,
+		Subject: fmt.Sprintf("%s: запуск №%d", notificationSubjects[shipment.Status], shipment.ShipmentID),
+		Body:    body.String(),
+	}
+}
+
+// headlineOrder sorts metric names alphabetically with the headline metric of the model type first
+func headlineOrder(modelType string, metrics map[string]float64) []string {
+	headline := headlineMetrics[modelType]
+	names := make([]string, 0, len(metrics))
+	for name := range metrics {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if (names[i] == headline) != (names[j] == headline) {
+			return names[i] == headline
+		}
+		return names[i] < names[j]
+	})
+	return names
+}
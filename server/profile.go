@@ -0,0 +1,231 @@
+package main
+
+import (
+	"feklistova/models"
+	"context"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const profilePageSize = 10
+
+// Метрика, которая показывается для запуска в списке профиля
+var headlineMetrics = map[string]string{
+	"class":    "F1-score",
+	"reg":      "R2 Score",
+	"cluster":  "Silhouette",
+	"forecast": "MASE",
+	"anomaly":  "Anomaly Share",
+}
+
+type ProfilePage struct {
+	CSRFField   template.HTML
+	Username    string
+	Email       string
+	Plan        string
+	Meters      []UsageMeter
+	MaxDataset  string // пустая строка, если ограничения нет
+	Shipments   *ShipmentList
+	ProjectsTab bool // открыть вкладку «Мои проекты»
+}
+
+// ShipmentList содержит одну страницу запусков пользователя
+type ShipmentList struct {
+	Shipments []ProfileShipment `json:"shipments"`
+	Total     int               `json:"total"`
+	Page      int               `json:"page"`
+	Pages     int               `json:"pages"`
+}
+
+// ProfileShipment описывает запуск в списке профиля вместе с его основной метрикой
+type ProfileShipment struct {
+	models.Shipment
+	MetricName  string `json:"metric_name"`
+	MetricValue string `json:"metric_value"` // пустая строка, если метрики нет
+}
+
+// ProfileHandler returns the profile of the logged in user and a page of their shipments as JSON
+func ProfileHandler(w http.ResponseWriter, r *http.Request) {
+	if !IsAuthorized(r) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	userID := GetUserID(r)
+
+	ctx, cancel := context.WithTimeout(r.Context(), time.Second*5)
+	defer cancel()
+
+	user, err := repo.GetUserByID(ctx, userID)
+	if err != nil {
+		log.Printf("Failed to load user %d: %v", userID, err)
+		http.Error(w, "Unable to identify user", http.StatusInternalServerError)
+		return
+	}
+	shipments, err := profileShipments(ctx, userID, profilePageNumber(r))
+	if err != nil {
+		log.Printf("Failed to load shipments of user %d: %v", userID, err)
+		http.Error(w, "Error loading profile", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(struct {
+		Username  string        `json:"username"`
+		Email     string        `json:"email"`
+		Plan      string        `json:"plan"`
+		Shipments *ShipmentList `json:"shipments"`
+	}{user.Username, user.Email, user.Plan, shipments})
+	if err != nil {
+		log.Printf("Failed to send profile of user %d: %v", userID, err)
+	}
+}
+
+// ShipmentRenameHandler moves the shipment to the project with the new name, the project is created when needed
+func ShipmentRenameHandler(w http.ResponseWriter, r *http.Request) {
+	shipment, ok := userShipment(w, r)
+	if !ok {
+		return
+	}
+
+	if err := r.ParseMultipartForm(10 << 20); err != nil {
+		log.Println(err)
+		http.Error(w, "Error parsing form data", http.StatusBadRequest)
+		return
+	}
+	name := strings.TrimSpace(r.FormValue("project_name"))
+	if name == "" {
+		http.Error(w, "Project name must not be empty", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), time.Second*5)
+	defer cancel()
+
+	project := &models.Project{
+		UserID:              shipment.UserID,
+		Name:                name,
+		DefaultModelType:    shipment.ModelType,
+		DefaultTargetColumn: shipment.TargetColumn,
+		CreatedAt:           time.Now(),
+	}
+	if err := repo.GetOrCreateProject(ctx, project); err != nil {
+		log.Printf("Failed to get project %s of user %d: %v", name, shipment.UserID, err)
+		http.Error(w, "Error renaming shipment", http.StatusInternalServerError)
+		return
+	}
+	if err := repo.MoveShipmentToProject(ctx, shipment.ShipmentID, project); err != nil {
+		log.Printf("Failed to move shipment %d to project %d: %v", shipment.ShipmentID, project.ProjectID, err)
+		http.Error(w, "Error renaming shipment", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("User %d moved shipment %d to project %d", shipment.UserID, shipment.ShipmentID, project.ProjectID)
+
+	http.Redirect(w, r, profileShipmentsURL(r.FormValue("page")), http.StatusSeeOther)
+}
+
+// ShipmentDeleteHandler moves a finished shipment to the trash, it is purged after config.TrashRetention.
+// Running shipments, unless stuck longer than config.StaleShipmentTimeout, and shipments registered in the model registry are kept.
+func ShipmentDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	shipment, ok := userShipment(w, r)
+	if !ok {
+		return
+	}
+	target := fmt.Sprintf("shipment:%d", shipment.ShipmentID)
+
+	if shipmentRunning(shipment) {
+		http.Error(w, "Shipment is still training", http.StatusConflict)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), time.Second*5)
+	defer cancel()
+
+	versions, err := repo.CountModelVersionsByShipmentID(ctx, shipment.ShipmentID)
+	if err != nil {
+		log.Printf("Failed to count model versions of shipment %d: %v", shipment.ShipmentID, err)
+		http.Error(w, "Error deleting shipment", http.StatusInternalServerError)
+		return
+	}
+	if versions > 0 {
+		recordAudit(r, shipment.UserID, auditShipmentDelete, target, outcomeDenied)
+		http.Error(w, "Shipment is registered in the model registry", http.StatusConflict)
+		return
+	}
+
+	if err := repo.TrashShipment(ctx, shipment.ShipmentID); err != nil {
+		log.Printf("Failed to move shipment %d to the trash: %v", shipment.ShipmentID, err)
+		http.Error(w, "Error deleting shipment", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("User %d moved shipment %d to the trash", shipment.UserID, shipment.ShipmentID)
+	recordAudit(r, shipment.UserID, auditShipmentDelete, target, outcomeSuccess)
+
+	http.Redirect(w, r, profileShipmentsURL(r.FormValue("page")), http.StatusSeeOther)
+}
+
+// profileShipments loads the page of the user's shipments with their headline metrics
+func profileShipments(ctx context.Context, userID, page int) (*ShipmentList, error) {
+	total, err := repo.CountShipmentsByUserID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	list := &ShipmentList{
+		Shipments: []ProfileShipment{},
+		Total:     total,
+		Pages:     max((total+profilePageSize-1)/profilePageSize, 1),
+	}
+	list.Page = min(page, list.Pages)
+
+	shipments, metrics, err := searchShipments(ctx, models.ShipmentFilter{
+		UserID: userID,
+		Limit:  profilePageSize,
+		Offset: (list.Page - 1) * profilePageSize,
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, shipment := range shipments {
+		item := ProfileShipment{Shipment: shipment, MetricName: headlineMetrics[shipment.ModelType]}
+		if value, ok := metrics[shipment.ShipmentID][item.MetricName]; ok {
+			item.MetricValue = strconv.FormatFloat(value, 'f', 4, 64)
+		}
+		list.Shipments = append(list.Shipments, item)
+	}
+	return list, nil
+}
+
+// profilePageNumber returns the requested page of the shipment list, the first page by default
+func profilePageNumber(r *http.Request) int {
+	page, err := strconv.Atoi(r.URL.Query().Get("page"))
+	if err != nil || page < 1 {
+		return 1
+	}
+	return page
+}
+
+// profileShipmentsURL returns the profile address with the shipment list opened on the page
+func profileShipmentsURL(page string) string {
+	if number, err := strconv.Atoi(page); err == nil && number > 1 {
+		return fmt.Sprintf("/profile?tab=projects&page=%d", number)
+	}
+	return "/profile?tab=projects"
+}
+
+// PrevPage returns the number of the previous page, 0 on the first page
+func (l *ShipmentList) PrevPage() int {
+	return l.Page - 1
+}
+
+// NextPage returns the number of the next page, 0 on the last page
+func (l *ShipmentList) NextPage() int {
+	if l.Page >= l.Pages {
+		return 0
+	}
+	return l.Page + 1
+}
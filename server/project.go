@@ -0,0 +1,390 @@
+package main
+
+import (
+	"feklistova/config"
+	"feklistova/models"
+	"feklistova/python"
+	"context"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/csrf"
+	"github.com/gorilla/mux"
+)
+
+type ProjectsPage struct {
+	CSRFField template.HTML
+	Projects  []models.Project
+}
+
+type ProjectPage struct {
+	CSRFField    template.HTML
+	Project      *models.Project
+	MetricNames  []string
+	Runs         []ProjectRun
+	Schedules    []models.Schedule
+	ScheduleRuns []models.ScheduleRun
+	Webhooks     []models.Webhook
+	Deliveries   []models.WebhookDelivery
+	Events       []string
+}
+
+// ProjectRun представляет строку таблицы запусков проекта
+type ProjectRun struct {
+	models.Shipment
+	Metrics []string // значения в порядке ProjectPage.MetricNames
+}
+
+// ModelFormPage содержит данные формы запуска обучения
+type ModelFormPage struct {
+	CSRFField    template.HTML
+	Projects     []models.Project
+	ProjectName  string
+	TargetColumn string
+	Algorithm    string
+	Algorithms   []AlgorithmOption
+	Forecast     models.ForecastSettings // поля формы прогнозирования
+	ColumnRoles  map[string]string       // столбцы каждой роли через запятую
+	WeightColumn bool                    // тип задачи принимает столбец с весами строк
+	// Validation и ValidationStrategies заполняются для типов задач с настраиваемой проверкой качества
+	Validation           models.ValidationSettings
+	ValidationStrategies []python.ValidationStrategy
+	// ParentID и ParentDataset заполняются при клонировании запуска
+	ParentID      int
+	ParentDataset string
+}
+
+// AlgorithmOption описывает алгоритм и его гиперпараметры в форме обучения
+type AlgorithmOption struct {
+	Title           string
+	Hyperparameters []HyperparameterField
+}
+
+type HyperparameterField struct {
+	python.Hyperparameter
+	Value string
+}
+
+func ProjectsHandlerTmpl(w http.ResponseWriter, r *http.Request) {
+	if !IsAuthorized(r) {
+		http.Redirect(w, r, "/users/enter", http.StatusSeeOther)
+		return
+	}
+	userID := GetUserID(r)
+
+	ctx, cancel := context.WithTimeout(r.Context(), time.Second*5)
+	defer cancel()
+
+	projects, err := repo.GetProjectsByUserID(ctx, userID)
+	if err != nil {
+		log.Printf("Failed to load projects of user %d: %v", userID, err)
+		http.Error(w, "Error loading projects", http.StatusInternalServerError)
+		return
+	}
+
+	renderTmpl(w, "web/projects.html", ProjectsPage{
+		CSRFField: csrf.TemplateField(r),
+		Projects:  projects,
+	})
+}
+
+func ProjectCreateHandler(w http.ResponseWriter, r *http.Request) {
+	if !IsAuthorized(r) {
+		http.Redirect(w, r, "/users/enter", http.StatusSeeOther)
+		return
+	}
+	userID := GetUserID(r)
+
+	project, ok := parseProjectForm(w, r)
+	if !ok {
+		return
+	}
+	project.UserID = userID
+	project.CreatedAt = time.Now()
+
+	ctx, cancel := context.WithTimeout(r.Context(), time.Second*5)
+	defer cancel()
+
+	if err := repo.CreateProject(ctx, project); err != nil {
+		log.Printf("Failed to create project for user %d: %v", userID, err)
+		http.Error(w, "Project with this name already exists", http.StatusConflict)
+		return
+	}
+	log.Printf("User %d created project %d", userID, project.ProjectID)
+
+	http.Redirect(w, r, fmt.Sprintf("/projects/%d", project.ProjectID), http.StatusSeeOther)
+}
+
+func ProjectHandlerTmpl(w http.ResponseWriter, r *http.Request) {
+	project, ok := userProject(w, r)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), time.Second*5)
+	defer cancel()
+
+	shipments, err := repo.GetShipmentsByProjectID(ctx, project.ProjectID)
+	if err != nil {
+		log.Printf("Failed to load runs of project %d: %v", project.ProjectID, err)
+		http.Error(w, "Error loading project", http.StatusInternalServerError)
+		return
+	}
+	metrics, err := repo.GetProjectRunMetrics(ctx, project.ProjectID)
+	if err != nil {
+		log.Printf("Failed to load metrics of project %d: %v", project.ProjectID, err)
+		http.Error(w, "Error loading project", http.StatusInternalServerError)
+		return
+	}
+
+	schedules, err := repo.GetSchedulesByProjectID(ctx, project.ProjectID)
+	if err != nil {
+		log.Printf("Failed to load schedules of project %d: %v", project.ProjectID, err)
+		http.Error(w, "Error loading project", http.StatusInternalServerError)
+		return
+	}
+	scheduleRuns, err := repo.GetScheduleRunsByProjectID(ctx, project.ProjectID, config.ScheduleHistoryLimit)
+	if err != nil {
+		log.Printf("Failed to load schedule runs of project %d: %v", project.ProjectID, err)
+		http.Error(w, "Error loading project", http.StatusInternalServerError)
+		return
+	}
+
+	webhooks, err := repo.GetWebhooksByProjectID(ctx, project.ProjectID)
+	if err != nil {
+		log.Printf("Failed to load webhooks of project %d: %v", project.ProjectID, err)
+		http.Error(w, "Error loading project", http.StatusInternalServerError)
+		return
+	}
+	deliveries, err := repo.GetWebhookDeliveriesByProjectID(ctx, project.ProjectID, config.WebhookHistoryLimit)
+	if err != nil {
+		log.Printf("Failed to load webhook deliveries of project %d: %v", project.ProjectID, err)
+		http.Error(w, "Error loading project", http.StatusInternalServerError)
+		return
+	}
+
+	page := ProjectPage{
+		CSRFField:    csrf.TemplateField(r),
+		Project:      project,
+		MetricNames:  metricNames(metrics),
+		Schedules:    schedules,
+		ScheduleRuns: scheduleRuns,
+		Webhooks:     webhooks,
+		Deliveries:   deliveries,
+		Events:       webhookEvents,
+	}
+	for _, shipment := range shipments {
+		run := ProjectRun{Shipment: shipment}
+		for _, name := range page.MetricNames {
+			value, ok := metrics[shipment.ShipmentID][name]
+			if ok {
+				run.Metrics = append(run.Metrics, strconv.FormatFloat(value, 'f', 4, 64))
+			} else {
+				run.Metrics = append(run.Metrics, "")
+			}
+		}
+		page.Runs = append(page.Runs, run)
+	}
+
+	renderTmpl(w, "web/project.html", page)
+}
+
+func ProjectUpdateHandler(w http.ResponseWriter, r *http.Request) {
+	project, ok := userProject(w, r)
+	if !ok {
+		return
+	}
+
+	updated, ok := parseProjectForm(w, r)
+	if !ok {
+		return
+	}
+	updated.ProjectID = project.ProjectID
+	updated.UserID = project.UserID
+
+	ctx, cancel := context.WithTimeout(r.Context(), time.Second*5)
+	defer cancel()
+
+	if err := repo.UpdateProject(ctx, updated); err != nil {
+		log.Printf("Failed to update project %d: %v", project.ProjectID, err)
+		http.Error(w, "Project with this name already exists", http.StatusConflict)
+		return
+	}
+	log.Printf("User %d updated project %d", project.UserID, project.ProjectID)
+
+	http.Redirect(w, r, fmt.Sprintf("/projects/%d", project.ProjectID), http.StatusSeeOther)
+}
+
+// userProject loads the project from the URL and checks that it belongs to the logged in user.
+// On failure it writes the response itself.
+func userProject(w http.ResponseWriter, r *http.Request) (*models.Project, bool) {
+	if !IsAuthorized(r) {
+		http.Redirect(w, r, "/users/enter", http.StatusSeeOther)
+		return nil, false
+	}
+	userID := GetUserID(r)
+
+	projectID, err := strconv.Atoi(mux.Vars(r)["project_id"])
+	if err != nil {
+		http.Error(w, "Invalid project_id", http.StatusBadRequest)
+		return nil, false
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), time.Second*5)
+	defer cancel()
+
+	project, err := repo.GetProjectByID(ctx, projectID)
+	if err != nil || project.UserID != userID {
+		log.Printf("User %d requested unknown project %d: %v", userID, projectID, err)
+		http.Error(w, "Project not found", http.StatusNotFound)
+		return nil, false
+	}
+	return project, true
+}
+
+func parseProjectForm(w http.ResponseWriter, r *http.Request) (*models.Project, bool) {
+	err := r.ParseMultipartForm(10 << 20)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Error parsing form data", http.StatusBadRequest)
+		return nil, false
+	}
+
+	project := &models.Project{
+		Name:                strings.TrimSpace(r.FormValue("name")),
+		Description:         strings.TrimSpace(r.FormValue("description")),
+		DefaultModelType:    r.FormValue("default_model_type"),
+		DefaultTargetColumn: strings.TrimSpace(r.FormValue("default_target_column")),
+	}
+	if project.Name == "" {
+		http.Error(w, "Project name must not be empty", http.StatusBadRequest)
+		return nil, false
+	}
+	if project.DefaultModelType != "" && !python.ValidModelType(project.DefaultModelType) {
+		http.Error(w, "Unknown model type", http.StatusBadRequest)
+		return nil, false
+	}
+	return project, true
+}
+
+// shipmentProject returns the project the new run belongs to: the project chosen in the form
+// or the user's project with the typed name, which is created if needed.
+// On failure it writes the response itself.
+func shipmentProject(ctx context.Context, w http.ResponseWriter, r *http.Request, userID int, modelType, targetColumn string) (*models.Project, bool) {
+	if value := r.FormValue("project_id"); value != "" {
+		projectID, err := strconv.Atoi(value)
+		if err != nil {
+			http.Error(w, "Invalid project_id", http.StatusBadRequest)
+			return nil, false
+		}
+		project, err := repo.GetProjectByID(ctx, projectID)
+		if err != nil || project.UserID != userID {
+			log.Printf("User %d requested unknown project %d: %v", userID, projectID, err)
+			http.Error(w, "Project not found", http.StatusNotFound)
+			return nil, false
+		}
+		return project, true
+	}
+
+	name := strings.TrimSpace(r.FormValue("project_name"))
+	if name == "" {
+		http.Error(w, "Project name must not be empty", http.StatusBadRequest)
+		return nil, false
+	}
+	project := &models.Project{
+		UserID:              userID,
+		Name:                name,
+		DefaultModelType:    modelType,
+		DefaultTargetColumn: targetColumn,
+		CreatedAt:           time.Now(),
+	}
+	if err := repo.GetOrCreateProject(ctx, project); err != nil {
+		log.Printf("Failed to get project %s of user %d: %v", name, userID, err)
+		http.Error(w, "Error creating shipment", http.StatusInternalServerError)
+		return nil, false
+	}
+	return project, true
+}
+
+// modelFormTmpl renders a training form, the project and target column are prefilled from ?project_id=
+func modelFormTmpl(w http.ResponseWriter, r *http.Request, template_file, modelType string) {
+	if !IsAuthorized(r) {
+		http.Redirect(w, r, "/users/enter", http.StatusSeeOther)
+		return
+	}
+	userID := GetUserID(r)
+
+	ctx, cancel := context.WithTimeout(r.Context(), time.Second*5)
+	defer cancel()
+
+	projects, err := repo.GetProjectsByUserID(ctx, userID)
+	if err != nil {
+		log.Printf("Failed to load projects of user %d: %v", userID, err)
+		http.Error(w, "Error loading projects", http.StatusInternalServerError)
+		return
+	}
+
+	page := ModelFormPage{
+		CSRFField:   csrf.TemplateField(r),
+		Projects:    projects,
+		Algorithms:  algorithmOptions(modelType, "", nil),
+		Forecast:    models.ForecastSettings{Horizon: 1},
+		ColumnRoles: columnRoleValues(nil),
+		Validation:  validationFormValues(nil),
+	}
+	if description, ok := python.LookupModelType(modelType); ok {
+		page.ValidationStrategies = description.ValidationStrategies()
+		page.WeightColumn = description.AcceptsColumnRole("weight")
+	}
+	if projectID, err := strconv.Atoi(r.URL.Query().Get("project_id")); err == nil {
+		for _, project := range projects {
+			if project.ProjectID != projectID {
+				continue
+			}
+			page.ProjectName = project.Name
+			if project.DefaultModelType == "" || project.DefaultModelType == modelType {
+				page.TargetColumn = project.DefaultTargetColumn
+			}
+		}
+	}
+
+	renderTmpl(w, template_file, page, modelFormPartial)
+}
+
+// algorithmOptions lists the algorithms of the model type, values prefill the hyperparameters of the selected algorithm
+func algorithmOptions(modelType, selected string, values map[string]float64) []AlgorithmOption {
+	var options []AlgorithmOption
+	for _, title := range python.Algorithms(modelType) {
+		option := AlgorithmOption{Title: title}
+		for _, param := range python.AlgorithmHyperparameters(title) {
+			field := HyperparameterField{Hyperparameter: param}
+			if value, ok := values[param.Name]; ok && title == selected {
+				field.Value = strconv.FormatFloat(value, 'f', -1, 64)
+			}
+			option.Hyperparameters = append(option.Hyperparameters, field)
+		}
+		options = append(options, option)
+	}
+	return options
+}
+
+// metricNames returns the sorted names of all metrics met in the runs
+func metricNames(metrics map[int]map[string]float64) []string {
+	seen := make(map[string]bool)
+	var names []string
+	for _, runMetrics := range metrics {
+		for name := range runMetrics {
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+	sortMetricNames(names)
+	return names
+}
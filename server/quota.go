@@ -0,0 +1,166 @@
+package main
+
+import (
+	"feklistova/config"
+	"feklistova/models"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// quotaMu serializes quota checks with shipment creation so that parallel
+// requests can't exceed the limit of concurrent shipments
+var quotaMu sync.Mutex
+
+// UsageMeter описывает одну шкалу использования ресурсов в профиле
+type UsageMeter struct {
+	Title   string
+	Used    string
+	Limit   string // пустая строка, если ограничения нет
+	Percent int
+}
+
+// userQuota returns the limits of the user's plan with the personal overrides applied
+func userQuota(ctx context.Context, user *models.User) (models.Quota, error) {
+	quota, ok := config.Plans[user.Plan]
+	if !ok {
+		log.Printf("User %d has unknown plan %s, default plan is used", user.ID, user.Plan)
+		quota = config.Plans[config.DefaultPlan]
+	}
+	if err := repo.ApplyQuotaOverrides(ctx, user.ID, &quota); err != nil {
+		return models.Quota{}, err
+	}
+	return quota, nil
+}
+
+// monthStart returns the beginning of the month training minutes are counted for
+func monthStart(now time.Time) time.Time {
+	return time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+}
+
+// quotaError is returned when a new shipment would exceed a limit of the user's plan
+type quotaError struct {
+	Resource string
+	Message  string
+}
+
+func (e *quotaError) Error() string {
+	return e.Message
+}
+
+// registerShipment checks the quota of the user and registers the shipment
+func registerShipment(ctx context.Context, shipment *models.Shipment, quota models.Quota, datasetSize int64) error {
+	quotaMu.Lock()
+	defer quotaMu.Unlock()
+
+	usage, err := repo.GetQuotaUsage(ctx, shipment.UserID, monthStart(time.Now()))
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case quota.MaxDatasetBytes > 0 && datasetSize > quota.MaxDatasetBytes:
+		return &quotaError{"dataset_size", "Dataset exceeds the size limit of your plan"}
+	case quota.MaxStorageBytes > 0 && usage.StoredBytes+datasetSize > quota.MaxStorageBytes:
+		return &quotaError{"storage", "Storage quota exceeded"}
+	case quota.MaxConcurrentShipments > 0 && usage.RunningShipments >= quota.MaxConcurrentShipments:
+		return &quotaError{"concurrent_shipments", "Too many trainings are running at the same time"}
+	case quota.MaxTrainingMinutes > 0 && usage.TrainingMinutes >= float64(quota.MaxTrainingMinutes):
+		return &quotaError{"training_minutes", "Monthly training time quota exceeded"}
+	}
+
+	return repo.CreateShipment(ctx, shipment)
+}
+
+// createShipmentWithinQuota checks the quota of the user and registers the shipment.
+// On failure it writes the response itself.
+func createShipmentWithinQuota(ctx context.Context, w http.ResponseWriter, r *http.Request,
+	shipment *models.Shipment, quota models.Quota, datasetSize int64) bool {
+	err := registerShipment(ctx, shipment, quota, datasetSize)
+	if err == nil {
+		return true
+	}
+
+	var exceeded *quotaError
+	if errors.As(err, &exceeded) {
+		log.Printf("User %d exceeded %s quota", shipment.UserID, exceeded.Resource)
+		recordAudit(r, shipment.UserID, auditShipmentCreate, "quota:"+exceeded.Resource, outcomeDenied)
+		http.Error(w, exceeded.Message, http.StatusForbidden)
+		return false
+	}
+	log.Printf("Error creating shipment: %v", err)
+	http.Error(w, "Error creating shipment", http.StatusInternalServerError)
+	return false
+}
+
+// usageMeters prepares the usage of every limited resource for the profile page
+func usageMeters(quota models.Quota, usage *models.QuotaUsage) []UsageMeter {
+	return []UsageMeter{
+		newUsageMeter("Хранилище", formatBytes(usage.StoredBytes), formatBytes(quota.MaxStorageBytes),
+			float64(usage.StoredBytes), float64(quota.MaxStorageBytes)),
+		newUsageMeter("Обучения одновременно", fmt.Sprint(usage.RunningShipments), fmt.Sprint(quota.MaxConcurrentShipments),
+			float64(usage.RunningShipments), float64(quota.MaxConcurrentShipments)),
+		newUsageMeter("Минуты обучения в этом месяце", fmt.Sprintf("%.0f", usage.TrainingMinutes), fmt.Sprint(quota.MaxTrainingMinutes),
+			usage.TrainingMinutes, float64(quota.MaxTrainingMinutes)),
+	}
+}
+
+func newUsageMeter(title, used, limit string, usedValue, limitValue float64) UsageMeter {
+	meter := UsageMeter{Title: title, Used: used}
+	if limitValue > 0 {
+		meter.Limit = limit
+		meter.Percent = int(min(usedValue/limitValue*100, 100))
+	}
+	return meter
+}
+
+func formatBytes(size int64) string {
+	switch {
+	case size >= 1<<30:
+		return fmt.Sprintf("%.1f ГБ", float64(size)/(1<<30))
+	case size >= 1<<20:
+		return fmt.Sprintf("%.1f МБ", float64(size)/(1<<20))
+	case size >= 1<<10:
+		return fmt.Sprintf("%.1f КБ", float64(size)/(1<<10))
+	}
+	return fmt.Sprintf("%d Б", size)
+}
+
+// shipmentRunning reports whether the shipment is still training.
+// Runs stuck longer than config.StaleShipmentTimeout are not, the reaper fails them.
+func shipmentRunning(shipment *models.Shipment) bool {
+	if shipment.Status != "accepted" && shipment.Status != "in progress" {
+		return false
+	}
+	return time.Since(shipment.Timestamp) < config.StaleShipmentTimeout
+}
+
+// reapStaleShipments fails the runs left accepted or in progress since before the moment by a restart,
+// a crashed training process or an interrupted scheduled run, so they stop holding the user's quota
+func reapStaleShipments(ctx context.Context, startedBefore time.Time) {
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+
+	shipmentIDs, err := repo.FailStaleShipments(ctx, startedBefore)
+	if err != nil {
+		log.Printf("Failed to fail stale shipments: %v", err)
+		return
+	}
+	for _, shipmentID := range shipmentIDs {
+		log.Printf("Shipment %d was stuck in training and is marked as failed", shipmentID)
+
+		// the status is saved again the way training saves it, so the finish time is set,
+		// the webhooks and the email are sent and the files of the run are removed
+		shipment, err := repo.GetShipmentByID(ctx, shipmentID)
+		if err != nil {
+			log.Printf("Failed to load stale shipment %d: %v", shipmentID, err)
+		} else if err := saveShipmentStatus(ctx, shipment); err != nil {
+			log.Printf("Failed to save status of stale shipment %d: %v", shipmentID, err)
+		}
+		removeShipmentFiles(ctx, shipmentID)
+	}
+}
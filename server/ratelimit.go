@@ -0,0 +1,138 @@
+package main
+
+import (
+	"feklistova/ratelimit"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// rateLimitPolicy описывает, как лимитер применяется к маршруту
+type rateLimitPolicy struct {
+	limiter *ratelimit.Limiter
+	keys    []func(r *http.Request) string
+	// onlyFailures counts only responses with an error status and forgets the keys except the IP address on success
+	onlyFailures bool
+	// lockedPage is a page that shows the lockout notice, plain 429 is returned when empty
+	lockedPage string
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (sr *statusRecorder) WriteHeader(status int) {
+	sr.status = status
+	sr.ResponseWriter.WriteHeader(status)
+}
+
+// clientIP returns the IP address the request came from
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func ipKey(r *http.Request) string {
+	return "ip:" + clientIP(r)
+}
+
+func accountKey(r *http.Request) string {
+	r.ParseMultipartForm(10 << 20)
+	email := strings.ToLower(strings.TrimSpace(r.FormValue("email")))
+	if email == "" {
+		return ""
+	}
+	return "account:" + email
+}
+
+func userKey(r *http.Request) string {
+	userID := GetUserID(r)
+	if userID == -1 {
+		return ""
+	}
+	return fmt.Sprintf("user:%d", userID)
+}
+
+func rateLimitMiddleware(p rateLimitPolicy) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var keys []string
+			for _, keyFunc := range p.keys {
+				if key := keyFunc(r); key != "" {
+					keys = append(keys, key)
+				}
+			}
+
+			var retryAfter time.Duration
+			for _, key := range keys {
+				if wait, ok := p.limiter.Check(key); !ok && wait > retryAfter {
+					retryAfter = wait
+				}
+			}
+			if retryAfter > 0 {
+				log.Printf("Rate limit exceeded for %v on %s, retry after %s", keys, r.URL.Path, retryAfter)
+				rejectRateLimited(w, r, p.lockedPage, retryAfter)
+				return
+			}
+
+			rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+			next.ServeHTTP(rec, r)
+
+			failed := rec.status >= http.StatusBadRequest
+			for _, key := range keys {
+				if p.onlyFailures && !failed {
+					// the address keeps its failures, a successful login to one account
+					// must not clear the guesses at other accounts made from it
+					if key != ipKey(r) {
+						p.limiter.Reset(key)
+					}
+					continue
+				}
+				if p.limiter.Fail(key) {
+					log.Printf("Key %s locked out on %s", key, r.URL.Path)
+					recordAudit(r, currentActorID(r), auditLockout, key+" "+r.URL.Path, outcomeDenied)
+				}
+			}
+		})
+	}
+}
+
+// rateMiddleware limits how often the route is called, every request spends a token of each key
+func rateMiddleware(bucket *ratelimit.Bucket, keyFuncs ...func(r *http.Request) string) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var keys []string
+			for _, keyFunc := range keyFuncs {
+				if key := keyFunc(r); key != "" {
+					keys = append(keys, key)
+				}
+			}
+			if retryAfter, ok := bucket.Take(keys...); !ok {
+				log.Printf("Rate limit exceeded for %v on %s, retry after %s", keys, r.URL.Path, retryAfter)
+				rejectRateLimited(w, r, "", retryAfter)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func rejectRateLimited(w http.ResponseWriter, r *http.Request, lockedPage string, retryAfter time.Duration) {
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	w.Header().Set("Retry-After", fmt.Sprintf("%d", seconds))
+	if lockedPage != "" {
+		http.Redirect(w, r, fmt.Sprintf("%s?retry_after=%d", lockedPage, seconds), http.StatusSeeOther)
+		return
+	}
+	http.Error(w, "Слишком много запросов, попробуйте позже", http.StatusTooManyRequests)
+}
@@ -0,0 +1,407 @@
+package main
+
+import (
+	"feklistova/models"
+	"context"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/csrf"
+	"github.com/gorilla/mux"
+)
+
+// Стадии версий в реестре моделей
+const (
+	stageNone       = "none"
+	stageStaging    = "staging"
+	stageProduction = "production"
+	stageArchived   = "archived"
+)
+
+var modelStages = []string{stageNone, stageStaging, stageProduction, stageArchived}
+
+// model names are used in API paths, so only URL safe characters are allowed
+var registeredModelName = regexp.MustCompile(`^[\p{L}\p{N}_.-]{1,100}$`)
+
+type RegistryPage struct {
+	CSRFField template.HTML
+	Models    []models.RegisteredModel
+	Projects  []models.Project
+}
+
+// RegisteredModelPage содержит модель реестра, ее версии и историю смены стадий
+type RegisteredModelPage struct {
+	CSRFField   template.HTML
+	Model       *models.RegisteredModel
+	Project     *models.Project
+	Stages      []string
+	Versions    []RegistryVersion
+	Candidates  []models.Shipment // завершенные запуски проекта, еще не зарегистрированные в модели
+	Transitions []models.ModelStageTransition
+}
+
+// RegistryVersion представляет строку таблицы версий модели
+type RegistryVersion struct {
+	models.ModelVersion
+	Algorithm string
+}
+
+// ProductionModel is the answer of the latest production API
+type ProductionModel struct {
+	Model       models.RegisteredModel `json:"model"`
+	Version     models.ModelVersion    `json:"version"`
+	Algorithm   string                 `json:"algorithm"`
+	Metrics     map[string]float64     `json:"metrics"`
+	DownloadURL string                 `json:"download_url"`
+}
+
+func RegistryHandlerTmpl(w http.ResponseWriter, r *http.Request) {
+	if !IsAuthorized(r) {
+		http.Redirect(w, r, "/users/enter", http.StatusSeeOther)
+		return
+	}
+	userID := GetUserID(r)
+
+	ctx, cancel := context.WithTimeout(r.Context(), time.Second*5)
+	defer cancel()
+
+	registeredModels, err := repo.GetRegisteredModelsByUserID(ctx, userID)
+	if err != nil {
+		log.Printf("Failed to load registered models of user %d: %v", userID, err)
+		http.Error(w, "Error loading model registry", http.StatusInternalServerError)
+		return
+	}
+	projects, err := repo.GetProjectsByUserID(ctx, userID)
+	if err != nil {
+		log.Printf("Failed to load projects of user %d: %v", userID, err)
+		http.Error(w, "Error loading projects", http.StatusInternalServerError)
+		return
+	}
+
+	renderTmpl(w, "web/registry.html", RegistryPage{
+		CSRFField: csrf.TemplateField(r),
+		Models:    registeredModels,
+		Projects:  projects,
+	})
+}
+
+func RegisteredModelCreateHandler(w http.ResponseWriter, r *http.Request) {
+	if !IsAuthorized(r) {
+		http.Redirect(w, r, "/users/enter", http.StatusSeeOther)
+		return
+	}
+	userID := GetUserID(r)
+
+	if err := r.ParseMultipartForm(10 << 20); err != nil {
+		log.Println(err)
+		http.Error(w, "Error parsing form data", http.StatusBadRequest)
+		return
+	}
+
+	name := strings.TrimSpace(r.FormValue("name"))
+	if !registeredModelName.MatchString(name) {
+		http.Error(w, "Model name may contain only letters, digits, '_', '.' and '-'", http.StatusBadRequest)
+		return
+	}
+	projectID, err := strconv.Atoi(r.FormValue("project_id"))
+	if err != nil {
+		http.Error(w, "Invalid project_id", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), time.Second*5)
+	defer cancel()
+
+	project, err := repo.GetProjectByID(ctx, projectID)
+	if err != nil || project.UserID != userID {
+		log.Printf("User %d requested unknown project %d: %v", userID, projectID, err)
+		http.Error(w, "Project not found", http.StatusNotFound)
+		return
+	}
+
+	model := &models.RegisteredModel{
+		UserID:      userID,
+		ProjectID:   project.ProjectID,
+		Name:        name,
+		Description: strings.TrimSpace(r.FormValue("description")),
+		CreatedAt:   time.Now(),
+	}
+	if err := repo.CreateRegisteredModel(ctx, model); err != nil {
+		log.Printf("Failed to create registered model for user %d: %v", userID, err)
+		http.Error(w, "Model with this name already exists", http.StatusConflict)
+		return
+	}
+	log.Printf("User %d registered model %d (%s)", userID, model.ModelID, model.Name)
+
+	http.Redirect(w, r, fmt.Sprintf("/registry/models/%d", model.ModelID), http.StatusSeeOther)
+}
+
+func RegisteredModelHandlerTmpl(w http.ResponseWriter, r *http.Request) {
+	model, ok := userRegisteredModel(w, r)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), time.Second*5)
+	defer cancel()
+
+	project, err := repo.GetProjectByID(ctx, model.ProjectID)
+	if err != nil {
+		log.Printf("Failed to load project %d: %v", model.ProjectID, err)
+		http.Error(w, "Error loading model", http.StatusInternalServerError)
+		return
+	}
+	versions, err := repo.GetModelVersionsByModelID(ctx, model.ModelID)
+	if err != nil {
+		log.Printf("Failed to load versions of model %d: %v", model.ModelID, err)
+		http.Error(w, "Error loading model", http.StatusInternalServerError)
+		return
+	}
+	transitions, err := repo.GetModelStageTransitions(ctx, model.ModelID)
+	if err != nil {
+		log.Printf("Failed to load stage history of model %d: %v", model.ModelID, err)
+		http.Error(w, "Error loading model", http.StatusInternalServerError)
+		return
+	}
+	shipments, err := repo.GetShipmentsByProjectID(ctx, model.ProjectID)
+	if err != nil {
+		log.Printf("Failed to load runs of project %d: %v", model.ProjectID, err)
+		http.Error(w, "Error loading model", http.StatusInternalServerError)
+		return
+	}
+
+	page := RegisteredModelPage{
+		CSRFField:   csrf.TemplateField(r),
+		Model:       model,
+		Project:     project,
+		Stages:      modelStages,
+		Transitions: transitions,
+	}
+	registered := make(map[int]bool)
+	algorithms := make(map[int]string)
+	for _, version := range versions {
+		registered[version.ShipmentID] = true
+	}
+	for _, shipment := range shipments {
+		algorithms[shipment.ShipmentID] = shipment.Algorithm
+		if shipment.Status == "finished" && !registered[shipment.ShipmentID] {
+			page.Candidates = append(page.Candidates, shipment)
+		}
+	}
+	for _, version := range versions {
+		page.Versions = append(page.Versions, RegistryVersion{
+			ModelVersion: version,
+			Algorithm:    algorithms[version.ShipmentID],
+		})
+	}
+
+	renderTmpl(w, "web/registry_model.html", page)
+}
+
+// ModelVersionCreateHandler registers a finished run of the model's project as a new version of the model
+func ModelVersionCreateHandler(w http.ResponseWriter, r *http.Request) {
+	model, ok := userRegisteredModel(w, r)
+	if !ok {
+		return
+	}
+
+	shipmentID, err := strconv.Atoi(r.FormValue("shipment_id"))
+	if err != nil {
+		http.Error(w, "Invalid shipment_id", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), time.Second*5)
+	defer cancel()
+
+	shipment, err := repo.GetShipmentByID(ctx, shipmentID)
+	if err != nil || shipment.UserID != model.UserID || shipment.ProjectID != model.ProjectID {
+		log.Printf("User %d requested unknown shipment %d: %v", model.UserID, shipmentID, err)
+		http.Error(w, "Shipment not found", http.StatusNotFound)
+		return
+	}
+	if shipment.Status != "finished" {
+		http.Error(w, "Only finished runs can be registered", http.StatusConflict)
+		return
+	}
+	modelFiles, err := repo.GetUploadedFilesByShipmentID(ctx, shipmentID)
+	if err != nil || len(modelFiles) == 0 {
+		log.Printf("Failed to get model file of shipment %d: %v", shipmentID, err)
+		http.Error(w, "Model file of the run is not available", http.StatusConflict)
+		return
+	}
+
+	version := &models.ModelVersion{
+		ModelID:     model.ModelID,
+		ShipmentID:  shipmentID,
+		ModelFileID: modelFiles[0].FileID,
+		Stage:       stageNone,
+		CreatedBy:   model.UserID,
+		CreatedAt:   time.Now(),
+	}
+	if err := repo.CreateModelVersion(ctx, version); err != nil {
+		log.Printf("Failed to create version of model %d: %v", model.ModelID, err)
+		http.Error(w, "Error registering model version", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("User %d registered shipment %d as version %d of model %d", model.UserID, shipmentID, version.Version, model.ModelID)
+	recordAudit(r, model.UserID, auditModelVersionCreate, fmt.Sprintf("model_version:%d", version.VersionID), outcomeSuccess)
+
+	http.Redirect(w, r, fmt.Sprintf("/registry/models/%d", model.ModelID), http.StatusSeeOther)
+}
+
+// ModelVersionStageHandler moves a model version to another stage. When a version is promoted
+// to production with archive_existing set, the previous production versions are archived.
+func ModelVersionStageHandler(w http.ResponseWriter, r *http.Request) {
+	if !IsAuthorized(r) {
+		http.Redirect(w, r, "/users/enter", http.StatusSeeOther)
+		return
+	}
+	userID := GetUserID(r)
+
+	versionID, err := strconv.Atoi(mux.Vars(r)["version_id"])
+	if err != nil {
+		http.Error(w, "Invalid version_id", http.StatusBadRequest)
+		return
+	}
+	stage := r.FormValue("stage")
+	if !validModelStage(stage) {
+		http.Error(w, "Invalid stage", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), time.Second*5)
+	defer cancel()
+
+	version, err := repo.GetModelVersionByID(ctx, versionID)
+	if err != nil {
+		log.Printf("User %d requested unknown model version %d: %v", userID, versionID, err)
+		http.Error(w, "Model version not found", http.StatusNotFound)
+		return
+	}
+	model, err := repo.GetRegisteredModelByID(ctx, version.ModelID)
+	if err != nil || model.UserID != userID {
+		log.Printf("User %d requested model version %d of another user: %v", userID, versionID, err)
+		http.Error(w, "Model version not found", http.StatusNotFound)
+		return
+	}
+
+	fromStage := version.Stage
+	var archiveStage string
+	if stage == stageProduction && r.FormValue("archive_existing") != "" {
+		archiveStage = stageArchived
+	}
+	if err := repo.TransitionModelVersion(ctx, version, stage, archiveStage, userID); err != nil {
+		log.Printf("Failed to move model version %d to %s: %v", versionID, stage, err)
+		http.Error(w, "Error changing model stage", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("User %d moved version %d of model %d from %s to %s", userID, version.Version, model.ModelID, fromStage, stage)
+	recordAudit(r, userID, auditModelStageChange, fmt.Sprintf("model_version:%d %s->%s", versionID, fromStage, stage), outcomeSuccess)
+	if stage == stageProduction && fromStage != stageProduction {
+		emitEvent(ctx, model.ProjectID, eventModelPromoted, modelEventData{Model: model, Version: version})
+	}
+
+	http.Redirect(w, r, fmt.Sprintf("/registry/models/%d", model.ModelID), http.StatusSeeOther)
+}
+
+// ProductionModelAPIHandler returns the latest production version of the user's model as JSON
+func ProductionModelAPIHandler(w http.ResponseWriter, r *http.Request) {
+	if !IsAuthorized(r) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	userID := GetUserID(r)
+	name := mux.Vars(r)["model_name"]
+
+	ctx, cancel := context.WithTimeout(r.Context(), time.Second*5)
+	defer cancel()
+
+	model, version, err := productionModel(ctx, userID, name)
+	if err != nil {
+		log.Printf("No production version of model %s for user %d: %v", name, userID, err)
+		http.Error(w, "Production model not found", http.StatusNotFound)
+		return
+	}
+	shipment, err := repo.GetShipmentByID(ctx, version.ShipmentID)
+	if err != nil {
+		log.Printf("Failed to load shipment %d: %v", version.ShipmentID, err)
+		http.Error(w, "Error loading production model", http.StatusInternalServerError)
+		return
+	}
+	metrics, err := repo.GetMetricsByFileID(ctx, version.ModelFileID)
+	if err != nil {
+		log.Printf("Failed to load metrics of model file %d: %v", version.ModelFileID, err)
+		http.Error(w, "Error loading production model", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(ProductionModel{
+		Model:       *model,
+		Version:     *version,
+		Algorithm:   shipment.Algorithm,
+		Metrics:     metrics,
+		DownloadURL: fmt.Sprintf("/api/shipment/download_results/%d", version.ShipmentID),
+	})
+	if err != nil {
+		log.Printf("Failed to send production model %s: %v", name, err)
+	}
+}
+
+// productionModel resolves the latest production version of the user's registered model,
+// it is the model predictions should be made with
+func productionModel(ctx context.Context, userID int, name string) (*models.RegisteredModel, *models.ModelVersion, error) {
+	model, err := repo.GetRegisteredModelByName(ctx, userID, name)
+	if err != nil {
+		return nil, nil, err
+	}
+	version, err := repo.GetLatestModelVersionByStage(ctx, model.ModelID, stageProduction)
+	if err != nil {
+		return nil, nil, err
+	}
+	return model, version, nil
+}
+
+// userRegisteredModel loads the registered model from the URL and checks that it belongs to the logged in user.
+// On failure it writes the response itself.
+func userRegisteredModel(w http.ResponseWriter, r *http.Request) (*models.RegisteredModel, bool) {
+	if !IsAuthorized(r) {
+		http.Redirect(w, r, "/users/enter", http.StatusSeeOther)
+		return nil, false
+	}
+	userID := GetUserID(r)
+
+	modelID, err := strconv.Atoi(mux.Vars(r)["model_id"])
+	if err != nil {
+		http.Error(w, "Invalid model_id", http.StatusBadRequest)
+		return nil, false
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), time.Second*5)
+	defer cancel()
+
+	model, err := repo.GetRegisteredModelByID(ctx, modelID)
+	if err != nil || model.UserID != userID {
+		log.Printf("User %d requested unknown registered model %d: %v", userID, modelID, err)
+		http.Error(w, "Model not found", http.StatusNotFound)
+		return nil, false
+	}
+	return model, true
+}
+
+func validModelStage(stage string) bool {
+	for _, known := range modelStages {
+		if stage == known {
+			return true
+		}
+	}
+	return false
+}
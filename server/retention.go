@@ -0,0 +1,108 @@
+package main
+
+import (
+	"feklistova/config"
+	"context"
+	"fmt"
+	"log"
+	"time"
+)
+
+// applyRetention deletes the datasets kept longer than config.DatasetRetention
+// and purges the shipments that stayed in the trash longer than config.TrashRetention
+func applyRetention(ctx context.Context) {
+	now := time.Now()
+	if config.DatasetRetention > 0 {
+		expireDatasets(ctx, now.Add(-config.DatasetRetention))
+	}
+	purgeTrash(ctx, now.Add(-config.TrashRetention))
+}
+
+// expireDatasets removes the datasets of shipments whose training has ended before the moment.
+// The file is removed first and its row afterwards, so a failed removal is retried on the next pass.
+func expireDatasets(ctx context.Context, finishedBefore time.Time) {
+	ctxQuery, cancelQuery := context.WithTimeout(ctx, time.Second*10)
+	defer cancelQuery()
+
+	files, err := repo.GetExpiredDatasets(ctxQuery, finishedBefore, config.RetentionBatchSize)
+	if err != nil {
+		log.Printf("Failed to get expired datasets: %v", err)
+		return
+	}
+	for _, file := range files {
+		if file.FilePath != "" {
+			if err := fileRepo.DeleteFileByPath(file.FilePath); err != nil {
+				log.Printf("Failed to delete dataset file %s: %v", file.FilePath, err)
+				continue
+			}
+		}
+		if err := repo.DeleteFile(ctxQuery, file.FileID, true); err != nil {
+			log.Printf("Failed to forget dataset with ID %d: %v", file.FileID, err)
+			continue
+		}
+		log.Printf("Dataset with ID %d of shipment %d expired", file.FileID, file.ShipmentID)
+	}
+}
+
+// purgeTrash deletes up to a batch of the shipments moved to the trash before the moment.
+// Shipments that fail to purge are skipped for the rest of the run, so a batch of oldest shipments
+// that can not be purged, e.g. registered in the model registry, does not hold back the newer ones.
+func purgeTrash(ctx context.Context, deletedBefore time.Time) {
+	skipped := []int{}
+	purged := 0
+	for purged < config.RetentionBatchSize {
+		ctxQuery, cancelQuery := context.WithTimeout(ctx, time.Second*10)
+		shipments, err := repo.GetExpiredTrashedShipments(ctxQuery, deletedBefore, config.RetentionBatchSize-purged, skipped)
+		cancelQuery()
+		if err != nil {
+			log.Printf("Failed to get expired trashed shipments: %v", err)
+			return
+		}
+		if len(shipments) == 0 {
+			return
+		}
+
+		for _, shipment := range shipments {
+			if err := purgeShipment(ctx, shipment.ShipmentID); err != nil {
+				log.Printf("Failed to purge shipment %d: %v", shipment.ShipmentID, err)
+				skipped = append(skipped, shipment.ShipmentID)
+				continue
+			}
+			purged++
+			log.Printf("Shipment %d of user %d purged from the trash", shipment.ShipmentID, shipment.UserID)
+		}
+	}
+}
+
+// purgeShipment deletes the shipment with its files for good. Physical files are removed before
+// the rows, so when a file can't be removed the shipment stays in the trash and is retried later.
+func purgeShipment(ctx context.Context, shipmentID int) error {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*10)
+	defer cancel()
+
+	versions, err := repo.CountModelVersionsByShipmentID(ctx, shipmentID)
+	if err != nil {
+		return err
+	}
+	if versions > 0 {
+		return fmt.Errorf("shipment is registered in the model registry")
+	}
+
+	downloadedFiles, err := repo.GetDownloadedFilesByShipmentID(ctx, shipmentID)
+	if err != nil {
+		return err
+	}
+	uploadedFiles, err := repo.GetUploadedFilesByShipmentID(ctx, shipmentID)
+	if err != nil {
+		return err
+	}
+	for _, file := range append(downloadedFiles, uploadedFiles...) {
+		if file.FilePath == "" {
+			continue
+		}
+		if err := fileRepo.DeleteFileByPath(file.FilePath); err != nil {
+			return fmt.Errorf("error deleting file with ID %d: %w", file.FileID, err)
+		}
+	}
+	return repo.DeleteShipment(ctx, shipmentID)
+}
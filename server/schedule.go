@@ -0,0 +1,416 @@
+package main
+
+import (
+	"feklistova/models"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/robfig/cron/v3"
+)
+
+// Статусы записей истории запусков по расписанию
+const (
+	scheduleRunStarted  = "started"
+	scheduleRunFinished = "finished"
+	scheduleRunFailed   = "failed"
+)
+
+func ScheduleCreateHandler(w http.ResponseWriter, r *http.Request) {
+	project, ok := userProject(w, r)
+	if !ok {
+		return
+	}
+
+	if err := r.ParseMultipartForm(10 << 20); err != nil {
+		log.Println(err)
+		http.Error(w, "Error parsing form data", http.StatusBadRequest)
+		return
+	}
+
+	cronExpr := strings.TrimSpace(r.FormValue("cron_expr"))
+	spec, err := cron.ParseStandard(cronExpr)
+	if err != nil {
+		http.Error(w, "Invalid cron expression: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), time.Second*30)
+	defer cancel()
+
+	schedule := &models.Schedule{
+		UserID:    project.UserID,
+		ProjectID: project.ProjectID,
+		CronExpr:  cronExpr,
+		Enabled:   true,
+		NextRunAt: spec.Next(time.Now()),
+		CreatedAt: time.Now(),
+	}
+	if value := r.FormValue("template_shipment_id"); value != "" {
+		if schedule.TemplateShipmentID, err = strconv.Atoi(value); err != nil {
+			http.Error(w, "Invalid template_shipment_id", http.StatusBadRequest)
+			return
+		}
+		template, err := repo.GetShipmentByID(ctx, schedule.TemplateShipmentID)
+		if err != nil || template.ProjectID != project.ProjectID {
+			log.Printf("User %d requested unknown template shipment %d: %v", project.UserID, schedule.TemplateShipmentID, err)
+			http.Error(w, "Shipment not found", http.StatusNotFound)
+			return
+		}
+	}
+
+	// the dataset is saved first, so a failed upload does not leave a schedule without its dataset
+	datasetSaved, ok := storeScheduleDataset(ctx, w, r, schedule)
+	if !ok {
+		return
+	}
+	if err := repo.CreateSchedule(ctx, schedule); err != nil {
+		log.Printf("Failed to create schedule in project %d: %v", project.ProjectID, err)
+		if datasetSaved {
+			if err := fileRepo.DeleteFileByPath(schedule.DatasetPath); err != nil {
+				log.Printf("Failed to delete dataset of the schedule not created: %v", err)
+			}
+		}
+		http.Error(w, "Error creating schedule", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("User %d created schedule %d (%s) in project %d", project.UserID, schedule.ScheduleID, cronExpr, project.ProjectID)
+
+	http.Redirect(w, r, fmt.Sprintf("/projects/%d", project.ProjectID), http.StatusSeeOther)
+}
+
+// ScheduleDatasetHandler replaces the dataset the next runs of the schedule are trained on
+func ScheduleDatasetHandler(w http.ResponseWriter, r *http.Request) {
+	schedule, ok := userSchedule(w, r)
+	if !ok {
+		return
+	}
+
+	if err := r.ParseMultipartForm(10 << 20); err != nil {
+		log.Println(err)
+		http.Error(w, "Error parsing form data", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), time.Second*30)
+	defer cancel()
+
+	if !saveScheduleDataset(ctx, w, r, schedule) {
+		return
+	}
+	http.Redirect(w, r, fmt.Sprintf("/projects/%d", schedule.ProjectID), http.StatusSeeOther)
+}
+
+// ScheduleToggleHandler pauses the schedule or resumes it from the current time
+func ScheduleToggleHandler(w http.ResponseWriter, r *http.Request) {
+	schedule, ok := userSchedule(w, r)
+	if !ok {
+		return
+	}
+
+	schedule.Enabled = !schedule.Enabled
+	if schedule.Enabled {
+		schedule.NextRunAt = nextScheduleRun(schedule)
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), time.Second*5)
+	defer cancel()
+
+	if err := repo.UpdateScheduleState(ctx, schedule); err != nil {
+		log.Printf("Failed to update schedule %d: %v", schedule.ScheduleID, err)
+		http.Error(w, "Error updating schedule", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("User %d set schedule %d enabled=%t", schedule.UserID, schedule.ScheduleID, schedule.Enabled)
+
+	http.Redirect(w, r, fmt.Sprintf("/projects/%d", schedule.ProjectID), http.StatusSeeOther)
+}
+
+func ScheduleDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	schedule, ok := userSchedule(w, r)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), time.Second*5)
+	defer cancel()
+
+	if err := repo.DeleteSchedule(ctx, schedule.ScheduleID); err != nil {
+		log.Printf("Failed to delete schedule %d: %v", schedule.ScheduleID, err)
+		http.Error(w, "Error deleting schedule", http.StatusInternalServerError)
+		return
+	}
+	if schedule.DatasetPath != "" {
+		if err := fileRepo.DeleteFileByPath(schedule.DatasetPath); err != nil {
+			log.Printf("Failed to delete dataset of schedule %d: %v", schedule.ScheduleID, err)
+		}
+	}
+	log.Printf("User %d deleted schedule %d", schedule.UserID, schedule.ScheduleID)
+
+	http.Redirect(w, r, fmt.Sprintf("/projects/%d", schedule.ProjectID), http.StatusSeeOther)
+}
+
+// userSchedule loads the schedule from the URL and checks that it belongs to the logged in user.
+// On failure it writes the response itself.
+func userSchedule(w http.ResponseWriter, r *http.Request) (*models.Schedule, bool) {
+	if !IsAuthorized(r) {
+		http.Redirect(w, r, "/users/enter", http.StatusSeeOther)
+		return nil, false
+	}
+	userID := GetUserID(r)
+
+	scheduleID, err := strconv.Atoi(mux.Vars(r)["schedule_id"])
+	if err != nil {
+		http.Error(w, "Invalid schedule_id", http.StatusBadRequest)
+		return nil, false
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), time.Second*5)
+	defer cancel()
+
+	schedule, err := repo.GetScheduleByID(ctx, scheduleID)
+	if err != nil || schedule.UserID != userID {
+		log.Printf("User %d requested unknown schedule %d: %v", userID, scheduleID, err)
+		http.Error(w, "Schedule not found", http.StatusNotFound)
+		return nil, false
+	}
+	return schedule, true
+}
+
+// saveScheduleDataset replaces the dataset of the schedule with the file from the form,
+// the previous dataset is removed. Nothing is changed when the form has no file.
+// On failure it writes the response itself.
+func saveScheduleDataset(ctx context.Context, w http.ResponseWriter, r *http.Request, schedule *models.Schedule) bool {
+	previousPath := schedule.DatasetPath
+	saved, ok := storeScheduleDataset(ctx, w, r, schedule)
+	if !saved {
+		return ok
+	}
+
+	if err := repo.UpdateScheduleDataset(ctx, schedule); err != nil {
+		log.Printf("Failed to update dataset of schedule %d: %v", schedule.ScheduleID, err)
+		if err := fileRepo.DeleteFileByPath(schedule.DatasetPath); err != nil {
+			log.Printf("Failed to delete new dataset of schedule %d: %v", schedule.ScheduleID, err)
+		}
+		http.Error(w, "Error saving dataset", http.StatusInternalServerError)
+		return false
+	}
+	if previousPath != "" {
+		if err := fileRepo.DeleteFileByPath(previousPath); err != nil {
+			log.Printf("Failed to delete previous dataset of schedule %d: %v", schedule.ScheduleID, err)
+		}
+	}
+	log.Printf("Dataset of schedule %d replaced with %s", schedule.ScheduleID, schedule.DatasetName)
+	return true
+}
+
+// storeScheduleDataset saves the dataset file from the form under a new name and sets it as the dataset
+// of the schedule, the schedule row is left to the caller. saved is false when the form has no file.
+// On failure it writes the response itself.
+func storeScheduleDataset(ctx context.Context, w http.ResponseWriter, r *http.Request, schedule *models.Schedule) (saved, ok bool) {
+	file, fileHeader, err := r.FormFile("file")
+	if errors.Is(err, http.ErrMissingFile) {
+		return false, true
+	}
+	if err != nil {
+		log.Printf("Error retrieving schedule dataset: %v", err)
+		http.Error(w, "Error retrieving file", http.StatusBadRequest)
+		return false, false
+	}
+	defer file.Close()
+
+	user, err := repo.GetUserByID(ctx, schedule.UserID)
+	if err != nil {
+		log.Printf("Failed to load user %d: %v", schedule.UserID, err)
+		http.Error(w, "Unable to identify user", http.StatusInternalServerError)
+		return false, false
+	}
+	quota, err := userQuota(ctx, user)
+	if err != nil {
+		log.Printf("Failed to load quota of user %d: %v", schedule.UserID, err)
+		http.Error(w, "Error saving dataset", http.StatusInternalServerError)
+		return false, false
+	}
+	if quota.MaxDatasetBytes > 0 && fileHeader.Size > quota.MaxDatasetBytes {
+		http.Error(w, "Dataset exceeds the size limit of your plan", http.StatusRequestEntityTooLarge)
+		return false, false
+	}
+
+	// every upload gets its own name, so the current dataset stays intact until the schedule points to the new one
+	fileName := fmt.Sprintf("schedule_%d_%d.%s", schedule.UserID, time.Now().UnixNano(), fileExtension(fileHeader.Filename))
+	if err := fileRepo.SaveDownloadedFile(fileName, file); err != nil {
+		log.Printf("Error saving dataset of user %d schedule: %v", schedule.UserID, err)
+		http.Error(w, "Error saving dataset", http.StatusInternalServerError)
+		return false, false
+	}
+
+	schedule.DatasetPath = fileRepo.GetDownloadedFilePath(fileName)
+	schedule.DatasetName = fileHeader.Filename
+	schedule.DatasetSize = fileHeader.Size
+	return true, true
+}
+
+// nextScheduleRun returns the next run time of the schedule after the current time
+func nextScheduleRun(schedule *models.Schedule) time.Time {
+	spec, err := cron.ParseStandard(schedule.CronExpr)
+	if err != nil {
+		// expressions are validated when a schedule is created, a broken one is retried a day later
+		log.Printf("Schedule %d has invalid cron expression %q: %v", schedule.ScheduleID, schedule.CronExpr, err)
+		return time.Now().Add(time.Hour * 24)
+	}
+	return spec.Next(time.Now())
+}
+
+// runDueSchedules starts a new shipment for every schedule whose run time has come.
+// Claiming the schedules is safe when several server instances share the database.
+func runDueSchedules(ctx context.Context) {
+	ctxClaim, cancelClaim := context.WithTimeout(ctx, time.Second*5)
+	defer cancelClaim()
+
+	schedules, err := repo.ClaimDueSchedules(ctxClaim, time.Now(), nextScheduleRun)
+	if err != nil {
+		log.Printf("Failed to claim due schedules: %v", err)
+		return
+	}
+	for _, schedule := range schedules {
+		go runSchedule(ctx, schedule)
+	}
+}
+
+// runSchedule creates and trains a shipment of the schedule and records it in the run history
+func runSchedule(ctx context.Context, schedule models.Schedule) {
+	log.Printf("Starting scheduled run of schedule %d in project %d", schedule.ScheduleID, schedule.ProjectID)
+
+	run := &models.ScheduleRun{
+		ScheduleID: schedule.ScheduleID,
+		Status:     scheduleRunStarted,
+		StartedAt:  time.Now(),
+	}
+	ctxRun, cancelRun := context.WithTimeout(ctx, time.Second*5)
+	defer cancelRun()
+
+	if err := repo.CreateScheduleRun(ctxRun, run); err != nil {
+		log.Printf("Failed to record run of schedule %d: %v", schedule.ScheduleID, err)
+		return
+	}
+
+	shipment, dataset, err := scheduledShipment(ctxRun, &schedule)
+	if err == nil {
+		run.ShipmentID = shipment.ShipmentID
+		if err := repo.UpdateScheduleRun(ctxRun, run); err != nil {
+			log.Printf("Failed to update run %d of schedule %d: %v", run.RunID, schedule.ScheduleID, err)
+		}
+		err = trainShipment(ctx, shipment, dataset)
+		dataset.Close()
+	}
+
+	run.Status = scheduleRunFinished
+	if err != nil {
+		log.Printf("Scheduled run %d of schedule %d failed: %v", run.RunID, schedule.ScheduleID, err)
+		run.Status = scheduleRunFailed
+		run.Error = err.Error()
+	}
+
+	ctxFinal, cancelFinal := context.WithTimeout(context.WithoutCancel(ctx), time.Second*5)
+	defer cancelFinal()
+
+	if err := repo.UpdateScheduleRun(ctxFinal, run); err != nil {
+		log.Printf("Failed to update run %d of schedule %d: %v", run.RunID, schedule.ScheduleID, err)
+	}
+}
+
+// scheduledShipment registers a shipment with the settings of the schedule template within the user's quota.
+// The template is the chosen shipment or the latest finished shipment of the project.
+func scheduledShipment(ctx context.Context, schedule *models.Schedule) (*models.Shipment, *datasetSource, error) {
+	user, err := repo.GetUserByID(ctx, schedule.UserID)
+	if err != nil {
+		return nil, nil, err
+	}
+	quota, err := userQuota(ctx, user)
+	if err != nil {
+		return nil, nil, err
+	}
+	project, err := repo.GetProjectByID(ctx, schedule.ProjectID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var template *models.Shipment
+	if schedule.TemplateShipmentID != 0 {
+		if template, err = repo.GetShipmentByID(ctx, schedule.TemplateShipmentID); err != nil {
+			return nil, nil, err
+		}
+	} else {
+		shipments, err := repo.GetShipmentsByProjectID(ctx, schedule.ProjectID)
+		if err != nil {
+			return nil, nil, err
+		}
+		for i := range shipments {
+			if shipments[i].Status == "finished" {
+				template = &shipments[i]
+				break
+			}
+		}
+		if template == nil {
+			return nil, nil, errors.New("project has no finished runs to use as a template")
+		}
+	}
+
+	var dataset *datasetSource
+	if schedule.DatasetPath != "" {
+		dataset, err = openStoredDataset(schedule.DatasetPath, schedule.DatasetName, schedule.DatasetSize)
+	} else {
+		var files []models.File
+		if files, err = repo.GetDownloadedFilesByShipmentID(ctx, template.ShipmentID); err == nil {
+			if len(files) == 0 || files[0].FilePath == "" {
+				return nil, nil, fmt.Errorf("dataset of template shipment %d is not available", template.ShipmentID)
+			}
+			dataset, err = openStoredDataset(files[0].FilePath, files[0].OriginalName, files[0].Size)
+		}
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	shipment := &models.Shipment{
+		UserID:          schedule.UserID,
+		ProjectID:       project.ProjectID,
+		ParentID:        template.ShipmentID,
+		ProjectName:     project.Name,
+		ModelType:       template.ModelType,
+		Algorithm:       template.Algorithm,
+		TargetColumn:    template.TargetColumn,
+		Hyperparameters: template.Hyperparameters,
+		Forecast:        template.Forecast,
+		Validation:      template.Validation,
+		Columns:         template.Columns,
+		Status:          "accepted",
+		Timestamp:       time.Now(),
+	}
+	if err := registerShipment(ctx, shipment, quota, dataset.Size); err != nil {
+		dataset.Close()
+		return nil, nil, err
+	}
+	return shipment, dataset, nil
+}
+
+// openStoredDataset opens a dataset that is already kept in the file storage
+func openStoredDataset(path, name string, size int64) (*datasetSource, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return &datasetSource{
+		ReadCloser: file,
+		Name:       name,
+		Extension:  fileExtension(path),
+		Size:       size,
+	}, nil
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"feklistova/models"
+	"feklistova/python"
+	"context"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/csrf"
+)
+
+const searchPageSize = 50
+
+var shipmentStatuses = []string{"accepted", "in progress", "finished", "failed", "denied"}
+
+type SearchPage struct {
+	CSRFField   template.HTML
+	Filter      url.Values
+	MetricNames []string
+	Results     []ProjectRun
+	Tags        []string
+	Algorithms  []string
+	Statuses    []string
+	PrevQuery   template.URL
+	NextQuery   template.URL
+}
+
+// SearchResult is a shipment found by the search API together with its metrics
+type SearchResult struct {
+	models.Shipment
+	Metrics map[string]float64 `json:"metrics"`
+}
+
+func SearchHandlerTmpl(w http.ResponseWriter, r *http.Request) {
+	if !IsAuthorized(r) {
+		http.Redirect(w, r, "/users/enter", http.StatusSeeOther)
+		return
+	}
+	userID := GetUserID(r)
+
+	query := r.URL.Query()
+	filter, err := parseShipmentFilter(query, userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), time.Second*10)
+	defer cancel()
+
+	shipments, metrics, err := searchShipments(ctx, filter)
+	if err != nil {
+		log.Printf("Failed to search shipments of user %d: %v", userID, err)
+		http.Error(w, "Error searching shipments", http.StatusInternalServerError)
+		return
+	}
+	tags, err := repo.GetTagsByUserID(ctx, userID)
+	if err != nil {
+		log.Printf("Failed to load tags of user %d: %v", userID, err)
+		http.Error(w, "Error searching shipments", http.StatusInternalServerError)
+		return
+	}
+
+	var algorithms []string
+	for _, modelType := range python.ModelTypes() {
+		algorithms = append(algorithms, python.Algorithms(modelType)...)
+	}
+
+	page := SearchPage{
+		CSRFField:   csrf.TemplateField(r),
+		Filter:      query,
+		MetricNames: metricNames(metrics),
+		Tags:        tags,
+		Algorithms:  algorithms,
+		Statuses:    shipmentStatuses,
+	}
+	for _, shipment := range shipments {
+		run := ProjectRun{Shipment: shipment}
+		for _, name := range page.MetricNames {
+			value, ok := metrics[shipment.ShipmentID][name]
+			if ok {
+				run.Metrics = append(run.Metrics, strconv.FormatFloat(value, 'f', 4, 64))
+			} else {
+				run.Metrics = append(run.Metrics, "")
+			}
+		}
+		page.Results = append(page.Results, run)
+	}
+
+	if filter.Offset > 0 {
+		prev := cloneValues(query)
+		prev.Set("offset", strconv.Itoa(max(filter.Offset-filter.Limit, 0)))
+		page.PrevQuery = template.URL(prev.Encode())
+	}
+	if len(shipments) == filter.Limit {
+		next := cloneValues(query)
+		next.Set("offset", strconv.Itoa(filter.Offset+filter.Limit))
+		page.NextQuery = template.URL(next.Encode())
+	}
+
+	renderTmpl(w, "web/search.html", page)
+}
+
+// SearchAPIHandler returns the shipments matching the filter with their tags and metrics as JSON
+func SearchAPIHandler(w http.ResponseWriter, r *http.Request) {
+	if !IsAuthorized(r) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	userID := GetUserID(r)
+
+	filter, err := parseShipmentFilter(r.URL.Query(), userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), time.Second*10)
+	defer cancel()
+
+	shipments, metrics, err := searchShipments(ctx, filter)
+	if err != nil {
+		log.Printf("Failed to search shipments of user %d: %v", userID, err)
+		http.Error(w, "Error searching shipments", http.StatusInternalServerError)
+		return
+	}
+
+	results := make([]SearchResult, 0, len(shipments))
+	for _, shipment := range shipments {
+		results = append(results, SearchResult{Shipment: shipment, Metrics: metrics[shipment.ShipmentID]})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(results); err != nil {
+		log.Printf("Failed to send search results: %v", err)
+	}
+}
+
+// searchShipments finds the shipments and loads their tags and metrics
+func searchShipments(ctx context.Context, filter models.ShipmentFilter) ([]models.Shipment, map[int]map[string]float64, error) {
+	shipments, err := repo.SearchShipments(ctx, filter)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	shipmentIDs := make([]int, len(shipments))
+	for i, shipment := range shipments {
+		shipmentIDs[i] = shipment.ShipmentID
+	}
+	tags, err := repo.GetShipmentTags(ctx, shipmentIDs)
+	if err != nil {
+		return nil, nil, err
+	}
+	for i := range shipments {
+		shipments[i].Tags = tags[shipments[i].ShipmentID]
+	}
+	metrics, err := repo.GetMetricsByShipmentIDs(ctx, shipmentIDs)
+	if err != nil {
+		return nil, nil, err
+	}
+	return shipments, metrics, nil
+}
+
+func parseShipmentFilter(query url.Values, userID int) (models.ShipmentFilter, error) {
+	filter := models.ShipmentFilter{
+		UserID:      userID,
+		ProjectName: strings.TrimSpace(query.Get("project")),
+		Algorithm:   query.Get("algorithm"),
+		ModelType:   query.Get("model_type"),
+		Status:      query.Get("status"),
+		Limit:       searchPageSize,
+	}
+
+	var err error
+	if filter.ModelType != "" && !python.ValidModelType(filter.ModelType) {
+		return filter, fmt.Errorf("invalid model_type: %s", filter.ModelType)
+	}
+	for _, value := range query["tag"] {
+		tags, err := parseTags(value)
+		if err != nil {
+			return filter, err
+		}
+		filter.Tags = append(filter.Tags, tags...)
+	}
+	if value := query.Get("from"); value != "" {
+		if filter.From, err = time.Parse("2006-01-02", value); err != nil {
+			return filter, fmt.Errorf("invalid from date: %s", value)
+		}
+	}
+	if value := query.Get("to"); value != "" {
+		if filter.To, err = time.Parse("2006-01-02", value); err != nil {
+			return filter, fmt.Errorf("invalid to date: %s", value)
+		}
+		// the end date is inclusive
+		filter.To = filter.To.AddDate(0, 0, 1)
+	}
+	for _, value := range query["metric"] {
+		if strings.TrimSpace(value) == "" {
+			continue
+		}
+		threshold, err := parseMetricThreshold(value)
+		if err != nil {
+			return filter, err
+		}
+		filter.Metrics = append(filter.Metrics, threshold)
+	}
+	if value := query.Get("limit"); value != "" {
+		if filter.Limit, err = strconv.Atoi(value); err != nil || filter.Limit <= 0 {
+			return filter, fmt.Errorf("invalid limit: %s", value)
+		}
+	}
+	if value := query.Get("offset"); value != "" {
+		if filter.Offset, err = strconv.Atoi(value); err != nil || filter.Offset < 0 {
+			return filter, fmt.Errorf("invalid offset: %s", value)
+		}
+	}
+	return filter, nil
+}
+
+// parseMetricThreshold parses conditions like "F1-score>=0.8"
+func parseMetricThreshold(value string) (models.MetricThreshold, error) {
+	for _, operator := range []string{">=", "<=", ">", "<"} {
+		index := strings.Index(value, operator)
+		if index <= 0 {
+			continue
+		}
+		threshold := models.MetricThreshold{
+			Name:     strings.TrimSpace(value[:index]),
+			Operator: operator,
+		}
+		number, err := strconv.ParseFloat(strings.TrimSpace(value[index+len(operator):]), 64)
+		if err != nil || threshold.Name == "" {
+			break
+		}
+		threshold.Value = number
+		return threshold, nil
+	}
+	return models.MetricThreshold{}, fmt.Errorf("invalid metric condition %q, expected e.g. F1-score>=0.8", value)
+}
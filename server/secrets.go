@@ -0,0 +1,50 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const secretKeySize = 32
+
+// loadSecretKey returns the key named by name: the key from the environment variable env is used
+// when it is set, otherwise a random key is generated on the first start and kept in file,
+// so the signed cookies and tokens stay valid after a restart.
+func loadSecretKey(name, env, file string) ([]byte, error) {
+	if value := strings.TrimSpace(os.Getenv(env)); value != "" {
+		key, err := hex.DecodeString(value)
+		if err != nil || len(key) != secretKeySize {
+			return nil, fmt.Errorf("%s must be %d bytes in hex", env, secretKeySize)
+		}
+		return key, nil
+	}
+
+	data, err := os.ReadFile(file)
+	if err == nil {
+		key, err := hex.DecodeString(strings.TrimSpace(string(data)))
+		if err != nil || len(key) != secretKeySize {
+			return nil, fmt.Errorf("%s does not hold a %d byte key in hex", file, secretKeySize)
+		}
+		return key, nil
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	key := make([]byte, secretKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(file, []byte(hex.EncodeToString(key)), 0600); err != nil {
+		return nil, err
+	}
+	log.Printf("Generated a new %s in %s", name, file)
+	return key, nil
+}
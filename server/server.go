@@ -1,9 +1,11 @@
 package main
 
 import (
+	"feklistova/config"
 	"context"
 	"log"
 	"net/http"
+	"time"
 
 	"github.com/gorilla/mux"
 	httpSwagger "github.com/swaggo/http-swagger/v2"
@@ -22,6 +24,7 @@ func Serve(ctx context.Context) {
 
 	router := mux.NewRouter()
 	router.Use(loggingMiddleware)
+	router.Use(csrfMiddleware())
 
 	http.Handle("/", router)
 
@@ -42,18 +45,126 @@ func Serve(ctx context.Context) {
 	router.HandleFunc("/users/register", RegisterHandlerTmpl).Methods("GET") // registration.html
 	router.HandleFunc("/profile", ProfileHandlerTmpl).Methods("GET")         // profile.html
 
-	router.HandleFunc("/api/users/enter", LoginHandler).Methods("POST") // enter.html
-	router.HandleFunc("/api/users/register", RegisterHandler)           // registration.html
-	router.HandleFunc("/api/profile", ProfileHandler)                   // profile.html
+	loginRateLimit := rateLimitMiddleware(rateLimitPolicy{
+		limiter:      &loginLimiter,
+		keys:         []func(r *http.Request) string{ipKey, accountKey},
+		onlyFailures: true,
+		lockedPage:   "/users/enter",
+	})
+	registerRateLimit := rateLimitMiddleware(rateLimitPolicy{
+		limiter:    &registerLimiter,
+		keys:       []func(r *http.Request) string{ipKey},
+		lockedPage: "/users/register",
+	})
+	router.Handle("/api/users/enter", loginRateLimit(http.HandlerFunc(LoginHandler))).Methods("POST") // enter.html
+	router.Handle("/api/users/register", registerRateLimit(http.HandlerFunc(RegisterHandler)))        // registration.html
+	router.HandleFunc("/api/profile", ProfileHandler)                                                 // profile.html
+
+	// two-factor authentication
+	router.HandleFunc("/users/enter/2fa", LoginTwoFactorHandlerTmpl).Methods("GET") // enter_2fa.html
+	router.HandleFunc("/profile/2fa", TwoFactorHandlerTmpl).Methods("GET")          // twofactor.html
+
+	twoFactorRateLimit := rateLimitMiddleware(rateLimitPolicy{
+		limiter:      &loginLimiter,
+		keys:         []func(r *http.Request) string{ipKey, pendingUserKey},
+		onlyFailures: true,
+		lockedPage:   "/users/enter",
+	})
+	router.Handle("/api/users/enter/2fa", twoFactorRateLimit(http.HandlerFunc(LoginTwoFactorHandler))).Methods("POST") // enter_2fa.html
+	router.HandleFunc("/api/profile/2fa/enable", TwoFactorEnableHandler).Methods("POST")                               // twofactor.html
+	router.HandleFunc("/api/profile/2fa/disable", TwoFactorDisableHandler).Methods("POST")                             // twofactor.html
+	router.HandleFunc("/api/profile/2fa/recovery_codes", TwoFactorRecoveryCodesHandler).Methods("POST")                // twofactor.html
+
+	// account management
+	router.HandleFunc("/profile/edit", ProfileEditHandlerTmpl).Methods("GET") // profile_edit.html
+
+	accountRateLimit := rateLimitMiddleware(rateLimitPolicy{
+		limiter:      &loginLimiter,
+		keys:         []func(r *http.Request) string{ipKey, userKey},
+		onlyFailures: true,
+	})
+	router.HandleFunc("/api/profile/update", ProfileUpdateHandler).Methods("POST")                                    // profile_edit.html
+	router.HandleFunc("/api/profile/notifications", NotificationPreferencesHandler).Methods("POST")                   // profile_edit.html
+	router.Handle("/api/profile/password", accountRateLimit(http.HandlerFunc(PasswordChangeHandler))).Methods("POST") // profile_edit.html
+	router.Handle("/api/profile/delete", accountRateLimit(http.HandlerFunc(AccountDeleteHandler))).Methods("POST")    // profile_edit.html
+
+	// data export
+	router.HandleFunc("/profile/export", DataExportHandlerTmpl).Methods("GET")                          // export.html
+	router.HandleFunc("/api/profile/export", DataExportHandler).Methods("POST")                         // export.html
+	router.HandleFunc("/api/profile/export/download/{token}", DataExportDownloadHandler).Methods("GET") // export.html
+
+	// administration
+	router.HandleFunc("/admin/audit", AuditHandlerTmpl).Methods("GET")    // audit.html
+	router.HandleFunc("/api/admin/audit", AuditAPIHandler).Methods("GET") // audit.html
+
+	// projects
+	router.HandleFunc("/projects", ProjectsHandlerTmpl).Methods("GET")                           // projects.html
+	router.HandleFunc("/projects/{project_id}", ProjectHandlerTmpl).Methods("GET")               // project.html
+	router.HandleFunc("/api/projects", ProjectCreateHandler).Methods("POST")                     // projects.html
+	router.HandleFunc("/api/projects/{project_id}/update", ProjectUpdateHandler).Methods("POST") // project.html
+
+	// scheduled retraining
+	router.HandleFunc("/api/projects/{project_id}/schedules", ScheduleCreateHandler).Methods("POST")  // project.html
+	router.HandleFunc("/api/schedules/{schedule_id}/dataset", ScheduleDatasetHandler).Methods("POST") // project.html
+	router.HandleFunc("/api/schedules/{schedule_id}/toggle", ScheduleToggleHandler).Methods("POST")   // project.html
+	router.HandleFunc("/api/schedules/{schedule_id}/delete", ScheduleDeleteHandler).Methods("POST")   // project.html
+
+	// model registry
+	router.HandleFunc("/registry", RegistryHandlerTmpl).Methods("GET")                                          // registry.html
+	router.HandleFunc("/registry/models/{model_id}", RegisteredModelHandlerTmpl).Methods("GET")                 // registry_model.html
+	router.HandleFunc("/api/registry/models", RegisteredModelCreateHandler).Methods("POST")                     // registry.html
+	router.HandleFunc("/api/registry/models/{model_id}/versions", ModelVersionCreateHandler).Methods("POST")    // registry_model.html
+	router.HandleFunc("/api/registry/versions/{version_id}/stage", ModelVersionStageHandler).Methods("POST")    // registry_model.html
+	router.HandleFunc("/api/registry/models/{model_name}/production", ProductionModelAPIHandler).Methods("GET") // latest production version
+
+	// experiment comparison
+	router.HandleFunc("/shipments/compare", ComparisonHandlerTmpl).Methods("GET")    // compare.html
+	router.HandleFunc("/api/shipments/compare", ComparisonAPIHandler).Methods("GET") // compare.html
+
+	// project webhooks
+	router.HandleFunc("/api/projects/{project_id}/webhooks", WebhookCreateHandler).Methods("POST") // project.html
+	router.HandleFunc("/api/webhooks/{webhook_id}/toggle", WebhookToggleHandler).Methods("POST")   // project.html
+	router.HandleFunc("/api/webhooks/{webhook_id}/delete", WebhookDeleteHandler).Methods("POST")   // project.html
+
+	// shipment list in the profile
+	router.HandleFunc("/api/shipments/{shipment_id:[0-9]+}/rename", ShipmentRenameHandler).Methods("POST") // profile.html
+	router.HandleFunc("/api/shipments/{shipment_id:[0-9]+}/delete", ShipmentDeleteHandler).Methods("POST") // profile.html
+
+	// trash
+	router.HandleFunc("/shipments/trash", TrashHandlerTmpl).Methods("GET")                                   // trash.html
+	router.HandleFunc("/api/shipments/{shipment_id:[0-9]+}/restore", ShipmentRestoreHandler).Methods("POST") // trash.html
+	router.HandleFunc("/api/shipments/{shipment_id:[0-9]+}/purge", ShipmentPurgeHandler).Methods("POST")     // trash.html
+
+	// shipment details and search
+	router.HandleFunc("/shipments/search", SearchHandlerTmpl).Methods("GET")                                         // search.html
+	router.HandleFunc("/api/shipments/search", SearchAPIHandler).Methods("GET")                                      // search.html
+	router.HandleFunc("/shipments/{shipment_id:[0-9]+}", ShipmentHandlerTmpl).Methods("GET")                         // shipment.html
+	router.HandleFunc("/api/shipments/{shipment_id:[0-9]+}/annotations", ShipmentAnnotationsHandler).Methods("POST") // shipment.html
 
 	// shipment
-	router.HandleFunc("/shipment/model_class", ProgressClassHandlerTmpl).Methods("GET") // model_form_class.html
-	router.HandleFunc("/shipment/model_reg", ProgressRegHandlerTmpl).Methods("GET")     // model_form_reg.html
+	router.HandleFunc("/shipment/model_class", ProgressClassHandlerTmpl).Methods("GET")       // model_form_class.html
+	router.HandleFunc("/shipment/model_reg", ProgressRegHandlerTmpl).Methods("GET")           // model_form_reg.html
+	router.HandleFunc("/shipment/model_cluster", ProgressClusterHandlerTmpl).Methods("GET")   // model_form_cluster.html
+	router.HandleFunc("/shipment/model_forecast", ProgressForecastHandlerTmpl).Methods("GET") // model_form_forecast.html
+	router.HandleFunc("/shipment/model_anomaly", ProgressAnomalyHandlerTmpl).Methods("GET")   // model_form_anomaly.html
+
+	// cloning a run, the form is model_form_<model_type>.html
+	router.HandleFunc("/shipment/clone/{shipment_id}", CloneShipmentHandlerTmpl).Methods("GET")
 
 	// model_type is expected to be either class or reg
-	router.HandleFunc("/api/shipment/progress/{model_type}", ShipmentHandler) // progress_class.html
+	var shipmentHandler http.Handler = http.HandlerFunc(ShipmentHandler)
+	if config.ShipmentRateLimitEnabled {
+		shipmentHandler = rateMiddleware(&shipmentLimiter, ipKey, userKey)(shipmentHandler)
+	}
+	router.Handle("/api/shipment/progress/{model_type}", shipmentHandler) // progress_class.html
 	router.HandleFunc("/api/shipment/download_results/{shipment_id}", ShipmentDownloadHandler)
-	router.HandleFunc("/shipment/result/{shipment_id}", ResultShipmentHandler) // save_model_class.html / save_model_reg.html
+	router.HandleFunc("/shipment/result/{shipment_id}", ResultShipmentHandler) // save_model.html
+	router.HandleFunc("/api/shipment/result/{shipment_id}", ResultShipmentAPIHandler).Methods("GET")
+
+	// runs and exports orphaned by a restart are reaped at once, only after the timeout though:
+	// with several instances the younger ones may still be running on another instance
+	reapStaleShipments(ctx, time.Now().Add(-config.StaleShipmentTimeout))
+	failStaleDataExports(ctx, time.Now().Add(-config.StaleDataExportTimeout))
 
 	go func() {
 		err := server.ListenAndServe()
@@ -62,8 +173,33 @@ func Serve(ctx context.Context) {
 		}
 	}()
 
+	cleanupTicker := time.NewTicker(time.Minute * 10)
+	defer cleanupTicker.Stop()
+
+	schedulerTicker := time.NewTicker(config.SchedulerInterval)
+	defer schedulerTicker.Stop()
+
+	retentionTicker := time.NewTicker(config.RetentionInterval)
+	defer retentionTicker.Stop()
+
+	webhookTicker := time.NewTicker(config.WebhookInterval)
+	defer webhookTicker.Stop()
+
 	for {
 		select {
+		case <-cleanupTicker.C:
+			loginLimiter.Cleanup()
+			registerLimiter.Cleanup()
+			shipmentLimiter.Cleanup()
+			cleanupDataExports(ctx)
+			failStaleDataExports(ctx, time.Now().Add(-config.StaleDataExportTimeout))
+			reapStaleShipments(ctx, time.Now().Add(-config.StaleShipmentTimeout))
+		case <-schedulerTicker.C:
+			runDueSchedules(ctx)
+		case <-retentionTicker.C:
+			applyRetention(ctx)
+		case <-webhookTicker.C:
+			deliverDueWebhooks(ctx)
 		case <-ctx.Done():
 			log.Println("Shutting down server")
 			err := server.Shutdown(ctx)
@@ -2,7 +2,10 @@ package main
 
 import (
 	"feklistova/models"
+	"feklistova/python"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"html/template"
 	"io"
@@ -10,16 +13,16 @@ import (
 	"net/http"
 	"os"
 	"strconv"
-	"strings"
 	"time"
 
+	"github.com/gorilla/csrf"
 	"github.com/gorilla/mux"
 )
 
 func ShipmentHandler(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	modelType := vars["model_type"]
-	if modelType != "reg" && modelType != "class" {
+	if !python.ValidModelType(modelType) {
 		log.Printf("Restricted http request for shipment: incorrect request, model type %s is not allowed", modelType)
 		http.Error(w, "Error retrieving model type", http.StatusBadRequest)
 		return
@@ -38,288 +41,429 @@ func ShipmentHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	log.Printf("User %d requests new shipment", userID)
 
-	err := r.ParseMultipartForm(10 << 20) // 10 MB
+	ctxQuota, cancelQuota := context.WithTimeout(r.Context(), time.Second*5)
+	defer cancelQuota()
+
+	user, err := repo.GetUserByID(ctxQuota, userID)
+	if err != nil {
+		log.Printf("Failed to load user %d: %v", userID, err)
+		http.Error(w, "Unable to identify user", http.StatusInternalServerError)
+		return
+	}
+	quota, err := userQuota(ctxQuota, user)
+	if err != nil {
+		log.Printf("Failed to load quota of user %d: %v", userID, err)
+		http.Error(w, "Error creating shipment", http.StatusInternalServerError)
+		return
+	}
+	if quota.MaxDatasetBytes > 0 {
+		// the form also contains text fields, so a small reserve is left above the dataset limit
+		r.Body = http.MaxBytesReader(w, r.Body, quota.MaxDatasetBytes+1<<20)
+	}
+
+	err = r.ParseMultipartForm(10 << 20) // 10 MB
 	if err != nil {
 		log.Printf("Error parsing shipment form: %v", err)
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			http.Error(w, "Dataset exceeds the size limit of your plan", http.StatusRequestEntityTooLarge)
+			return
+		}
 		http.Error(w, "Unable to parse form data", http.StatusBadRequest)
 		return
 	}
 
 	// Extract individual form fields
-	projectName := r.FormValue("project_name")
 	algorithm := r.FormValue("model_type")
 	targetColumn := r.FormValue("target_column")
+	description, _ := python.LookupModelType(modelType)
+	if description.NeedsTarget && targetColumn == "" {
+		http.Error(w, "Target column must not be empty", http.StatusBadRequest)
+		return
+	}
+	if !description.NeedsTarget && !description.OptionalTarget {
+		targetColumn = ""
+	}
+	hyperparameters, err := python.ParseHyperparameters(algorithm, func(name string) string {
+		return r.FormValue("hp_" + name)
+	})
+	if err != nil {
+		log.Printf("Invalid hyperparameters in shipment form: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	columns, err := parseColumnSpec(r, description, targetColumn)
+	if err != nil {
+		log.Printf("Invalid column roles in shipment form: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	validation, err := parseValidationSettings(r, description, targetColumn)
+	if err != nil {
+		log.Printf("Invalid validation settings in shipment form: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var forecast *models.ForecastSettings
+	if modelType == "forecast" {
+		if forecast, err = parseForecastSettings(r, targetColumn); err != nil {
+			log.Printf("Invalid forecast settings in shipment form: %v", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), time.Second*5)
+	defer cancel()
+
+	// a cloned run may reuse the dataset of its parent instead of a new upload
+	var parentID int
+	var parentDataset *models.File
+	if value := r.FormValue("parent_shipment_id"); value != "" {
+		if parentID, err = strconv.Atoi(value); err != nil {
+			http.Error(w, "Invalid parent_shipment_id", http.StatusBadRequest)
+			return
+		}
+		var ok bool
+		if _, parentDataset, ok = parentShipment(ctx, w, userID, parentID); !ok {
+			return
+		}
+	}
 
 	// Handle file upload
-	file, fileHeader, err := r.FormFile("file")
+	dataset, err := shipmentDataset(r, parentDataset)
+	if errors.Is(err, http.ErrMissingFile) {
+		if parentID != 0 {
+			http.Error(w, "Dataset of the cloned shipment is no longer available, upload the dataset file", http.StatusBadRequest)
+		} else {
+			http.Error(w, "Dataset file is required", http.StatusBadRequest)
+		}
+		return
+	}
 	if err != nil {
 		log.Printf("Error saving uploaded file: %v", err)
 		http.Error(w, "Error retrieving file", http.StatusInternalServerError)
 		return
 	}
-	defer file.Close()
-	filenameParts := strings.Split(fileHeader.Filename, ".")
-	fileExtension := ""
-	if len(filenameParts) > 1 {
-		fileExtension = filenameParts[len(filenameParts)-1]
+	defer dataset.Close()
+
+	// columns are checked against the header before the run is created, so that a typo fails the form and not the training
+	referenced := []string{targetColumn}
+	for column := range columns {
+		referenced = append(referenced, column)
+	}
+	if forecast != nil {
+		referenced = append(referenced, forecast.TimeColumn, forecast.SeriesColumn)
+	}
+	if validation != nil {
+		referenced = append(referenced, validation.GroupColumn, validation.TimeColumn)
+	}
+	if err := validateDatasetColumns(dataset, referenced); err != nil {
+		log.Printf("Dataset of user %d does not match the shipment form: %v", userID, err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
 
-	log.Printf("Received request from user %d to create project: %s, model: %s, algorithm: %s, target col: %s",
-		userID, projectName, modelType, algorithm, targetColumn)
+	project, ok := shipmentProject(ctx, w, r, userID, modelType, targetColumn)
+	if !ok {
+		return
+	}
 
-	ctx, cancel := context.WithTimeout(r.Context(), time.Second*5)
-	defer cancel()
+	log.Printf("Received request from user %d to create run in project %d: %s, model: %s, algorithm: %s, target col: %s, hyperparameters: %v",
+		userID, project.ProjectID, project.Name, modelType, algorithm, targetColumn, hyperparameters)
 
 	// Creating shipment
 	shipment := &models.Shipment{
-		UserID:       userID,
-		ProjectName:  projectName,
-		ModelType:    modelType,
-		Algorithm:    algorithm,
-		TargetColumn: targetColumn,
-		Status:       "accepted",
-		Timestamp:    time.Now(),
+		UserID:          userID,
+		ProjectID:       project.ProjectID,
+		ParentID:        parentID,
+		ProjectName:     project.Name,
+		ModelType:       modelType,
+		Algorithm:       algorithm,
+		TargetColumn:    targetColumn,
+		Hyperparameters: hyperparameters,
+		Forecast:        forecast,
+		Validation:      validation,
+		Columns:         columns,
+		Status:          "accepted",
+		Timestamp:       time.Now(),
+	}
+	if !createShipmentWithinQuota(ctx, w, r, shipment, quota, dataset.Size) {
+		return
 	}
-	if err := repo.CreateShipment(ctx, shipment); err != nil {
-		log.Printf("Error creating shipment: %v", err)
-		http.Error(w, "Error creating shipment", http.StatusInternalServerError)
+	recordAudit(r, userID, auditShipmentCreate, fmt.Sprintf("shipment:%d", shipment.ShipmentID), outcomeSuccess)
+
+	if err := trainShipment(r.Context(), shipment, dataset); err != nil {
+		log.Printf("Shipment %d was not trained: %v", shipment.ShipmentID, err)
+		http.Error(w, "Error running shipment", http.StatusInternalServerError)
 		return
 	}
 
+	// Redirect to another page where results will be presented
+	http.Redirect(w, r, "/shipment/result/"+strconv.Itoa(shipment.ShipmentID), http.StatusFound)
+}
+
+// trainShipment saves the dataset of an accepted shipment, trains the model and stores it with its metrics.
+// The final status of the shipment is always saved, files of unsuccessful shipments are removed.
+func trainShipment(ctx context.Context, shipment *models.Shipment, dataset *datasetSource) (err error) {
 	defer func() {
 		if rec := recover(); rec != nil {
 			shipment.Status = "failed"
+			err = fmt.Errorf("model training panicked: %v", rec)
 		} else if err != nil {
 			shipment.Status = "denied"
 		} else {
 			shipment.Status = "finished"
 		}
 
-		ctxFinal, cancelFinal := context.WithTimeout(r.Context(), time.Minute*5)
+		ctxFinal, cancelFinal := context.WithTimeout(context.WithoutCancel(ctx), time.Minute*5)
 		defer cancelFinal()
 
-		if err := repo.UpdateShipmentStatus(ctxFinal, shipment); err != nil {
+		if err := saveShipmentStatus(ctxFinal, shipment); err != nil {
 			log.Fatal(err)
 		}
 
 		log.Printf("Shipment %d status changed to %s", shipment.ShipmentID, shipment.Status)
 
 		if shipment.Status == "failed" || shipment.Status == "denied" {
-			// clearing files
-			files, err := repo.GetDownloadedFilesByShipmentID(ctxFinal, shipment.ShipmentID)
-			if err != nil {
-				log.Printf("Failed to get download files for shipment ID %d: %v", shipment.ShipmentID, err)
-				return
-			}
-			for _, file := range files {
-				if err := fileRepo.DeleteDownloadedFile(strconv.Itoa(file.FileID)); err != nil {
-					log.Printf("Failed to delete file with ID %d: %v", file.FileID, err)
-				}
-				if err := repo.DeleteFile(ctxFinal, file.FileID, true); err != nil {
-					log.Fatalf("Failed to forget file with ID %d: %v", file.FileID, err)
-				}
-			}
-
-			files, err = repo.GetUploadedFilesByShipmentID(ctxFinal, shipment.ShipmentID)
-			if err != nil {
-				log.Printf("Failed to get upload files for shipment ID %d: %v", shipment.ShipmentID, err)
-				return
-			}
-			for _, file := range files {
-				if err := fileRepo.DeleteUploadedFile(strconv.Itoa(file.FileID)); err != nil {
-					log.Printf("Failed to delete file with ID %d: %v", file.FileID, err)
-				}
-				if err := repo.DeleteFile(ctxFinal, file.FileID, false); err != nil {
-					log.Fatalf("Failed to forget file with ID %d: %v", file.FileID, err)
-				}
-			}
+			removeShipmentFiles(ctxFinal, shipment.ShipmentID)
 		}
 	}()
 
+	ctxSaving, cancelSaving := context.WithTimeout(ctx, time.Second*5)
+	defer cancelSaving()
+
+	emitShipmentEvent(ctxSaving, shipment)
+
 	downloadedFile := &models.File{
-		ShipmentID: shipment.ShipmentID,
-		Timestamp:  time.Now(),
+		ShipmentID:   shipment.ShipmentID,
+		OriginalName: dataset.Name,
+		Size:         dataset.Size,
+		Timestamp:    time.Now(),
 	}
-	if err := repo.CreateFile(ctx, downloadedFile, true); err != nil {
-		log.Printf("Error creating file: %v", err)
-		http.Error(w, "Error creating downloading file", http.StatusInternalServerError)
-		return
+	if err := repo.CreateFile(ctxSaving, downloadedFile, true); err != nil {
+		return fmt.Errorf("error creating downloading file: %w", err)
 	}
 
-	newFileName := fmt.Sprintf("%d.%s", downloadedFile.FileID, fileExtension)
+	newFileName := fmt.Sprintf("%d.%s", downloadedFile.FileID, dataset.Extension)
 	log.Printf("Saving downloaded file as %s", newFileName)
-	if err := fileRepo.SaveDownloadedFile(newFileName, file); err != nil {
-		log.Printf("Error saving file: %v", err)
-		http.Error(w, "Error saving downloaded file", http.StatusInternalServerError)
-		return
+	if err := fileRepo.SaveDownloadedFile(newFileName, dataset); err != nil {
+		return fmt.Errorf("error saving downloaded file: %w", err)
 	}
 
 	downloadedFilePath := fileRepo.GetDownloadedFilePath(newFileName)
-	if err := repo.UpdateFilePathByID(ctx, downloadedFile.FileID, downloadedFilePath, true); err != nil {
-		log.Printf("Error updating file: %v", err)
-		http.Error(w, "Error saving downloaded file", http.StatusInternalServerError)
-		return
+	if err := repo.UpdateFilePathByID(ctxSaving, downloadedFile.FileID, downloadedFilePath, true); err != nil {
+		return fmt.Errorf("error updating downloaded file: %w", err)
 	}
 
 	shipment.Status = "in progress"
-	if err := repo.UpdateShipmentStatus(ctx, shipment); err != nil {
-		log.Println(err)
-		return
+	if err := saveShipmentStatus(ctxSaving, shipment); err != nil {
+		return err
 	}
 
 	uploadedFilePath := fileRepo.GetUploadedFilePath(strconv.Itoa(downloadedFile.FileID))
 	// Start the Python model process
 	log.Printf("Running model for shipment %d", shipment.ShipmentID)
-	metricsDict, err := pyModel.RunModel(
-		modelType,
-		algorithm,
-		targetColumn,
+	result, err := pyModel.RunModel(
+		shipment.ModelType,
+		shipment.Algorithm,
+		shipment.TargetColumn,
 		downloadedFilePath,
 		uploadedFilePath,
+		shipment.Hyperparameters,
+		python.TrainingOptions{Forecast: shipment.Forecast, Columns: shipment.Columns, Validation: shipment.Validation},
 	)
 	if err != nil {
-		log.Printf("Error running python model: %v", err)
 		os.Remove(uploadedFilePath)
-		http.Error(w, "Error starting Python model", http.StatusInternalServerError)
-		return
+		return fmt.Errorf("error running python model: %w", err)
 	}
 
-	metrics := models.ParseMetricsToModelMetrics(downloadedFile.FileID, metricsDict)
+	metrics := models.ParseMetricsToModelMetrics(downloadedFile.FileID, result.Metrics)
 	modelOutputFile := &models.File{
 		FilePath:   uploadedFilePath,
 		ShipmentID: shipment.ShipmentID,
 		Timestamp:  time.Now(),
 	}
-	ctxSaving, cancelSaving := context.WithTimeout(r.Context(), time.Second*5)
-	defer cancelSaving()
+	if info, err := os.Stat(uploadedFilePath); err == nil {
+		modelOutputFile.Size = info.Size()
+	}
+	ctxModel, cancelModel := context.WithTimeout(ctx, time.Second*5)
+	defer cancelModel()
 
-	if err := repo.CreateModelFile(ctxSaving, modelOutputFile, metrics); err != nil {
-		log.Printf("Error creating model file: %v", err)
-		http.Error(w, "Error saving model file", http.StatusInternalServerError)
-		return
+	if err := repo.CreateModelFile(ctxModel, modelOutputFile, metrics, result.ModelDetails); err != nil {
+		return fmt.Errorf("error creating model file: %w", err)
 	}
 	log.Printf("Model file successfully updated in the database: %s", uploadedFilePath)
-
-	// Redirect to another page where results will be presented
-	http.Redirect(w, r, "/shipment/result/"+strconv.Itoa(shipment.ShipmentID), http.StatusFound)
+	return nil
 }
 
-func ResultShipmentHandler(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	shipmentID, err := strconv.Atoi(vars["shipment_id"])
-	if err != nil {
-		http.Error(w, "Invalid shipment ID", http.StatusBadRequest)
-		return
+// saveShipmentStatus saves the status of the shipment, notifies the project webhooks
+// and emails the owner about the result if they asked for it
+func saveShipmentStatus(ctx context.Context, shipment *models.Shipment) error {
+	if err := repo.UpdateShipmentStatus(ctx, shipment); err != nil {
+		return err
 	}
-	log.Printf("Results for shipment %d requested", shipmentID)
-
-	ctx, cancel := context.WithTimeout(r.Context(), time.Second*5)
-	defer cancel()
+	emitShipmentEvent(ctx, shipment)
+	notifyShipmentStatus(ctx, shipment)
+	return nil
+}
 
-	shipment, err := repo.GetShipmentByID(ctx, shipmentID)
+// removeShipmentFiles deletes the dataset and the model of an unsuccessful shipment
+func removeShipmentFiles(ctx context.Context, shipmentID int) {
+	files, err := repo.GetDownloadedFilesByShipmentID(ctx, shipmentID)
 	if err != nil {
-		log.Printf("Failed to load shipment by ID %d: %v", shipmentID, err)
-		http.Error(w, "Invalid shipment ID", http.StatusBadRequest)
+		log.Printf("Failed to get download files for shipment ID %d: %v", shipmentID, err)
 		return
 	}
+	for _, file := range files {
+		if err := fileRepo.DeleteDownloadedFile(strconv.Itoa(file.FileID)); err != nil {
+			log.Printf("Failed to delete file with ID %d: %v", file.FileID, err)
+		}
+		if err := repo.DeleteFile(ctx, file.FileID, true); err != nil {
+			log.Fatalf("Failed to forget file with ID %d: %v", file.FileID, err)
+		}
+	}
 
-	uploadedFiles, err := repo.GetUploadedFilesByShipmentID(ctx, shipmentID)
+	files, err = repo.GetUploadedFilesByShipmentID(ctx, shipmentID)
 	if err != nil {
 		log.Printf("Failed to get upload files for shipment ID %d: %v", shipmentID, err)
-		http.Error(w, "Failed to get upload files", http.StatusInternalServerError)
-		return
-	}
-	if len(uploadedFiles) != 1 {
-		log.Printf("Expected length of files to be 1, got %d", len(uploadedFiles))
-	}
-	metricsDict, err := repo.GetMetricsByFileID(ctx, uploadedFiles[0].FileID)
-	if err != nil {
-		log.Printf("Failed to retrieve metrics by file ID %d: %v", uploadedFiles[0].FileID, err)
-		http.Error(w, "Unable to fetch metrics", http.StatusBadRequest)
 		return
 	}
-	log.Printf("Results for shipment %d requested: %s", shipmentID, uploadedFiles[0].FilePath)
-	for name, value := range metricsDict {
-		log.Printf("	%s: %.2f\n", name, value)
-	}
-	if shipment.ModelType == "reg" {
-		shipmentRegHandler(w, r, shipmentID, metricsDict)
-	} else if shipment.ModelType == "class" {
-		shipmentClassHandler(w, r, shipmentID, metricsDict)
-	} else {
-		log.Printf("Unknown ModelType for shipment ID %d: %s", shipmentID, shipment.ModelType)
-		http.Error(w, "Failed to parse ModelType", http.StatusInternalServerError)
-		return
+	for _, file := range files {
+		if err := fileRepo.DeleteUploadedFile(strconv.Itoa(file.FileID)); err != nil {
+			log.Printf("Failed to delete file with ID %d: %v", file.FileID, err)
+		}
+		if err := repo.DeleteFile(ctx, file.FileID, false); err != nil {
+			log.Fatalf("Failed to forget file with ID %d: %v", file.FileID, err)
+		}
 	}
 }
 
-type RegHandlerMetrics struct {
-	ShipmentID string
-	R2         string
-	MAE        string
+// Заголовки страницы результатов для типов моделей
+var resultTitles = map[string]string{
+	"class":    "Оценка качества классификации",
+	"reg":      "Оценка качества регрессии",
+	"cluster":  "Оценка качества кластеризации",
+	"forecast": "Оценка качества прогноза на истории",
+	"anomaly":  "Результаты поиска аномалий",
 }
 
-type ClassHandlerMetrics struct {
-	ShipmentID string
-	Precision  string
-	Recall     string
-	F1Score    string
+// ResultPage содержит все метрики обученной модели и подробную оценку классификатора
+type ResultPage struct {
+	CSRFField  template.HTML                `json:"-"`
+	ShipmentID int                          `json:"shipment_id"`
+	ModelType  string                       `json:"model_type"`
+	Title      string                       `json:"-"`
+	ResultFile string                       `json:"-"` // имя скачиваемого файла результатов
+	Metrics    []ResultMetric               `json:"metrics"`
+	Report     *models.ClassificationReport `json:"classification_report,omitempty"` // nil для регрессии и моделей, обученных до появления отчета
+	Forecast   *models.Forecast             `json:"forecast,omitempty"`              // только для прогнозирования
+	Schema     []models.DatasetColumn       `json:"schema,omitempty"`                // только для классификации и регрессии
+	Validation *models.ValidationSettings   `json:"validation,omitempty"`            // nil для запусков без настроек проверки
 }
 
-func shipmentClassHandler(w http.ResponseWriter, r *http.Request, shipmentID int, metricsDict map[string]float64) {
-	r.ParseForm()
-
-	modelParams := ClassHandlerMetrics{
-		ShipmentID: fmt.Sprintf("%d", shipmentID),
-		Precision:  fmt.Sprintf("%.2f", metricsDict["Precision"]),
-		Recall:     fmt.Sprintf("%.2f", metricsDict["Recall"]),
-		F1Score:    fmt.Sprintf("%.2f", metricsDict["F1-score"]),
-	}
-
-	tmpl, err := template.ParseFiles("web/save_model_class.html")
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+func ResultShipmentHandler(w http.ResponseWriter, r *http.Request) {
+	result, ok := shipmentResult(w, r)
+	if !ok {
 		return
 	}
+	result.CSRFField = csrf.TemplateField(r)
+	if result.Title == "" {
+		result.Title = "Оценка качества модели"
+	}
+	renderTmpl(w, "web/save_model.html", result)
+}
 
-	err = tmpl.Execute(w, modelParams)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+// ResultShipmentAPIHandler returns the metrics of the trained model with their metadata as JSON
+func ResultShipmentAPIHandler(w http.ResponseWriter, r *http.Request) {
+	result, ok := shipmentResult(w, r)
+	if !ok {
 		return
 	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		log.Printf("Failed to send results of shipment %d: %v", result.ShipmentID, err)
+	}
 }
 
-func shipmentRegHandler(w http.ResponseWriter, r *http.Request, shipmentID int, metricsDict map[string]float64) {
-	r.ParseForm()
-
-	modelParams := RegHandlerMetrics{
-		ShipmentID: fmt.Sprintf("%d", shipmentID),
-		R2:         fmt.Sprintf("%.2f", metricsDict["R2 Score"]),
-		MAE:        fmt.Sprintf("%.2f", metricsDict["MAE"]),
+// shipmentResult loads whatever metrics the model of the shipment has. On failure it writes the response itself.
+func shipmentResult(w http.ResponseWriter, r *http.Request) (*ResultPage, bool) {
+	shipment, ok := userShipment(w, r)
+	if !ok {
+		return nil, false
 	}
+	log.Printf("Results for shipment %d requested", shipment.ShipmentID)
+
+	ctx, cancel := context.WithTimeout(r.Context(), time.Second*5)
+	defer cancel()
 
-	tmpl, err := template.ParseFiles("web/save_model_reg.html")
+	uploadedFiles, err := repo.GetUploadedFilesByShipmentID(ctx, shipment.ShipmentID)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+		log.Printf("Failed to get upload files for shipment ID %d: %v", shipment.ShipmentID, err)
+		http.Error(w, "Failed to get upload files", http.StatusInternalServerError)
+		return nil, false
+	}
+	if len(uploadedFiles) == 0 {
+		http.Error(w, "Shipment has no trained model", http.StatusNotFound)
+		return nil, false
 	}
+	if len(uploadedFiles) != 1 {
+		log.Printf("Expected length of files to be 1, got %d", len(uploadedFiles))
+	}
+	fileID := uploadedFiles[0].FileID
 
-	err = tmpl.Execute(w, modelParams)
+	metricsDict, err := repo.GetMetricsByFileID(ctx, fileID)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+		log.Printf("Failed to retrieve metrics by file ID %d: %v", fileID, err)
+		http.Error(w, "Unable to fetch metrics", http.StatusInternalServerError)
+		return nil, false
 	}
+	report, err := repo.GetClassificationReport(ctx, fileID)
+	if err != nil {
+		log.Printf("Failed to retrieve classification report by file ID %d: %v", fileID, err)
+		http.Error(w, "Unable to fetch metrics", http.StatusInternalServerError)
+		return nil, false
+	}
+	forecast, err := repo.GetForecast(ctx, fileID)
+	if err != nil {
+		log.Printf("Failed to retrieve forecast by file ID %d: %v", fileID, err)
+		http.Error(w, "Unable to fetch metrics", http.StatusInternalServerError)
+		return nil, false
+	}
+	schema, err := repo.GetDatasetSchema(ctx, fileID)
+	if err != nil {
+		log.Printf("Failed to retrieve dataset schema by file ID %d: %v", fileID, err)
+		http.Error(w, "Unable to fetch metrics", http.StatusInternalServerError)
+		return nil, false
+	}
+	metricStd, err := repo.GetMetricStdByFileID(ctx, fileID)
+	if err != nil {
+		log.Printf("Failed to retrieve metric deviation by file ID %d: %v", fileID, err)
+		http.Error(w, "Unable to fetch metrics", http.StatusInternalServerError)
+		return nil, false
+	}
+
+	description, _ := python.LookupModelType(shipment.ModelType)
+	return &ResultPage{
+		ResultFile: description.ResultFile,
+		ShipmentID: shipment.ShipmentID,
+		ModelType:  shipment.ModelType,
+		Title:      resultTitles[shipment.ModelType],
+		Metrics:    resultMetrics(metricsDict, metricStd),
+		Report:     report,
+		Forecast:   forecast,
+		Schema:     schema,
+		Validation: shipment.Validation,
+	}, true
 }
 
 func ShipmentDownloadHandler(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	shipmentIDStr := vars["shipment_id"]
-	shipmentID, err := strconv.Atoi(shipmentIDStr)
-	if err != nil {
-		log.Printf("Requested to download results with invalid shipment ID %s: %v", shipmentIDStr, err)
-		http.Error(w, "Invalid shipment_id", http.StatusBadRequest)
+	// the results of clustering and anomaly detection contain the whole uploaded dataset, only the owner may get them
+	shipment, ok := userShipment(w, r)
+	if !ok {
 		return
 	}
+	shipmentID := shipment.ShipmentID
 
 	ctx, cancel := context.WithTimeout(r.Context(), time.Second*5)
 	defer cancel()
@@ -333,12 +477,23 @@ func ShipmentDownloadHandler(w http.ResponseWriter, r *http.Request) {
 	if len(uploadedFiles) != 1 {
 		log.Printf("Expected length of files to be 1, got %d", len(uploadedFiles))
 	}
+	if len(uploadedFiles) == 0 {
+		http.Error(w, "File not found", http.StatusNotFound)
+		return
+	}
+	// clustering and other tasks without a model to save produce a table, it is named after the model type
+	fileName := "results"
+	if description, ok := python.LookupModelType(shipment.ModelType); ok {
+		fileName = description.ResultFile
+	}
 	filePath := uploadedFiles[0].FilePath
 	log.Printf("Results are being sent to download for shipment ID %d: %s", shipmentID, filePath)
+	target := fmt.Sprintf("shipment:%d", shipmentID)
 
 	_, err = os.Stat(filePath)
 	if os.IsNotExist(err) {
 		log.Printf("Results not found for shipment ID %d: %s", shipmentID, filePath)
+		recordAudit(r, shipment.UserID, auditShipmentDownload, target, outcomeFailure)
 		http.Error(w, "File not found", http.StatusNotFound)
 		return
 	}
@@ -346,12 +501,14 @@ func ShipmentDownloadHandler(w http.ResponseWriter, r *http.Request) {
 	file, err := os.Open(filePath)
 	if err != nil {
 		log.Printf("Failed to open results for shipment ID %d: %s", shipmentID, filePath)
+		recordAudit(r, shipment.UserID, auditShipmentDownload, target, outcomeFailure)
 		http.Error(w, "Failed to open file", http.StatusInternalServerError)
 		return
 	}
 	defer file.Close()
+	recordAudit(r, shipment.UserID, auditShipmentDownload, target, outcomeSuccess)
 
-	w.Header().Set("Content-Disposition", "attachment; filename=results")
+	w.Header().Set("Content-Disposition", "attachment; filename="+fileName)
 	w.Header().Set("Content-Type", "application/octet-stream")
 
 	_, err = io.Copy(w, file)
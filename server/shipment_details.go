@@ -0,0 +1,168 @@
+package main
+
+import (
+	"feklistova/models"
+	"bytes"
+	"context"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gorilla/csrf"
+	"github.com/gorilla/mux"
+	"github.com/yuin/goldmark"
+)
+
+const (
+	maxShipmentTags = 20
+	maxTagLength    = 50
+	maxNoteLength   = 10000
+)
+
+// ShipmentPage содержит параметры запуска, его метрики, теги и заметку
+type ShipmentPage struct {
+	CSRFField template.HTML
+	Shipment  *models.Shipment
+	Metrics   map[string]float64
+	TagsValue string
+	NoteHTML  template.HTML
+}
+
+func ShipmentHandlerTmpl(w http.ResponseWriter, r *http.Request) {
+	shipment, ok := userShipment(w, r)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), time.Second*5)
+	defer cancel()
+
+	tags, err := repo.GetShipmentTags(ctx, []int{shipment.ShipmentID})
+	if err != nil {
+		log.Printf("Failed to load tags of shipment %d: %v", shipment.ShipmentID, err)
+		http.Error(w, "Error loading shipment", http.StatusInternalServerError)
+		return
+	}
+	shipment.Tags = tags[shipment.ShipmentID]
+	metrics, err := repo.GetMetricsByShipmentID(ctx, shipment.ShipmentID)
+	if err != nil {
+		log.Printf("Failed to load metrics of shipment %d: %v", shipment.ShipmentID, err)
+		http.Error(w, "Error loading shipment", http.StatusInternalServerError)
+		return
+	}
+	noteHTML, err := renderMarkdown(shipment.Note)
+	if err != nil {
+		log.Printf("Failed to render note of shipment %d: %v", shipment.ShipmentID, err)
+	}
+
+	renderTmpl(w, "web/shipment.html", ShipmentPage{
+		CSRFField: csrf.TemplateField(r),
+		Shipment:  shipment,
+		Metrics:   metrics,
+		TagsValue: strings.Join(shipment.Tags, ", "),
+		NoteHTML:  noteHTML,
+	})
+}
+
+// ShipmentAnnotationsHandler saves the tags and the note of the shipment
+func ShipmentAnnotationsHandler(w http.ResponseWriter, r *http.Request) {
+	shipment, ok := userShipment(w, r)
+	if !ok {
+		return
+	}
+
+	if err := r.ParseMultipartForm(10 << 20); err != nil {
+		log.Println(err)
+		http.Error(w, "Error parsing form data", http.StatusBadRequest)
+		return
+	}
+	tags, err := parseTags(r.FormValue("tags"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	note := strings.TrimSpace(r.FormValue("note"))
+	if utf8.RuneCountInString(note) > maxNoteLength {
+		http.Error(w, fmt.Sprintf("Note must not be longer than %d characters", maxNoteLength), http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), time.Second*5)
+	defer cancel()
+
+	if err := repo.SetShipmentTags(ctx, shipment.ShipmentID, tags); err != nil {
+		log.Printf("Failed to save tags of shipment %d: %v", shipment.ShipmentID, err)
+		http.Error(w, "Error saving tags", http.StatusInternalServerError)
+		return
+	}
+	if err := repo.UpdateShipmentNote(ctx, shipment.ShipmentID, note); err != nil {
+		log.Printf("Failed to save note of shipment %d: %v", shipment.ShipmentID, err)
+		http.Error(w, "Error saving note", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("User %d updated tags and note of shipment %d", shipment.UserID, shipment.ShipmentID)
+
+	http.Redirect(w, r, fmt.Sprintf("/shipments/%d", shipment.ShipmentID), http.StatusSeeOther)
+}
+
+// userShipment loads the shipment from the URL and checks that it belongs to the logged in user.
+// On failure it writes the response itself.
+func userShipment(w http.ResponseWriter, r *http.Request) (*models.Shipment, bool) {
+	if !IsAuthorized(r) {
+		http.Redirect(w, r, "/users/enter", http.StatusSeeOther)
+		return nil, false
+	}
+	userID := GetUserID(r)
+
+	shipmentID, err := strconv.Atoi(mux.Vars(r)["shipment_id"])
+	if err != nil {
+		http.Error(w, "Invalid shipment_id", http.StatusBadRequest)
+		return nil, false
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), time.Second*5)
+	defer cancel()
+
+	shipment, err := repo.GetShipmentByID(ctx, shipmentID)
+	if err != nil || shipment.UserID != userID {
+		log.Printf("User %d requested unknown shipment %d: %v", userID, shipmentID, err)
+		http.Error(w, "Shipment not found", http.StatusNotFound)
+		return nil, false
+	}
+	return shipment, true
+}
+
+// parseTags splits comma separated tags, tags are compared in lower case
+func parseTags(value string) ([]string, error) {
+	var tags []string
+	seen := make(map[string]bool)
+	for _, tag := range strings.Split(value, ",") {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || seen[tag] {
+			continue
+		}
+		if utf8.RuneCountInString(tag) > maxTagLength {
+			return nil, fmt.Errorf("tag %q is longer than %d characters", tag, maxTagLength)
+		}
+		seen[tag] = true
+		tags = append(tags, tag)
+	}
+	if len(tags) > maxShipmentTags {
+		return nil, fmt.Errorf("a shipment can have at most %d tags", maxShipmentTags)
+	}
+	return tags, nil
+}
+
+// renderMarkdown converts a note to HTML. Raw HTML and dangerous links in the note are dropped by goldmark.
+func renderMarkdown(source string) (template.HTML, error) {
+	var buf bytes.Buffer
+	if err := goldmark.Convert([]byte(source), &buf); err != nil {
+		return "", err
+	}
+	return template.HTML(buf.String()), nil
+}
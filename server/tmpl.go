@@ -1,23 +1,51 @@
 package main
 
 import (
-	"io"
+	"feklistova/python"
+	"context"
+	"html/template"
+	"log"
 	"net/http"
-	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/gorilla/csrf"
 )
 
+// templateData содержит данные, общие для всех HTML шаблонов
+type templateData struct {
+	CSRFField template.HTML
+}
+
 func handlerTmpl(w http.ResponseWriter, r *http.Request, template_file string) {
-	file, err := os.Open(template_file)
+	renderTmpl(w, template_file, templateData{CSRFField: csrf.TemplateField(r)})
+}
+
+// Функции, доступные в шаблонах страниц
+var templateFuncs = template.FuncMap{
+	"modelTypeTitle":  python.ModelTypeTitle,
+	"validationTitle": python.ValidationStrategyTitle,
+}
+
+// Общие части форм обучения, их подключают все файлы model_form_<тип>.html
+const modelFormPartial = "web/partials/model_form.html"
+
+// renderTmpl отдает HTML шаблон, заполненный переданными данными.
+// В partials передаются файлы с общими частями, которые шаблон подключает через {{ template }}
+func renderTmpl(w http.ResponseWriter, template_file string, data interface{}, partials ...string) {
+	files := append([]string{template_file}, partials...)
+	tmpl, err := template.New(filepath.Base(template_file)).Funcs(templateFuncs).ParseFiles(files...)
 	if err != nil {
+		log.Printf("Error parsing template %s: %v", template_file, err)
 		http.Error(w, "Файл не найден", http.StatusInternalServerError)
 		return
 	}
-	defer file.Close()
 
-	w.Header().Set("Content-Type", "text/html")
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
 
-	_, err = io.Copy(w, file)
+	err = tmpl.Execute(w, data)
 	if err != nil {
+		log.Printf("Error executing template %s: %v", template_file, err)
 		http.Error(w, "Ошибка при чтении файла", http.StatusInternalServerError)
 		return
 	}
@@ -35,30 +63,81 @@ func RegisterHandlerTmpl(w http.ResponseWriter, r *http.Request) {
 	handlerTmpl(w, r, "web/registration.html")
 }
 
-func ProfileHandlerTmpl(w http.ResponseWriter, r *http.Request) {
-	handlerTmpl(w, r, "web/profile.html")
-}
-
-func ProgressClassHandlerTmpl(w http.ResponseWriter, r *http.Request) {
-	if IsAuthorized(r) {
-		handlerTmpl(w, r, "web/model_form_class.html")
+func LoginTwoFactorHandlerTmpl(w http.ResponseWriter, r *http.Request) {
+	if _, ok := pendingTwoFactorUserID(r); ok {
+		handlerTmpl(w, r, "web/enter_2fa.html")
 	} else {
 		http.Redirect(w, r, "/users/enter", http.StatusSeeOther)
 	}
 }
 
-func ProgressRegHandlerTmpl(w http.ResponseWriter, r *http.Request) {
-	if IsAuthorized(r) {
-		handlerTmpl(w, r, "web/model_form_reg.html")
-	} else {
+func ProfileHandlerTmpl(w http.ResponseWriter, r *http.Request) {
+	if !IsAuthorized(r) {
 		http.Redirect(w, r, "/users/enter", http.StatusSeeOther)
+		return
+	}
+	userID := GetUserID(r)
+
+	ctx, cancel := context.WithTimeout(r.Context(), time.Second*5)
+	defer cancel()
+
+	user, err := repo.GetUserByID(ctx, userID)
+	if err != nil {
+		log.Printf("Failed to load user %d: %v", userID, err)
+		http.Error(w, "Unable to identify user", http.StatusInternalServerError)
+		return
 	}
+	quota, err := userQuota(ctx, user)
+	if err != nil {
+		log.Printf("Failed to load quota of user %d: %v", userID, err)
+		http.Error(w, "Error loading profile", http.StatusInternalServerError)
+		return
+	}
+	usage, err := repo.GetQuotaUsage(ctx, userID, monthStart(time.Now()))
+	if err != nil {
+		log.Printf("Failed to get quota usage of user %d: %v", userID, err)
+		http.Error(w, "Error loading profile", http.StatusInternalServerError)
+		return
+	}
+
+	shipments, err := profileShipments(ctx, userID, profilePageNumber(r))
+	if err != nil {
+		log.Printf("Failed to load shipments of user %d: %v", userID, err)
+		http.Error(w, "Error loading profile", http.StatusInternalServerError)
+		return
+	}
+
+	page := ProfilePage{
+		CSRFField:   csrf.TemplateField(r),
+		Username:    user.Username,
+		Email:       user.Email,
+		Plan:        user.Plan,
+		Meters:      usageMeters(quota, usage),
+		Shipments:   shipments,
+		ProjectsTab: r.URL.Query().Get("tab") == "projects",
+	}
+	if quota.MaxDatasetBytes > 0 {
+		page.MaxDataset = formatBytes(quota.MaxDatasetBytes)
+	}
+	renderTmpl(w, "web/profile.html", page)
+}
+
+func ProgressClassHandlerTmpl(w http.ResponseWriter, r *http.Request) {
+	modelFormTmpl(w, r, "web/model_form_class.html", "class")
+}
+
+func ProgressRegHandlerTmpl(w http.ResponseWriter, r *http.Request) {
+	modelFormTmpl(w, r, "web/model_form_reg.html", "reg")
+}
+
+func ProgressClusterHandlerTmpl(w http.ResponseWriter, r *http.Request) {
+	modelFormTmpl(w, r, "web/model_form_cluster.html", "cluster")
 }
 
-func ResultClassHandlerTmpl(w http.ResponseWriter, r *http.Request) {
-	handlerTmpl(w, r, "web/save_model_class.html")
+func ProgressForecastHandlerTmpl(w http.ResponseWriter, r *http.Request) {
+	modelFormTmpl(w, r, "web/model_form_forecast.html", "forecast")
 }
 
-func ResultRegHandlerTmpl(w http.ResponseWriter, r *http.Request) {
-	handlerTmpl(w, r, "web/save_model_reg.html")
+func ProgressAnomalyHandlerTmpl(w http.ResponseWriter, r *http.Request) {
+	modelFormTmpl(w, r, "web/model_form_anomaly.html", "anomaly")
 }
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"feklistova/config"
+	"feklistova/models"
+	"context"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/csrf"
+	"github.com/gorilla/mux"
+)
+
+type TrashPage struct {
+	CSRFField            template.HTML
+	Shipments            []TrashedShipment
+	TrashRetentionDays   int
+	DatasetRetentionDays int // 0, если датасеты хранятся бессрочно
+}
+
+// TrashedShipment описывает запуск в корзине и время его окончательного удаления
+type TrashedShipment struct {
+	models.Shipment
+	PurgeAt time.Time
+}
+
+func TrashHandlerTmpl(w http.ResponseWriter, r *http.Request) {
+	if !IsAuthorized(r) {
+		http.Redirect(w, r, "/users/enter", http.StatusSeeOther)
+		return
+	}
+	userID := GetUserID(r)
+
+	ctx, cancel := context.WithTimeout(r.Context(), time.Second*5)
+	defer cancel()
+
+	shipments, err := repo.GetTrashedShipmentsByUserID(ctx, userID)
+	if err != nil {
+		log.Printf("Failed to load trash of user %d: %v", userID, err)
+		http.Error(w, "Error loading trash", http.StatusInternalServerError)
+		return
+	}
+
+	page := TrashPage{
+		CSRFField:            csrf.TemplateField(r),
+		TrashRetentionDays:   int(config.TrashRetention.Hours() / 24),
+		DatasetRetentionDays: int(config.DatasetRetention.Hours() / 24),
+	}
+	for _, shipment := range shipments {
+		page.Shipments = append(page.Shipments, TrashedShipment{
+			Shipment: shipment,
+			PurgeAt:  shipment.DeletedAt.Add(config.TrashRetention),
+		})
+	}
+	renderTmpl(w, "web/trash.html", page)
+}
+
+// ShipmentRestoreHandler returns the shipment from the trash
+func ShipmentRestoreHandler(w http.ResponseWriter, r *http.Request) {
+	shipment, ok := trashedShipment(w, r)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), time.Second*5)
+	defer cancel()
+
+	if err := repo.RestoreShipment(ctx, shipment.ShipmentID); err != nil {
+		log.Printf("Failed to restore shipment %d: %v", shipment.ShipmentID, err)
+		http.Error(w, "Error restoring shipment", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("User %d restored shipment %d from the trash", shipment.UserID, shipment.ShipmentID)
+	recordAudit(r, shipment.UserID, auditShipmentRestore, fmt.Sprintf("shipment:%d", shipment.ShipmentID), outcomeSuccess)
+
+	http.Redirect(w, r, "/shipments/trash", http.StatusSeeOther)
+}
+
+// ShipmentPurgeHandler deletes the shipment from the trash for good without waiting for the retention period
+func ShipmentPurgeHandler(w http.ResponseWriter, r *http.Request) {
+	shipment, ok := trashedShipment(w, r)
+	if !ok {
+		return
+	}
+
+	if err := purgeShipment(r.Context(), shipment.ShipmentID); err != nil {
+		log.Printf("Failed to purge shipment %d: %v", shipment.ShipmentID, err)
+		http.Error(w, "Error deleting shipment", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("User %d purged shipment %d", shipment.UserID, shipment.ShipmentID)
+	recordAudit(r, shipment.UserID, auditShipmentPurge, fmt.Sprintf("shipment:%d", shipment.ShipmentID), outcomeSuccess)
+
+	http.Redirect(w, r, "/shipments/trash", http.StatusSeeOther)
+}
+
+// trashedShipment loads the shipment from the URL out of the logged in user's trash.
+// On failure it writes the response itself.
+func trashedShipment(w http.ResponseWriter, r *http.Request) (*models.Shipment, bool) {
+	if !IsAuthorized(r) {
+		http.Redirect(w, r, "/users/enter", http.StatusSeeOther)
+		return nil, false
+	}
+	userID := GetUserID(r)
+
+	shipmentID, err := strconv.Atoi(mux.Vars(r)["shipment_id"])
+	if err != nil {
+		http.Error(w, "Invalid shipment_id", http.StatusBadRequest)
+		return nil, false
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), time.Second*5)
+	defer cancel()
+
+	shipment, err := repo.GetTrashedShipmentByID(ctx, shipmentID)
+	if err != nil || shipment.UserID != userID {
+		log.Printf("User %d requested unknown trashed shipment %d: %v", userID, shipmentID, err)
+		http.Error(w, "Shipment not found", http.StatusNotFound)
+		return nil, false
+	}
+	return shipment, true
+}
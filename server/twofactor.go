@@ -0,0 +1,396 @@
+package main
+
+import (
+	"feklistova/config"
+	"feklistova/models"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"html/template"
+	"image/png"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/csrf"
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
+)
+
+const (
+	// totpPeriod is the length of a TOTP time step in seconds, totpSkew the number of steps accepted around the current one
+	totpPeriod = 30
+	totpSkew   = 1
+)
+
+type TwoFactorPage struct {
+	CSRFField         template.HTML
+	Enabled           bool
+	Required          bool
+	Secret            string
+	QRCode            template.URL
+	RecoveryCodes     []string
+	RecoveryCodesLeft int
+}
+
+// pendingTwoFactorUserID returns the user that passed the password check and still has to enter the second factor
+func pendingTwoFactorUserID(r *http.Request) (int, bool) {
+	session, _ := store.Get(r, "secret")
+	userID, ok := session.Values["2fa_pending_user_id"].(int)
+	if !ok {
+		return 0, false
+	}
+	until, ok := session.Values["2fa_pending_until"].(int64)
+	if !ok || time.Now().Unix() > until {
+		return 0, false
+	}
+	return userID, true
+}
+
+func pendingUserKey(r *http.Request) string {
+	userID, ok := pendingTwoFactorUserID(r)
+	if !ok {
+		return ""
+	}
+	return fmt.Sprintf("user:%d", userID)
+}
+
+// twoFactorUserID returns the logged in user even if the two-factor setup is not finished yet
+func twoFactorUserID(r *http.Request) (int, bool) {
+	session, _ := store.Get(r, "secret")
+	authenticated, _ := session.Values["authenticated"].(bool)
+	if !authenticated {
+		return 0, false
+	}
+	userID := GetUserID(r)
+	return userID, userID != -1
+}
+
+func LoginTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseMultipartForm(10 << 20)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Error parsing form data", http.StatusBadRequest)
+		return
+	}
+
+	userID, ok := pendingTwoFactorUserID(r)
+	if !ok {
+		http.Redirect(w, r, "/users/enter", http.StatusSeeOther)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), time.Second*5)
+	defer cancel()
+
+	user, err := repo.GetUserTOTP(ctx, userID)
+	if err != nil {
+		log.Printf("Failed to load user %d for two-factor check: %v", userID, err)
+		http.Error(w, "Invalid code", http.StatusUnauthorized)
+		return
+	}
+
+	code := r.FormValue("code")
+	if !validateTOTPCode(ctx, userID, user.TOTPSecret, code) {
+		used, err := repo.UseRecoveryCode(ctx, userID, hashRecoveryCode(code))
+		if err != nil {
+			log.Printf("Failed to check recovery code for user %d: %v", userID, err)
+		}
+		if !used {
+			recordAudit(r, userID, auditLoginTwoFactor, fmt.Sprintf("user:%d", userID), outcomeFailure)
+			http.Error(w, "Invalid code", http.StatusUnauthorized)
+			return
+		}
+		log.Printf("User %d logged in with a recovery code", userID)
+		recordAudit(r, userID, auditLoginTwoFactor, "recovery_code", outcomeSuccess)
+	}
+
+	recordLogin(r, userID)
+	startSession(w, r, userID, false)
+}
+
+func TwoFactorHandlerTmpl(w http.ResponseWriter, r *http.Request) {
+	userID, ok := twoFactorUserID(r)
+	if !ok {
+		http.Redirect(w, r, "/users/enter", http.StatusSeeOther)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), time.Second*5)
+	defer cancel()
+
+	user, err := repo.GetUserTOTP(ctx, userID)
+	if err != nil {
+		log.Printf("Failed to load user %d: %v", userID, err)
+		http.Error(w, "Unable to identify user", http.StatusInternalServerError)
+		return
+	}
+
+	page := TwoFactorPage{
+		CSRFField: csrf.TemplateField(r),
+		Enabled:   user.TOTPEnabled,
+		Required:  config.Require2FA,
+	}
+
+	if user.TOTPEnabled {
+		page.RecoveryCodesLeft, err = repo.CountRecoveryCodes(ctx, userID)
+		if err != nil {
+			log.Printf("Failed to count recovery codes for user %d: %v", userID, err)
+		}
+		renderTmpl(w, "web/twofactor.html", page)
+		return
+	}
+
+	// the secret waiting for confirmation is reused, so reloading the page does not invalidate
+	// the one already added to the authenticator app
+	opts := totp.GenerateOpts{
+		Issuer:      config.TOTPIssuer,
+		AccountName: user.Email,
+	}
+	if user.TOTPSecret != "" {
+		secret, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(user.TOTPSecret)
+		if err != nil {
+			log.Printf("Failed to decode pending TOTP secret of user %d, issuing a new one: %v", userID, err)
+		} else {
+			opts.Secret = secret
+		}
+	}
+	key, err := totp.Generate(opts)
+	if err != nil {
+		log.Printf("Failed to generate TOTP secret for user %d: %v", userID, err)
+		http.Error(w, "Error generating secret", http.StatusInternalServerError)
+		return
+	}
+	if len(opts.Secret) == 0 {
+		if err := repo.SetTOTPSecret(ctx, userID, key.Secret()); err != nil {
+			log.Printf("Failed to save TOTP secret for user %d: %v", userID, err)
+			http.Error(w, "Error generating secret", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	qrCode, err := qrCodeDataURL(key)
+	if err != nil {
+		log.Printf("Failed to render QR code for user %d: %v", userID, err)
+	}
+	page.Secret = key.Secret()
+	page.QRCode = qrCode
+	renderTmpl(w, "web/twofactor.html", page)
+}
+
+func TwoFactorEnableHandler(w http.ResponseWriter, r *http.Request) {
+	userID, user, ok := checkTwoFactorCode(w, r)
+	if !ok {
+		return
+	}
+	if user.TOTPEnabled {
+		http.Redirect(w, r, "/profile/2fa", http.StatusSeeOther)
+		return
+	}
+
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		log.Printf("Failed to generate recovery codes for user %d: %v", userID, err)
+		http.Error(w, "Error generating recovery codes", http.StatusInternalServerError)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), time.Second*5)
+	defer cancel()
+
+	if err := repo.EnableTOTP(ctx, userID, hashes); err != nil {
+		log.Printf("Failed to enable two-factor authentication for user %d: %v", userID, err)
+		http.Error(w, "Error enabling two-factor authentication", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("User %d enabled two-factor authentication", userID)
+	recordAudit(r, userID, auditTwoFactorEnable, fmt.Sprintf("user:%d", userID), outcomeSuccess)
+
+	session, _ := store.Get(r, "secret")
+	session.Values["2fa_setup_required"] = false
+	session.Save(r, w)
+
+	renderTmpl(w, "web/twofactor.html", TwoFactorPage{
+		CSRFField:         csrf.TemplateField(r),
+		Enabled:           true,
+		Required:          config.Require2FA,
+		RecoveryCodes:     codes,
+		RecoveryCodesLeft: len(codes),
+	})
+}
+
+func TwoFactorDisableHandler(w http.ResponseWriter, r *http.Request) {
+	if config.Require2FA {
+		http.Error(w, "Two-factor authentication is required on this deployment", http.StatusForbidden)
+		return
+	}
+
+	userID, user, ok := checkTwoFactorCode(w, r)
+	if !ok {
+		return
+	}
+	if !user.TOTPEnabled {
+		http.Redirect(w, r, "/profile/2fa", http.StatusSeeOther)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), time.Second*5)
+	defer cancel()
+
+	if err := repo.DisableTOTP(ctx, userID); err != nil {
+		log.Printf("Failed to disable two-factor authentication for user %d: %v", userID, err)
+		http.Error(w, "Error disabling two-factor authentication", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("User %d disabled two-factor authentication", userID)
+	recordAudit(r, userID, auditTwoFactorDisable, fmt.Sprintf("user:%d", userID), outcomeSuccess)
+
+	http.Redirect(w, r, "/profile", http.StatusSeeOther)
+}
+
+func TwoFactorRecoveryCodesHandler(w http.ResponseWriter, r *http.Request) {
+	userID, user, ok := checkTwoFactorCode(w, r)
+	if !ok {
+		return
+	}
+	if !user.TOTPEnabled {
+		http.Redirect(w, r, "/profile/2fa", http.StatusSeeOther)
+		return
+	}
+
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		log.Printf("Failed to generate recovery codes for user %d: %v", userID, err)
+		http.Error(w, "Error generating recovery codes", http.StatusInternalServerError)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), time.Second*5)
+	defer cancel()
+
+	if err := repo.ReplaceRecoveryCodes(ctx, userID, hashes); err != nil {
+		log.Printf("Failed to replace recovery codes for user %d: %v", userID, err)
+		http.Error(w, "Error generating recovery codes", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("User %d regenerated recovery codes", userID)
+	recordAudit(r, userID, auditRecoveryCodes, fmt.Sprintf("user:%d", userID), outcomeSuccess)
+
+	renderTmpl(w, "web/twofactor.html", TwoFactorPage{
+		CSRFField:         csrf.TemplateField(r),
+		Enabled:           true,
+		Required:          config.Require2FA,
+		RecoveryCodes:     codes,
+		RecoveryCodesLeft: len(codes),
+	})
+}
+
+// checkTwoFactorCode validates the TOTP code from the form against the secret stored for the logged in user.
+// On failure it writes the response itself.
+func checkTwoFactorCode(w http.ResponseWriter, r *http.Request) (int, *models.User, bool) {
+	userID, ok := twoFactorUserID(r)
+	if !ok {
+		http.Redirect(w, r, "/users/enter", http.StatusSeeOther)
+		return 0, nil, false
+	}
+
+	err := r.ParseMultipartForm(10 << 20)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Error parsing form data", http.StatusBadRequest)
+		return 0, nil, false
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), time.Second*5)
+	defer cancel()
+
+	user, err := repo.GetUserTOTP(ctx, userID)
+	if err != nil {
+		log.Printf("Failed to load user %d: %v", userID, err)
+		http.Error(w, "Unable to identify user", http.StatusInternalServerError)
+		return 0, nil, false
+	}
+
+	if user.TOTPSecret == "" || !validateTOTPCode(ctx, userID, user.TOTPSecret, r.FormValue("code")) {
+		recordAudit(r, userID, auditTwoFactorVerify, r.URL.Path, outcomeFailure)
+		http.Error(w, "Invalid code", http.StatusUnauthorized)
+		return 0, nil, false
+	}
+	return userID, user, true
+}
+
+// validateTOTPCode checks the code against the current time step and its neighbours, like totp.Validate does,
+// and accepts it only if no code of the same or a later step was accepted before, so an intercepted code can not be replayed.
+func validateTOTPCode(ctx context.Context, userID int, secret, code string) bool {
+	code = strings.TrimSpace(code)
+	now := time.Now().Unix() / totpPeriod
+	for step := now - totpSkew; step <= now+totpSkew; step++ {
+		expected, err := totp.GenerateCodeCustom(secret, time.Unix(step*totpPeriod, 0), totp.ValidateOpts{
+			Period:    totpPeriod,
+			Digits:    otp.DigitsSix,
+			Algorithm: otp.AlgorithmSHA1,
+		})
+		if err != nil {
+			log.Printf("Failed to generate TOTP code for user %d: %v", userID, err)
+			return false
+		}
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) != 1 {
+			continue
+		}
+
+		fresh, err := repo.UseTOTPStep(ctx, userID, step)
+		if err != nil {
+			log.Printf("Failed to record TOTP step for user %d: %v", userID, err)
+			return false
+		}
+		if !fresh {
+			log.Printf("Rejected a reused TOTP code of user %d", userID)
+		}
+		return fresh
+	}
+	return false
+}
+
+func qrCodeDataURL(key *otp.Key) (template.URL, error) {
+	img, err := key.Image(200, 200)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return "", err
+	}
+	return template.URL("data:image/png;base64," + base64.StdEncoding.EncodeToString(buf.Bytes())), nil
+}
+
+// generateRecoveryCodes returns the codes to show to the user once and their hashes to store
+func generateRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, 0, config.RecoveryCodesCount)
+	hashes := make([]string, 0, config.RecoveryCodesCount)
+	for i := 0; i < config.RecoveryCodesCount; i++ {
+		raw := make([]byte, 5)
+		if _, err := rand.Read(raw); err != nil {
+			return nil, nil, err
+		}
+		code := strings.ToLower(base32.StdEncoding.EncodeToString(raw))
+		code = code[:4] + "-" + code[4:]
+		codes = append(codes, code)
+		hashes = append(hashes, hashRecoveryCode(code))
+	}
+	return codes, hashes, nil
+}
+
+func hashRecoveryCode(code string) string {
+	normalized := strings.ToLower(strings.TrimSpace(code))
+	normalized = strings.ReplaceAll(normalized, "-", "")
+	normalized = strings.ReplaceAll(normalized, " ", "")
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:])
+}
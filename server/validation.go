@@ -0,0 +1,107 @@
+package main
+
+import (
+	"feklistova/config"
+	"feklistova/models"
+	"feklistova/python"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// parseValidationSettings reads how the model is evaluated. Model types without validation strategies get nil,
+// empty fields fall back to the split used before the settings existed.
+func parseValidationSettings(r *http.Request, modelType python.ModelType, targetColumn string) (*models.ValidationSettings, error) {
+	if len(modelType.Validation) == 0 {
+		return nil, nil
+	}
+
+	settings := &models.ValidationSettings{
+		Strategy: strings.TrimSpace(r.FormValue("validation_strategy")),
+		Seed:     config.DefaultValidationSeed,
+	}
+	if settings.Strategy == "" {
+		settings.Strategy = config.DefaultValidationStrategy
+	}
+	supported := false
+	for _, name := range modelType.Validation {
+		supported = supported || name == settings.Strategy
+	}
+	if !supported {
+		return nil, fmt.Errorf("validation strategy %s is not supported for model type %s", settings.Strategy, modelType.Name)
+	}
+
+	if value := strings.TrimSpace(r.FormValue("validation_seed")); value != "" {
+		seed, err := strconv.Atoi(value)
+		if err != nil || seed < 0 {
+			return nil, fmt.Errorf("seed must be a non-negative integer")
+		}
+		settings.Seed = seed
+	}
+
+	strategy, _ := python.LookupValidationStrategy(settings.Strategy)
+	if !strategy.Folds {
+		settings.TestSize = config.DefaultTestSize
+		if value := strings.TrimSpace(r.FormValue("test_size")); value != "" {
+			testSize, err := strconv.ParseFloat(value, 64)
+			if err != nil || testSize < config.MinTestSize || testSize > config.MaxTestSize {
+				return nil, fmt.Errorf("test size must be between %g and %g", config.MinTestSize, config.MaxTestSize)
+			}
+			settings.TestSize = testSize
+		}
+		return settings, nil
+	}
+
+	settings.Folds = config.DefaultValidationFolds
+	if value := strings.TrimSpace(r.FormValue("folds")); value != "" {
+		folds, err := strconv.Atoi(value)
+		if err != nil || folds < 2 || folds > config.MaxValidationFolds {
+			return nil, fmt.Errorf("folds must be between 2 and %d", config.MaxValidationFolds)
+		}
+		settings.Folds = folds
+	}
+
+	switch settings.Strategy {
+	case "group_kfold":
+		settings.GroupColumn = strings.TrimSpace(r.FormValue("group_column"))
+		if settings.GroupColumn == "" {
+			return nil, fmt.Errorf("group column must not be empty")
+		}
+		if settings.GroupColumn == targetColumn {
+			return nil, fmt.Errorf("group column must differ from the target column")
+		}
+	case "time_series":
+		// without a time column the rows are taken in the order of the file
+		settings.TimeColumn = strings.TrimSpace(r.FormValue("validation_time_column"))
+		if settings.TimeColumn != "" && settings.TimeColumn == targetColumn {
+			return nil, fmt.Errorf("time column must differ from the target column")
+		}
+	}
+	return settings, nil
+}
+
+// validationFormValues returns the settings the training form starts with: the settings of a cloned run
+// or the defaults, fields the strategy does not use keep their default values
+func validationFormValues(settings *models.ValidationSettings) models.ValidationSettings {
+	values := models.ValidationSettings{
+		Strategy: config.DefaultValidationStrategy,
+		TestSize: config.DefaultTestSize,
+		Folds:    config.DefaultValidationFolds,
+		Seed:     config.DefaultValidationSeed,
+	}
+	if settings == nil {
+		return values
+	}
+	values.Strategy = settings.Strategy
+	values.Seed = settings.Seed
+	values.GroupColumn = settings.GroupColumn
+	values.TimeColumn = settings.TimeColumn
+	if settings.TestSize > 0 {
+		values.TestSize = settings.TestSize
+	}
+	if settings.Folds > 0 {
+		values.Folds = settings.Folds
+	}
+	return values
+}
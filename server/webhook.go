@@ -0,0 +1,384 @@
+package main
+
+import (
+	"feklistova/config"
+	"feklistova/models"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// События, на которые можно подписать вебхук
+const (
+	eventShipmentAccepted = "shipment.accepted"
+	eventShipmentStarted  = "shipment.started"
+	eventShipmentFinished = "shipment.finished"
+	eventShipmentFailed   = "shipment.failed"
+	eventShipmentDenied   = "shipment.denied"
+	eventModelPromoted    = "model.promoted"
+)
+
+var webhookEvents = []string{
+	eventShipmentAccepted,
+	eventShipmentStarted,
+	eventShipmentFinished,
+	eventShipmentFailed,
+	eventShipmentDenied,
+	eventModelPromoted,
+}
+
+// shipmentStatusEvents maps the shipment statuses to the events sent when a shipment gets them
+var shipmentStatusEvents = map[string]string{
+	"accepted":    eventShipmentAccepted,
+	"in progress": eventShipmentStarted,
+	"finished":    eventShipmentFinished,
+	"failed":      eventShipmentFailed,
+	"denied":      eventShipmentDenied,
+}
+
+// Статусы доставок вебхуков
+const (
+	deliveryPending   = "pending"
+	deliveryDelivered = "delivered"
+	deliveryFailed    = "failed"
+)
+
+// webhookPayload is the JSON body of every delivery
+type webhookPayload struct {
+	Event      string      `json:"event"`
+	OccurredAt time.Time   `json:"occurred_at"`
+	Data       interface{} `json:"data"`
+}
+
+// shipmentEventData describes the shipment in shipment.* events, metrics are set for finished shipments
+type shipmentEventData struct {
+	Shipment *models.Shipment   `json:"shipment"`
+	Metrics  map[string]float64 `json:"metrics,omitempty"`
+}
+
+// modelEventData describes the promoted model version in model.promoted events
+type modelEventData struct {
+	Model   *models.RegisteredModel `json:"model"`
+	Version *models.ModelVersion    `json:"version"`
+}
+
+// webhookClient sends deliveries. Unless config.WebhookAllowPrivateNetworks is set, it refuses to
+// connect to loopback and private addresses, so webhooks can't be used to reach internal services.
+var webhookClient = &http.Client{
+	Timeout: config.WebhookTimeout,
+	Transport: &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout: config.WebhookTimeout,
+			Control: checkWebhookAddress,
+		}).DialContext,
+	},
+}
+
+func WebhookCreateHandler(w http.ResponseWriter, r *http.Request) {
+	project, ok := userProject(w, r)
+	if !ok {
+		return
+	}
+
+	if err := r.ParseMultipartForm(10 << 20); err != nil {
+		log.Println(err)
+		http.Error(w, "Error parsing form data", http.StatusBadRequest)
+		return
+	}
+
+	webhookURL, err := url.Parse(r.FormValue("url"))
+	if err != nil || (webhookURL.Scheme != "http" && webhookURL.Scheme != "https") || webhookURL.Host == "" {
+		http.Error(w, "Webhook URL must be an absolute http or https URL", http.StatusBadRequest)
+		return
+	}
+	var events []string
+	for _, event := range r.Form["events"] {
+		if !validWebhookEvent(event) {
+			http.Error(w, "Unknown event: "+event, http.StatusBadRequest)
+			return
+		}
+		events = append(events, event)
+	}
+	if len(events) == 0 {
+		http.Error(w, "Choose at least one event", http.StatusBadRequest)
+		return
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		log.Printf("Failed to generate webhook secret: %v", err)
+		http.Error(w, "Error creating webhook", http.StatusInternalServerError)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), time.Second*5)
+	defer cancel()
+
+	webhook := &models.Webhook{
+		UserID:    project.UserID,
+		ProjectID: project.ProjectID,
+		URL:       webhookURL.String(),
+		Secret:    hex.EncodeToString(secret),
+		Events:    events,
+		Enabled:   true,
+		CreatedAt: time.Now(),
+	}
+	if err := repo.CreateWebhook(ctx, webhook); err != nil {
+		log.Printf("Failed to create webhook in project %d: %v", project.ProjectID, err)
+		http.Error(w, "Error creating webhook", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("User %d created webhook %d in project %d", project.UserID, webhook.WebhookID, project.ProjectID)
+
+	http.Redirect(w, r, fmt.Sprintf("/projects/%d", project.ProjectID), http.StatusSeeOther)
+}
+
+// WebhookToggleHandler pauses or resumes the webhook
+func WebhookToggleHandler(w http.ResponseWriter, r *http.Request) {
+	webhook, ok := userWebhook(w, r)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), time.Second*5)
+	defer cancel()
+
+	if err := repo.UpdateWebhookEnabled(ctx, webhook.WebhookID, !webhook.Enabled); err != nil {
+		log.Printf("Failed to toggle webhook %d: %v", webhook.WebhookID, err)
+		http.Error(w, "Error updating webhook", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("User %d set webhook %d enabled=%t", webhook.UserID, webhook.WebhookID, !webhook.Enabled)
+
+	http.Redirect(w, r, fmt.Sprintf("/projects/%d", webhook.ProjectID), http.StatusSeeOther)
+}
+
+func WebhookDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	webhook, ok := userWebhook(w, r)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), time.Second*5)
+	defer cancel()
+
+	if err := repo.DeleteWebhook(ctx, webhook.WebhookID); err != nil {
+		log.Printf("Failed to delete webhook %d: %v", webhook.WebhookID, err)
+		http.Error(w, "Error deleting webhook", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("User %d deleted webhook %d", webhook.UserID, webhook.WebhookID)
+
+	http.Redirect(w, r, fmt.Sprintf("/projects/%d", webhook.ProjectID), http.StatusSeeOther)
+}
+
+// userWebhook loads the webhook from the URL and checks that it belongs to the logged in user.
+// On failure it writes the response itself.
+func userWebhook(w http.ResponseWriter, r *http.Request) (*models.Webhook, bool) {
+	if !IsAuthorized(r) {
+		http.Redirect(w, r, "/users/enter", http.StatusSeeOther)
+		return nil, false
+	}
+	userID := GetUserID(r)
+
+	webhookID, err := strconv.Atoi(mux.Vars(r)["webhook_id"])
+	if err != nil {
+		http.Error(w, "Invalid webhook_id", http.StatusBadRequest)
+		return nil, false
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), time.Second*5)
+	defer cancel()
+
+	webhook, err := repo.GetWebhookByID(ctx, webhookID)
+	if err != nil || webhook.UserID != userID {
+		log.Printf("User %d requested unknown webhook %d: %v", userID, webhookID, err)
+		http.Error(w, "Webhook not found", http.StatusNotFound)
+		return nil, false
+	}
+	return webhook, true
+}
+
+func validWebhookEvent(event string) bool {
+	for _, known := range webhookEvents {
+		if event == known {
+			return true
+		}
+	}
+	return false
+}
+
+// emitShipmentEvent queues the event matching the current status of the shipment for the project webhooks
+func emitShipmentEvent(ctx context.Context, shipment *models.Shipment) {
+	event, ok := shipmentStatusEvents[shipment.Status]
+	if !ok || shipment.ProjectID == 0 {
+		return
+	}
+	data := shipmentEventData{Shipment: shipment}
+	if shipment.Status == "finished" {
+		metrics, err := repo.GetMetricsByShipmentID(ctx, shipment.ShipmentID)
+		if err != nil {
+			log.Printf("Failed to load metrics of shipment %d for webhooks: %v", shipment.ShipmentID, err)
+		}
+		data.Metrics = metrics
+	}
+	emitEvent(ctx, shipment.ProjectID, event, data)
+}
+
+// emitEvent queues the event for every enabled webhook of the project subscribed to it.
+// Errors are only logged, webhooks never break the action that caused the event.
+func emitEvent(ctx context.Context, projectID int, event string, data interface{}) {
+	webhooks, err := repo.GetWebhooksForEvent(ctx, projectID, event)
+	if err != nil {
+		log.Printf("Failed to get webhooks of project %d for %s: %v", projectID, event, err)
+		return
+	}
+	if len(webhooks) == 0 {
+		return
+	}
+
+	now := time.Now()
+	payload, err := json.Marshal(webhookPayload{Event: event, OccurredAt: now, Data: data})
+	if err != nil {
+		log.Printf("Failed to encode %s event of project %d: %v", event, projectID, err)
+		return
+	}
+	for _, webhook := range webhooks {
+		delivery := &models.WebhookDelivery{
+			WebhookID:     webhook.WebhookID,
+			Event:         event,
+			Payload:       string(payload),
+			Status:        deliveryPending,
+			NextAttemptAt: now,
+			CreatedAt:     now,
+		}
+		if err := repo.CreateWebhookDelivery(ctx, delivery); err != nil {
+			log.Printf("Failed to queue %s event for webhook %d: %v", event, webhook.WebhookID, err)
+		}
+	}
+}
+
+// deliverDueWebhooks sends the queued events whose attempt time has come
+func deliverDueWebhooks(ctx context.Context) {
+	ctxClaim, cancelClaim := context.WithTimeout(ctx, time.Second*5)
+	defer cancelClaim()
+
+	// the lease covers the longest attempt, so a delivery is not claimed twice while it is being sent
+	deliveries, err := repo.ClaimDueWebhookDeliveries(ctxClaim, time.Now(), config.WebhookTimeout*2, config.WebhookBatchSize)
+	if err != nil {
+		log.Printf("Failed to claim webhook deliveries: %v", err)
+		return
+	}
+	for _, delivery := range deliveries {
+		go deliverWebhook(ctx, delivery)
+	}
+}
+
+// deliverWebhook makes one attempt to send the event and saves the result.
+// Failed attempts are retried with exponential backoff up to config.WebhookMaxAttempts times.
+func deliverWebhook(ctx context.Context, delivery models.WebhookDelivery) {
+	delivery.Attempts++
+	delivery.ResponseCode, delivery.Error = 0, ""
+
+	err := sendWebhook(ctx, &delivery)
+	now := time.Now()
+	switch {
+	case err == nil:
+		delivery.Status = deliveryDelivered
+		delivery.DeliveredAt = &now
+	case delivery.Attempts >= config.WebhookMaxAttempts:
+		delivery.Status = deliveryFailed
+		delivery.Error = err.Error()
+		log.Printf("Webhook delivery %d failed after %d attempts: %v", delivery.DeliveryID, delivery.Attempts, err)
+	default:
+		delivery.Error = err.Error()
+		delivery.NextAttemptAt = now.Add(webhookBackoff(delivery.Attempts))
+	}
+
+	ctxSave, cancelSave := context.WithTimeout(ctx, time.Second*5)
+	defer cancelSave()
+
+	if err := repo.UpdateWebhookDelivery(ctxSave, &delivery); err != nil {
+		log.Printf("Failed to save webhook delivery %d: %v", delivery.DeliveryID, err)
+	}
+}
+
+// sendWebhook posts the signed payload, any response other than 2xx is an error
+func sendWebhook(ctx context.Context, delivery *models.WebhookDelivery) error {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.URL, bytes.NewBufferString(delivery.Payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "LinAutoML-Webhook")
+	req.Header.Set("X-Webhook-Event", delivery.Event)
+	req.Header.Set("X-Webhook-Delivery", strconv.Itoa(delivery.DeliveryID))
+	req.Header.Set("X-Webhook-Timestamp", timestamp)
+	req.Header.Set("X-Webhook-Signature", "sha256="+signWebhook(delivery.Secret, timestamp, delivery.Payload))
+
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	delivery.ResponseCode = resp.StatusCode
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected response status %s", resp.Status)
+	}
+	return nil
+}
+
+// signWebhook returns the hex HMAC-SHA256 of "<timestamp>.<payload>" with the webhook secret
+func signWebhook(secret, timestamp, payload string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "." + payload))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// webhookBackoff returns the delay before the next attempt after the given number of failed attempts
+func webhookBackoff(attempts int) time.Duration {
+	backoff := config.WebhookBaseBackoff
+	for i := 1; i < attempts && backoff < config.WebhookMaxBackoff; i++ {
+		backoff *= 2
+	}
+	return min(backoff, config.WebhookMaxBackoff)
+}
+
+// checkWebhookAddress is the dialer hook that rejects connections to internal addresses
+func checkWebhookAddress(network, address string, _ syscall.RawConn) error {
+	if config.WebhookAllowPrivateNetworks {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return fmt.Errorf("invalid webhook address %s", address)
+	}
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsUnspecified() || ip.IsMulticast() {
+		return fmt.Errorf("webhook address %s is not public", host)
+	}
+	return nil
+}